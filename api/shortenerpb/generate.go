@@ -0,0 +1,4 @@
+// Package shortenerpb holds gRPC API of the service generated from shortener.proto
+package shortenerpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative shortener.proto
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Yandex-Praktikum/go-profilable-shortener/models"
+)
+
+func Test_parseMix(t *testing.T) {
+	m, err := parseMix("shorten=2, redirect=10,list,delete=0")
+	require.NoError(t, err)
+	assert.Equal(t, 13, m.total)
+	assert.Equal(t, []string{"list", "redirect", "shorten"}, m.names())
+
+	for _, s := range []string{"", "delete=0", "unknown=1", "shorten=-1", "shorten=x"} {
+		_, err := parseMix(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func Test_percentile(t *testing.T) {
+	sorted := make([]time.Duration, 100)
+	for n := range sorted {
+		sorted[n] = time.Duration(n+1) * time.Millisecond
+	}
+	assert.Equal(t, 50*time.Millisecond, percentile(sorted, 50))
+	assert.Equal(t, 99*time.Millisecond, percentile(sorted, 99))
+	assert.Equal(t, time.Millisecond, percentile(sorted[:1], 99))
+}
+
+// fakeShortener implements API surface used by loadgen, links are kept per auth cookie
+type fakeShortener struct {
+	mu      sync.Mutex
+	links   map[string]string
+	owners  map[string]string
+	seq     int
+	users   int
+	gzipped int
+}
+
+func newFakeShortener() *fakeShortener {
+	return &fakeShortener{links: make(map[string]string), owners: make(map[string]string)}
+}
+
+func (f *fakeShortener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	uid := ""
+	if c, err := r.Cookie("auth"); err == nil {
+		uid = c.Value
+	} else {
+		f.users++
+		uid = fmt.Sprintf("user%d", f.users)
+		http.SetCookie(w, &http.Cookie{Name: "auth", Value: uid, Path: "/"})
+	}
+
+	body := r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		f.gzipped++
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body = zr
+	}
+
+	save := func(original string) string {
+		f.seq++
+		id := fmt.Sprintf("%x", f.seq)
+		f.links[id] = original
+		f.owners[id] = uid
+		return "http://" + r.Host + "/" + id
+	}
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/":
+		b, _ := io.ReadAll(body)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = io.WriteString(w, save(string(b)))
+	case r.Method == http.MethodPost && r.URL.Path == "/api/shorten":
+		var req models.ShortenRequest
+		_ = json.NewDecoder(body).Decode(&req)
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(models.ShortenResponse{Result: save(req.URL)})
+	case r.Method == http.MethodPost && r.URL.Path == "/api/shorten/batch":
+		var req []models.BatchShortenRequest
+		_ = json.NewDecoder(body).Decode(&req)
+		resp := make([]models.BatchShortenResponse, 0, len(req))
+		for _, item := range req {
+			resp = append(resp, models.BatchShortenResponse{CorrelationID: item.CorrelationID, ShortURL: save(item.OriginalURL)})
+		}
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(resp)
+	case r.Method == http.MethodGet && r.URL.Path == "/api/user/urls":
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodDelete && r.URL.Path == "/api/user/urls":
+		var ids []string
+		_ = json.NewDecoder(body).Decode(&ids)
+		for _, id := range ids {
+			if f.owners[id] == uid {
+				delete(f.links, id)
+			}
+		}
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodGet:
+		original, ok := f.links[strings.TrimPrefix(r.URL.Path, "/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.Redirect(w, r, original, http.StatusTemporaryRedirect)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func Test_run(t *testing.T) {
+	for _, mode := range []string{modeClosed, modeRate} {
+		t.Run(mode, func(t *testing.T) {
+			fake := newFakeShortener()
+			srv := httptest.NewServer(fake)
+			defer srv.Close()
+
+			opts, err := parseOptions([]string{
+				"-target", srv.URL,
+				"-mode", mode,
+				"-users", "5",
+				"-duration", "300ms",
+				"-rate", "200",
+				"-gzip",
+			}, io.Discard)
+			require.NoError(t, err)
+
+			report, err := run(context.Background(), opts)
+			require.NoError(t, err)
+
+			assert.Equal(t, mode, report.Mode)
+			assert.Positive(t, report.Total.Requests)
+			assert.Zero(t, report.Total.Errors, report.Total.ErrorKinds)
+			assert.Positive(t, report.Total.Throughput)
+			assert.LessOrEqual(t, report.Total.Latency.P50, report.Total.Latency.Max)
+
+			fake.mu.Lock()
+			// every simulated user got its own cookie and kept it
+			assert.Equal(t, 5, fake.users)
+			assert.Positive(t, fake.gzipped)
+			fake.mu.Unlock()
+
+			var text, js bytes.Buffer
+			require.NoError(t, report.writeText(&text))
+			assert.Contains(t, text.String(), "redirect")
+			require.NoError(t, report.writeJSON(&js))
+			var decoded Report
+			require.NoError(t, json.Unmarshal(js.Bytes(), &decoded))
+			assert.Equal(t, report.Total.Requests, decoded.Total.Requests)
+		})
+	}
+}
+
+func Test_errorKind(t *testing.T) {
+	fake := newFakeShortener()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/user/urls" {
+			http.Error(w, "boom", http.StatusInternalServerError)
+			return
+		}
+		fake.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	opts, err := parseOptions([]string{"-target", srv.URL, "-users", "1", "-duration", "100ms", "-mix", "list=1,redirect=1"}, io.Discard)
+	require.NoError(t, err)
+	report, err := run(context.Background(), opts)
+	require.NoError(t, err)
+
+	require.Len(t, report.Ops, 2)
+	list := report.Ops[0]
+	assert.Equal(t, "list", list.Name)
+	assert.Equal(t, list.Requests, list.Errors)
+	assert.Equal(t, map[string]int{"status_500": list.Requests}, list.ErrorKinds)
+	assert.Zero(t, report.Ops[1].Errors)
+}
@@ -0,0 +1,276 @@
+// Command loadgen drives running shortener with configurable mix of requests
+// of many simulated users and reports throughput, latencies and errors
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	modeClosed = "closed"
+	modeRate   = "rate"
+)
+
+const defaultMix = "shorten=2,shorten_api=2,batch=1,redirect=10,list=1,delete=1"
+
+type options struct {
+	target      string
+	users       int
+	duration    time.Duration
+	mode        string
+	rate        float64
+	concurrency int
+	maxInflight int
+	mix         mix
+	batchSize   int
+	gzip        bool
+	timeout     time.Duration
+	seed        int
+	jsonPath    string
+}
+
+func parseOptions(args []string, output io.Writer) (options, error) {
+	var (
+		opts   options
+		mixStr string
+	)
+	fs := flag.NewFlagSet("loadgen", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.StringVar(&opts.target, "target", "http://localhost:8080", "base URL of shortener")
+	fs.IntVar(&opts.users, "users", 50, "number of simulated users, each one has its own auth cookie")
+	fs.DurationVar(&opts.duration, "duration", 30*time.Second, "duration of measurement")
+	fs.StringVar(&opts.mode, "mode", modeClosed, "load mode: closed (workers send requests back to back) or rate (fixed request rate)")
+	fs.Float64Var(&opts.rate, "rate", 100, "requests per second in rate mode")
+	fs.IntVar(&opts.concurrency, "concurrency", 0, "number of workers in closed mode, defaults to number of users")
+	fs.IntVar(&opts.maxInflight, "max-inflight", 1000, "limit of concurrent requests in rate mode, requests over it are dropped")
+	fs.StringVar(&mixStr, "mix", defaultMix, "comma separated weights of operations: "+strings.Join(opNames(), ", "))
+	fs.IntVar(&opts.batchSize, "batch-size", 10, "number of URLs in batch request")
+	fs.BoolVar(&opts.gzip, "gzip", false, "send gzip compressed request bodies")
+	fs.DurationVar(&opts.timeout, "timeout", 5*time.Second, "timeout of single request")
+	fs.IntVar(&opts.seed, "seed", 5, "number of links every user shortens before measurement")
+	fs.StringVar(&opts.jsonPath, "json", "", "write JSON report to file, - is for stdout")
+	if err := fs.Parse(args); err != nil {
+		return options{}, err
+	}
+
+	opts.target = strings.TrimSuffix(opts.target, "/")
+	if opts.concurrency == 0 {
+		opts.concurrency = opts.users
+	}
+	switch {
+	case opts.mode != modeClosed && opts.mode != modeRate:
+		return options{}, fmt.Errorf("unknown mode %q", opts.mode)
+	case opts.users <= 0:
+		return options{}, errors.New("users must be positive")
+	case opts.duration <= 0:
+		return options{}, errors.New("duration must be positive")
+	case opts.mode == modeRate && opts.rate <= 0:
+		return options{}, errors.New("rate must be positive")
+	case opts.concurrency < 0:
+		return options{}, errors.New("concurrency must be positive")
+	case opts.maxInflight <= 0:
+		return options{}, errors.New("max-inflight must be positive")
+	case opts.batchSize <= 0:
+		return options{}, errors.New("batch-size must be positive")
+	case opts.seed < 0:
+		return options{}, errors.New("seed must not be negative")
+	}
+
+	m, err := parseMix(mixStr)
+	if err != nil {
+		return options{}, fmt.Errorf("cannot parse mix: %w", err)
+	}
+	opts.mix = m
+	return opts, nil
+}
+
+func opNames() []string {
+	names := make([]string, 0, len(ops))
+	for _, o := range ops {
+		names = append(names, o.name)
+	}
+	return names
+}
+
+func main() {
+	opts, err := parseOptions(os.Args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("cannot parse options: %s", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("%s load of %s: %d users, mix %s", opts.mode, opts.target, opts.users, strings.Join(opts.mix.names(), ","))
+	report, err := run(ctx, opts)
+	if err != nil {
+		log.Fatalf("cannot run load: %s", err)
+	}
+
+	if err := report.writeText(os.Stdout); err != nil {
+		log.Fatalf("cannot write report: %s", err)
+	}
+	if err := writeJSONReport(opts.jsonPath, report); err != nil {
+		log.Fatalf("cannot write JSON report: %s", err)
+	}
+}
+
+func writeJSONReport(path string, r Report) error {
+	switch path {
+	case "":
+		return nil
+	case "-":
+		return r.writeJSON(os.Stdout)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := r.writeJSON(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// run seeds users and applies load until duration passes or ctx is done
+func run(ctx context.Context, opts options) (Report, error) {
+	g := &generator{target: opts.target, gzip: opts.gzip, batchSize: opts.batchSize}
+	users := make([]*user, opts.users)
+	for n := range users {
+		users[n] = newUser(opts.timeout)
+	}
+	if err := seedUsers(ctx, g, users, opts.seed); err != nil {
+		return Report{}, err
+	}
+
+	c := newCollector()
+	loadCtx, cancel := context.WithTimeout(ctx, opts.duration)
+	defer cancel()
+
+	start := time.Now()
+	switch opts.mode {
+	case modeClosed:
+		runClosed(loadCtx, g, users, opts.mix, opts.concurrency, c)
+	case modeRate:
+		runRate(loadCtx, g, users, opts.mix, opts.rate, opts.maxInflight, c)
+	}
+
+	r := c.report(time.Since(start))
+	r.Mode = opts.mode
+	r.Users = opts.users
+	r.Gzip = opts.gzip
+	return r, nil
+}
+
+// seedUsers gives every user links to expand and delete, seeding is not measured
+func seedUsers(ctx context.Context, g *generator, users []*user, links int) error {
+	shorten, _ := lookupOp("shorten")
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for _, u := range users {
+		wg.Add(1)
+		go func(u *user) {
+			defer wg.Done()
+			for n := 0; n < links; n++ {
+				res := shorten.do(g, ctx, u)
+				if kind := errorKind(shorten, res); kind != "" {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = fmt.Errorf("cannot seed links: %s (%v)", kind, res.err)
+					}
+					mu.Unlock()
+					return
+				}
+			}
+		}(u)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// step performs one op of mix on behalf of u
+func step(ctx context.Context, g *generator, u *user, m mix, c *collector) {
+	o := m.pick()
+	if o.needsID && !u.hasIDs() {
+		o, _ = lookupOp("shorten")
+	}
+	res := o.do(g, ctx, u)
+	// links may be taken by concurrent delete of the same user
+	if errors.Is(res.err, errNoID) {
+		return
+	}
+	// requests cut by the end of run are not failures of target
+	if ctx.Err() != nil && res.err != nil {
+		return
+	}
+	c.record(o, res)
+}
+
+// runClosed keeps concurrency workers sending requests back to back
+func runClosed(ctx context.Context, g *generator, users []*user, m mix, concurrency int, c *collector) {
+	var wg sync.WaitGroup
+	for n := 0; n < concurrency; n++ {
+		wg.Add(1)
+		go func(u *user) {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				step(ctx, g, u, m, c)
+			}
+		}(users[n%len(users)])
+	}
+	wg.Wait()
+}
+
+// runRate starts requests at fixed rate regardless of responses, so slow target
+// does not lower offered load; requests over maxInflight are dropped
+func runRate(ctx context.Context, g *generator, users []*user, m mix, rate float64, maxInflight int, c *collector) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	inflight := make(chan struct{}, maxInflight)
+	interval := time.Duration(float64(time.Second) / rate)
+	start := time.Now()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for n := 0; ; n++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		// schedule is kept by start time, so timer delays do not accumulate
+		timer.Reset(time.Until(start.Add(time.Duration(n+1) * interval)))
+
+		select {
+		case inflight <- struct{}{}:
+		default:
+			c.drop()
+			continue
+		}
+		wg.Add(1)
+		go func(u *user) {
+			defer wg.Done()
+			defer func() { <-inflight }()
+			step(ctx, g, u, m, c)
+		}(users[n%len(users)])
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// collector gathers results of every op
+type collector struct {
+	mu  sync.Mutex
+	ops map[string]*opStats
+	// dropped counts requests fixed rate mode could not start in time
+	dropped int
+}
+
+type opStats struct {
+	latencies []time.Duration
+	errors    map[string]int
+}
+
+func newCollector() *collector {
+	return &collector{ops: make(map[string]*opStats)}
+}
+
+func (c *collector) record(o op, res result) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := c.ops[o.name]
+	if s == nil {
+		s = &opStats{errors: make(map[string]int)}
+		c.ops[o.name] = s
+	}
+	s.latencies = append(s.latencies, res.latency)
+	if kind := errorKind(o, res); kind != "" {
+		s.errors[kind]++
+	}
+}
+
+func (c *collector) drop() {
+	c.mu.Lock()
+	c.dropped++
+	c.mu.Unlock()
+}
+
+// errorKind classifies failed request, it is empty for successful ones
+func errorKind(o op, res result) string {
+	if res.err != nil {
+		var netErr net.Error
+		switch {
+		case errors.Is(res.err, context.DeadlineExceeded), errors.As(res.err, &netErr) && netErr.Timeout():
+			return "timeout"
+		case res.status != 0:
+			return "bad_response"
+		default:
+			return "transport"
+		}
+	}
+	for _, status := range o.expected {
+		if res.status == status {
+			return ""
+		}
+	}
+	return "status_" + strconv.Itoa(res.status)
+}
+
+// Report is a summary of load run
+type Report struct {
+	Mode     string  `json:"mode"`
+	Duration float64 `json:"duration_seconds"`
+	Users    int     `json:"users"`
+	Gzip     bool    `json:"gzip"`
+	Dropped  int     `json:"dropped,omitempty"`
+
+	Total OpReport   `json:"total"`
+	Ops   []OpReport `json:"ops"`
+}
+
+// OpReport summarizes requests of single operation
+type OpReport struct {
+	Name       string         `json:"name"`
+	Requests   int            `json:"requests"`
+	Errors     int            `json:"errors"`
+	ErrorKinds map[string]int `json:"error_kinds,omitempty"`
+	Throughput float64        `json:"throughput_rps"`
+	Latency    LatencyReport  `json:"latency_ms"`
+}
+
+// LatencyReport holds latency distribution in milliseconds
+type LatencyReport struct {
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+// report summarizes collected results of run lasted elapsed
+func (c *collector) report(elapsed time.Duration) Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r := Report{Duration: elapsed.Seconds(), Dropped: c.dropped}
+
+	names := make([]string, 0, len(c.ops))
+	for name := range c.ops {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	total := &opStats{errors: make(map[string]int)}
+	for _, name := range names {
+		s := c.ops[name]
+		r.Ops = append(r.Ops, s.report(name, elapsed))
+
+		total.latencies = append(total.latencies, s.latencies...)
+		for kind, n := range s.errors {
+			total.errors[kind] += n
+		}
+	}
+	r.Total = total.report("total", elapsed)
+	return r
+}
+
+func (s *opStats) report(name string, elapsed time.Duration) OpReport {
+	r := OpReport{
+		Name:       name,
+		Requests:   len(s.latencies),
+		ErrorKinds: s.errors,
+	}
+	for _, n := range s.errors {
+		r.Errors += n
+	}
+	if elapsed > 0 {
+		r.Throughput = float64(r.Requests) / elapsed.Seconds()
+	}
+	if len(s.latencies) == 0 {
+		return r
+	}
+
+	sorted := make([]time.Duration, len(s.latencies))
+	copy(sorted, s.latencies)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var sum time.Duration
+	for _, d := range sorted {
+		sum += d
+	}
+	r.Latency = LatencyReport{
+		Mean: ms(sum / time.Duration(len(sorted))),
+		P50:  ms(percentile(sorted, 50)),
+		P90:  ms(percentile(sorted, 90)),
+		P99:  ms(percentile(sorted, 99)),
+		Max:  ms(sorted[len(sorted)-1]),
+	}
+	return r
+}
+
+// percentile uses nearest rank method on sorted durations
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func (r Report) writeJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+func (r Report) writeText(w io.Writer) error {
+	fmt.Fprintf(w, "mode %s, %d users, gzip %t, %.1fs\n", r.Mode, r.Users, r.Gzip, r.Duration)
+	if r.Dropped > 0 {
+		fmt.Fprintf(w, "dropped %d requests, target could not keep up with the rate\n", r.Dropped)
+	}
+	fmt.Fprintln(w)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "op\trequests\terrors\trps\tmean ms\tp50 ms\tp90 ms\tp99 ms\tmax ms\t")
+	for _, op := range append(r.Ops, r.Total) {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f\t%.2f\t%.2f\t%.2f\t%.2f\t%.2f\t\n",
+			op.Name, op.Requests, op.Errors, op.Throughput,
+			op.Latency.Mean, op.Latency.P50, op.Latency.P90, op.Latency.P99, op.Latency.Max)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(r.Total.ErrorKinds) == 0 {
+		return nil
+	}
+	fmt.Fprintln(w, "\nerrors:")
+	for _, op := range r.Ops {
+		kinds := make([]string, 0, len(op.ErrorKinds))
+		for kind := range op.ErrorKinds {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+		for _, kind := range kinds {
+			fmt.Fprintf(w, "  %s %s: %d\n", op.Name, kind, op.ErrorKinds[kind])
+		}
+	}
+	return nil
+}
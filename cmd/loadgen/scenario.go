@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/cookiejar"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Yandex-Praktikum/go-profilable-shortener/models"
+)
+
+// user is a simulated client keeping its own auth cookie and short IDs
+type user struct {
+	client *http.Client
+
+	mu  sync.Mutex
+	ids []string
+}
+
+func newUser(timeout time.Duration) *user {
+	// cookiejar.New never fails without public suffix list
+	jar, _ := cookiejar.New(nil)
+	return &user{
+		client: &http.Client{
+			Jar:     jar,
+			Timeout: timeout,
+			// redirects are measured themselves, targets are not visited
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+func (u *user) hasIDs() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return len(u.ids) > 0
+}
+
+func (u *user) addIDs(ids ...string) {
+	u.mu.Lock()
+	u.ids = append(u.ids, ids...)
+	u.mu.Unlock()
+}
+
+// randomID returns one of user IDs, empty if there are none
+func (u *user) randomID() string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if len(u.ids) == 0 {
+		return ""
+	}
+	return u.ids[rand.Intn(len(u.ids))]
+}
+
+// takeID removes random ID from user, so deleted links are not expanded later
+func (u *user) takeID() string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if len(u.ids) == 0 {
+		return ""
+	}
+	n := rand.Intn(len(u.ids))
+	id := u.ids[n]
+	u.ids[n] = u.ids[len(u.ids)-1]
+	u.ids = u.ids[:len(u.ids)-1]
+	return id
+}
+
+// errNoID is returned by ops which need user link when user has none
+var errNoID = errors.New("user has no links")
+
+// op is a kind of request generated against shortener
+type op struct {
+	name string
+	// expected are statuses of successful responses
+	expected []int
+	// needsID ops are replaced with shorten while user has no links
+	needsID bool
+	do      func(g *generator, ctx context.Context, u *user) result
+}
+
+// result is an outcome of single request
+type result struct {
+	status int
+	// latency is measured from sending request until response body is read
+	latency time.Duration
+	err     error
+}
+
+var ops = []op{
+	{name: "shorten", expected: []int{http.StatusCreated}, do: (*generator).shorten},
+	{name: "shorten_api", expected: []int{http.StatusCreated}, do: (*generator).shortenAPI},
+	{name: "batch", expected: []int{http.StatusCreated}, do: (*generator).batch},
+	{name: "redirect", expected: []int{http.StatusTemporaryRedirect}, needsID: true, do: (*generator).redirect},
+	{name: "list", expected: []int{http.StatusOK, http.StatusNoContent}, do: (*generator).list},
+	{name: "delete", expected: []int{http.StatusAccepted}, needsID: true, do: (*generator).delete},
+}
+
+func lookupOp(name string) (op, bool) {
+	for _, o := range ops {
+		if o.name == name {
+			return o, true
+		}
+	}
+	return op{}, false
+}
+
+// weightedOp is an op chosen with probability proportional to weight
+type weightedOp struct {
+	op
+	weight int
+}
+
+// mix picks ops at random according to their weights
+type mix struct {
+	ops   []weightedOp
+	total int
+}
+
+// parseMix parses comma separated name=weight pairs, e.g. "shorten=1,redirect=10"
+func parseMix(s string) (mix, error) {
+	var m mix
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, weightStr := pair, "1"
+		if i := strings.IndexByte(pair, '='); i >= 0 {
+			name, weightStr = pair[:i], pair[i+1:]
+		}
+		o, ok := lookupOp(name)
+		if !ok {
+			return mix{}, fmt.Errorf("unknown operation %q", name)
+		}
+		weight, err := strconv.Atoi(weightStr)
+		if err != nil || weight < 0 {
+			return mix{}, fmt.Errorf("bad weight of %s: %q", name, weightStr)
+		}
+		if weight == 0 {
+			continue
+		}
+		m.ops = append(m.ops, weightedOp{op: o, weight: weight})
+		m.total += weight
+	}
+	if m.total == 0 {
+		return mix{}, fmt.Errorf("no operations in mix %q", s)
+	}
+	return m, nil
+}
+
+func (m mix) pick() op {
+	n := rand.Intn(m.total)
+	for _, o := range m.ops {
+		if n < o.weight {
+			return o.op
+		}
+		n -= o.weight
+	}
+	return m.ops[len(m.ops)-1].op
+}
+
+// names returns names of mixed ops sorted
+func (m mix) names() []string {
+	names := make([]string, 0, len(m.ops))
+	for _, o := range m.ops {
+		names = append(names, o.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// generator issues requests of mix against target
+type generator struct {
+	target    string
+	gzip      bool
+	batchSize int
+	// seq makes every shortened URL unique
+	seq int64
+}
+
+func (g *generator) nextURL() string {
+	return fmt.Sprintf("https://loadgen.example.com/%d/%d", time.Now().UnixNano(), atomic.AddInt64(&g.seq, 1))
+}
+
+// send performs request, response body is decoded into dst if it is given
+func (g *generator) send(ctx context.Context, u *user, method, path string, body []byte, dst interface{}) result {
+	var rd io.Reader
+	if body != nil {
+		if g.gzip {
+			var buf bytes.Buffer
+			zw := gzip.NewWriter(&buf)
+			_, _ = zw.Write(body)
+			_ = zw.Close()
+			body = buf.Bytes()
+		}
+		rd = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, g.target+path, rd)
+	if err != nil {
+		return result{err: err}
+	}
+	if body != nil && g.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	start := time.Now()
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return result{latency: time.Since(start), err: err}
+	}
+	defer resp.Body.Close()
+
+	res := result{status: resp.StatusCode}
+	if dst != nil && resp.StatusCode < 300 {
+		if err := decode(resp.Body, dst); err != nil {
+			res.err = fmt.Errorf("cannot read response: %w", err)
+		}
+	}
+	// drain body so connection is reused
+	_, _ = io.Copy(io.Discard, resp.Body)
+	res.latency = time.Since(start)
+	return res
+}
+
+func decode(r io.Reader, dst interface{}) error {
+	if s, ok := dst.(*string); ok {
+		b, err := io.ReadAll(r)
+		*s = string(b)
+		return err
+	}
+	return json.NewDecoder(r).Decode(dst)
+}
+
+// shortID returns ID part of short URL
+func shortID(shortURL string) string {
+	return shortURL[strings.LastIndexByte(shortURL, '/')+1:]
+}
+
+func (g *generator) shorten(ctx context.Context, u *user) result {
+	var shortURL string
+	res := g.send(ctx, u, http.MethodPost, "/", []byte(g.nextURL()), &shortURL)
+	if res.err == nil && res.status == http.StatusCreated {
+		u.addIDs(shortID(shortURL))
+	}
+	return res
+}
+
+func (g *generator) shortenAPI(ctx context.Context, u *user) result {
+	body, _ := json.Marshal(models.ShortenRequest{URL: g.nextURL()})
+	var resp models.ShortenResponse
+	res := g.send(ctx, u, http.MethodPost, "/api/shorten", body, &resp)
+	if res.err == nil && res.status == http.StatusCreated {
+		u.addIDs(shortID(resp.Result))
+	}
+	return res
+}
+
+func (g *generator) batch(ctx context.Context, u *user) result {
+	req := make([]models.BatchShortenRequest, g.batchSize)
+	for n := range req {
+		req[n] = models.BatchShortenRequest{CorrelationID: strconv.Itoa(n), OriginalURL: g.nextURL()}
+	}
+	body, _ := json.Marshal(req)
+
+	var resp []models.BatchShortenResponse
+	res := g.send(ctx, u, http.MethodPost, "/api/shorten/batch", body, &resp)
+	if res.err == nil && res.status == http.StatusCreated {
+		for _, item := range resp {
+			u.addIDs(shortID(item.ShortURL))
+		}
+	}
+	return res
+}
+
+// redirect expands one of user links
+func (g *generator) redirect(ctx context.Context, u *user) result {
+	id := u.randomID()
+	if id == "" {
+		return result{err: errNoID}
+	}
+	return g.send(ctx, u, http.MethodGet, "/"+id, nil, nil)
+}
+
+func (g *generator) list(ctx context.Context, u *user) result {
+	return g.send(ctx, u, http.MethodGet, "/api/user/urls", nil, nil)
+}
+
+// delete removes one of user links
+func (g *generator) delete(ctx context.Context, u *user) result {
+	id := u.takeID()
+	if id == "" {
+		return result{err: errNoID}
+	}
+	body, _ := json.Marshal([]string{id})
+	return g.send(ctx, u, http.MethodDelete, "/api/user/urls", body, nil)
+}
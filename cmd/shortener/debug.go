@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/config"
+)
+
+// debugTokenHeader carries token which lets request in profiling endpoints
+const debugTokenHeader = "X-Debug-Token"
+
+// debugGuard lets in requests coming from trusted subnet or bearing debug token
+type debugGuard struct {
+	subnet *net.IPNet
+	token  string
+}
+
+// newDebugGuard returns nil if neither trusted subnet nor token are configured
+func newDebugGuard(cfg *config.Config) *debugGuard {
+	if cfg.DebugToken == "" && cfg.DebugTrustedSubnet == "" {
+		return nil
+	}
+	g := &debugGuard{token: cfg.DebugToken}
+	if cfg.DebugTrustedSubnet != "" {
+		// subnet is validated by config
+		_, g.subnet, _ = net.ParseCIDR(cfg.DebugTrustedSubnet)
+	}
+	return g
+}
+
+func (g *debugGuard) allowed(r *http.Request) bool {
+	if g.token != "" {
+		token := r.Header.Get(debugTokenHeader)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(g.token)) == 1 {
+			return true
+		}
+	}
+	if g.subnet != nil {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		if ip := net.ParseIP(host); ip != nil && g.subnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *debugGuard) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !g.allowed(r) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newDebugRouter serves pprof profiles, runtime trace and goroutine dump,
+// paths are relative to the prefix router is mounted at
+func newDebugRouter(g *debugGuard) chi.Router {
+	r := chi.NewRouter()
+	r.Use(g.middleware)
+
+	r.Get("/cmdline", pprof.Cmdline)
+	r.Get("/profile", pprof.Profile)
+	r.Get("/symbol", pprof.Symbol)
+	r.Post("/symbol", pprof.Symbol)
+	r.Get("/trace", pprof.Trace)
+	r.Get("/goroutines", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		q.Set("debug", "2")
+		r.URL.RawQuery = q.Encode()
+		pprof.Handler("goroutine").ServeHTTP(w, r)
+	})
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		// index resolves profile name by standard prefix, its links are relative
+		r.URL.Path = "/debug/pprof/"
+		pprof.Index(w, r)
+	})
+	r.Get("/{profile}", func(w http.ResponseWriter, r *http.Request) {
+		pprof.Handler(chi.URLParam(r, "profile")).ServeHTTP(w, r)
+	})
+
+	return r
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/config"
+)
+
+func Test_debugRouter(t *testing.T) {
+	cfg := config.Default()
+	require.Nil(t, newDebugGuard(cfg))
+
+	cfg.DebugToken = "s3cr3t"
+	cfg.DebugTrustedSubnet = "10.0.0.0/8"
+	guard := newDebugGuard(cfg)
+	require.NotNil(t, guard)
+
+	r := chi.NewRouter()
+	r.Mount("/internal/prof", newDebugRouter(guard))
+
+	get := func(path, remoteAddr, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = remoteAddr
+		if token != "" {
+			req.Header.Set(debugTokenHeader, token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("forbidden", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, get("/internal/prof/", "192.0.2.1:1234", "").Code)
+		assert.Equal(t, http.StatusForbidden, get("/internal/prof/", "192.0.2.1:1234", "wrong").Code)
+	})
+
+	t.Run("token", func(t *testing.T) {
+		w := get("/internal/prof/", "192.0.2.1:1234", "s3cr3t")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "goroutine")
+	})
+
+	t.Run("trusted_subnet", func(t *testing.T) {
+		w := get("/internal/prof/heap?debug=1", "10.1.2.3:1234", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "heap profile")
+	})
+
+	t.Run("goroutines", func(t *testing.T) {
+		w := get("/internal/prof/goroutines", "10.1.2.3:1234", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "goroutine ")
+		assert.Contains(t, w.Body.String(), "[running]")
+	})
+
+	t.Run("unknown_profile", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, get("/internal/prof/nope", "10.1.2.3:1234", "").Code)
+	})
+}
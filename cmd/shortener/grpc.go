@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+
+	pb "github.com/Yandex-Praktikum/go-profilable-shortener/api/shortenerpb"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/grpcapi"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/service"
+)
+
+// newGRPCServer returns gRPC API of svc identifying callers like HTTP API does
+func newGRPCServer(svc *service.Service, ac authConfig, logger *zap.Logger) *grpc.Server {
+	a := grpcapi.Auth{Codec: ac.codec, Keys: ac.keys, Strict: ac.strict, Logger: logger}
+	srv := grpc.NewServer(grpc.UnaryInterceptor(a.UnaryInterceptor))
+	pb.RegisterShortenerServer(srv, grpcapi.NewServer(svc, logger))
+	return srv
+}
+
+// serveGRPC runs srv until ctx is done, then waits for in-flight calls up to timeout
+func serveGRPC(ctx context.Context, srv *grpc.Server, ln net.Listener, timeout time.Duration, logger *zap.Logger) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("server stopped unexpectedly: %w", err)
+	case <-ctx.Done():
+	}
+
+	logger.Info("stopping gRPC server, waiting for in-flight calls", zap.Duration("timeout", timeout))
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-stopped:
+		return nil
+	case <-timer.C:
+		srv.Stop()
+		<-stopped
+		return errors.New("cannot shutdown server gracefully: in-flight calls are cancelled")
+	}
+}
@@ -7,16 +7,34 @@ import (
 )
 
 type compressWriter struct {
-	w  http.ResponseWriter
-	zw *gzip.Writer
+	w           http.ResponseWriter
+	zw          *gzip.Writer
+	wroteHeader bool
+
+	// raw and compressed count body bytes before and after compression
+	raw        int
+	compressed countingWriter
+}
+
+// countingWriter counts bytes passed to underlying writer
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += n
+	return n, err
 }
 
 func newCompressWriter(w http.ResponseWriter) *compressWriter {
-	zw, _ := gzip.NewWriterLevel(w, gzip.BestCompression)
-	return &compressWriter{
-		w:  w,
-		zw: zw,
+	c := &compressWriter{
+		w:          w,
+		compressed: countingWriter{w: w},
 	}
+	c.zw, _ = gzip.NewWriterLevel(&c.compressed, gzip.BestCompression)
+	return c
 }
 
 func (c *compressWriter) Header() http.Header {
@@ -24,14 +42,19 @@ func (c *compressWriter) Header() http.Header {
 }
 
 func (c *compressWriter) Write(p []byte) (int, error) {
-	return c.zw.Write(p)
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	n, err := c.zw.Write(p)
+	c.raw += n
+	return n, err
 }
 
 func (c *compressWriter) WriteHeader(statusCode int) {
-	if statusCode < 300 {
-		c.w.Header().Set("Content-Encoding", "gzip")
-	}
+	// body is compressed regardless of status, e.g. 409 still carries short URL
+	c.w.Header().Set("Content-Encoding", "gzip")
 	c.w.WriteHeader(statusCode)
+	c.wroteHeader = true
 }
 
 func (c *compressWriter) Close() error {
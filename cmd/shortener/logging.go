@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/gofrs/uuid"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/config"
+)
+
+// newLogger builds JSON or console logger of configured level
+func newLogger(cfg *config.Config) (*zap.Logger, error) {
+	level, err := zapcore.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+
+	zc := zap.NewProductionConfig()
+	zc.Level = zap.NewAtomicLevelAt(level)
+	zc.Encoding = cfg.LogFormat
+	zc.EncoderConfig.TimeKey = "time"
+	zc.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	// requests are sampled by accessLog, so identical messages must not be throttled
+	zc.Sampling = nil
+	return zc.Build()
+}
+
+// accessLog writes a line per request
+type accessLog struct {
+	logger *zap.Logger
+	// sampleRate is a share of successful requests logged, failed ones are always logged
+	sampleRate float64
+}
+
+// logEntry holds access log fields which are known only deeper in handler chain
+type logEntry struct {
+	uid string
+}
+
+type logEntryKey struct{}
+
+// annotateUID adds user of request to its access log line
+func annotateUID(ctx context.Context, uid uuid.UUID) {
+	if entry, ok := ctx.Value(logEntryKey{}).(*logEntry); ok {
+		entry.uid = uid.String()
+	}
+}
+
+// middleware must be used by chi router after middleware.RequestID
+func (al accessLog) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		reqID := middleware.GetReqID(r.Context())
+		w.Header().Set(middleware.RequestIDHeader, reqID)
+
+		entry := &logEntry{}
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(context.WithValue(r.Context(), logEntryKey{}, entry)))
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		level := zapcore.InfoLevel
+		if status >= http.StatusInternalServerError {
+			level = zapcore.ErrorLevel
+		} else if al.sampleRate < 1 && rand.Float64() >= al.sampleRate {
+			return
+		}
+
+		ce := al.logger.Check(level, "request")
+		if ce == nil {
+			return
+		}
+		var route string
+		if rctx := chi.RouteContext(r.Context()); rctx != nil {
+			route = rctx.RoutePattern()
+		}
+		ce.Write(
+			zap.String("request_id", reqID),
+			zap.String("method", r.Method),
+			zap.String("route", route),
+			zap.String("path", r.URL.Path),
+			zap.Int("status", status),
+			zap.Int("bytes", ww.BytesWritten()),
+			zap.Duration("duration", time.Since(start)),
+			zap.String("uid", entry.uid),
+		)
+	})
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func Test_accessLog(t *testing.T) {
+	uid := uuid.Must(uuid.NewV4())
+
+	newRouter := func(sampleRate float64) (chi.Router, *observer.ObservedLogs) {
+		core, logs := observer.New(zapcore.InfoLevel)
+		al := accessLog{logger: zap.New(core), sampleRate: sampleRate}
+
+		r := chi.NewRouter()
+		r.Use(middleware.RequestID, al.middleware)
+		r.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
+			annotateUID(r.Context(), uid)
+			_, _ = w.Write([]byte("hello"))
+		})
+		r.Get("/fail/{id}", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		})
+		return r, logs
+	}
+
+	t.Run("fields", func(t *testing.T) {
+		r, logs := newRouter(1)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/abc", nil))
+
+		require.Equal(t, 1, logs.Len())
+		entry := logs.All()[0]
+		fields := entry.ContextMap()
+		assert.Equal(t, zapcore.InfoLevel, entry.Level)
+		assert.Equal(t, "/{id}", fields["route"])
+		assert.Equal(t, "/abc", fields["path"])
+		assert.Equal(t, int64(http.StatusOK), fields["status"])
+		assert.Equal(t, int64(5), fields["bytes"])
+		assert.Equal(t, uid.String(), fields["uid"])
+		assert.NotEmpty(t, fields["request_id"])
+		assert.Equal(t, fields["request_id"], w.Header().Get(middleware.RequestIDHeader))
+	})
+
+	t.Run("sampling", func(t *testing.T) {
+		r, logs := newRouter(0)
+
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/abc", nil))
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fail/abc", nil))
+
+		// failed requests are never sampled out
+		require.Equal(t, 1, logs.Len())
+		assert.Equal(t, zapcore.ErrorLevel, logs.All()[0].Level)
+	})
+}
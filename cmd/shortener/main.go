@@ -2,64 +2,424 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
 	"fmt"
+	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/jackc/pgx"
 	"github.com/jackc/pgx/stdlib"
+	"go.uber.org/zap"
 
 	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/app"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/auth"
 	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/config"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/deletion"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/metrics"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/profiler"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/ratelimit"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/service"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/stats"
 	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/store"
 )
 
 func main() {
-	config.Parse()
+	cfg, err := config.Load(os.Args[1:], os.Getenv)
+	if err != nil {
+		log.Fatalf("cannot load config: %s", err)
+	}
+	logger, err := newLogger(cfg)
+	if err != nil {
+		log.Fatalf("cannot create logger: %s", err)
+	}
+	defer func() { _ = logger.Sync() }()
+	// packages logging via standard logger write structured lines too
+	defer zap.RedirectStdLog(logger)()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	defer stop()
+	go func() {
+		// second signal terminates process immediately
+		<-ctx.Done()
+		stop()
+	}()
 
-	if err := run(); err != nil {
-		panic("unexpected error: " + err.Error())
+	if err := run(ctx, cfg, logger); err != nil {
+		logger.Error("shutdown: finished with error", zap.Error(err))
+		_ = logger.Sync()
+		os.Exit(1)
 	}
+	logger.Info("shutdown: finished")
 }
 
-func run() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// run serves requests until ctx is done, then shuts everything down in order:
+// HTTP and gRPC servers, background workers and storage at last
+func run(ctx context.Context, cfg *config.Config, logger *zap.Logger) (err error) {
+	initCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	storage, err := newStore(ctx)
+	m := metrics.New()
+	storage, backend, err := newStore(initCtx, cfg, logger.Named("store"))
 	if err != nil {
 		return fmt.Errorf("cannot create storage: %w", err)
 	}
-	defer storage.Close()
+	storage = m.InstrumentStore(storage, backend)
+	defer func() {
+		logger.Info("shutdown: closing storage")
+		if cerr := storage.Close(); cerr != nil && err == nil {
+			err = fmt.Errorf("cannot close storage: %w", cerr)
+		}
+	}()
+
+	// in-process limiters are used unless shared ones are asked for
+	newLimiter := func(_ string, rate ratelimit.Rate) ratelimit.Limiter {
+		return ratelimit.NewMemory(rate, cfg.RateLimitMaxClients)
+	}
+	var shared *ratelimit.Postgres
+	if cfg.RateLimitShared {
+		db, err := openDB(initCtx, cfg.DatabaseDSN)
+		if err != nil {
+			return fmt.Errorf("cannot connect rate limits database: %w", err)
+		}
+		// closed after workers are drained
+		defer db.Close()
+
+		shared = ratelimit.NewPostgres(db)
+		if err := shared.Bootstrap(initCtx); err != nil {
+			return err
+		}
+		newLimiter = shared.Limiter
+	}
+
+	var workers sync.WaitGroup
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	if cfg.ReapInterval > 0 {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			store.RunReaper(workersCtx, storage, cfg.ReapInterval, cfg.ExpiredRetention, logger)
+		}()
+	}
+	if cfg.ProfileDir != "" {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			profiler.Run(workersCtx, cfg.ProfileDir, cfg.ProfileInterval, cfg.ProfileCPUDuration, logger.Named("profiler"))
+		}()
+	}
+	if shared != nil {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			shared.RunPruner(workersCtx, rateLimitPruneInterval, func(err error) {
+				logger.Error("cannot prune rate limits", zap.Error(err))
+			})
+		}()
+	}
+
+	recorder := stats.NewRecorder(storage, cfg.StatsBufferSize, cfg.StatsBatchSize, cfg.StatsFlushInterval, logger)
+	deletions := deletion.NewQueue(storage, cfg.DeleteQueueSize, cfg.DeleteWorkers, cfg.DeleteBatchSize, cfg.DeleteFlushInterval, logger)
+	m.ObserveDeletionQueue(deletions.Depth)
+
+	defer func() {
+		logger.Info("shutdown: draining background workers", zap.Int64("pending_deletions", deletions.Depth()))
+		stopWorkers()
+		_ = deletions.Close()
+		_ = recorder.Close()
+		workers.Wait()
+	}()
+
+	codec, err := newCodec(cfg, logger)
+	if err != nil {
+		return fmt.Errorf("cannot create auth codec: %w", err)
+	}
+	ipKey, ephemeral, err := newIPHashKey(cfg)
+	if err != nil {
+		return err
+	}
+	if ephemeral {
+		logger.Warn("no IP hash key configured, unique visitors are counted anew after restart")
+	}
+	svc := service.New(cfg.BaseURL, storage,
+		service.WithRecorder(recorder, ipKey),
+		service.WithDeletionQueue(deletions),
+		service.WithMetrics(m),
+		service.WithMaxBatchSize(cfg.MaxBatchSize),
+		// reserved even if served by side listeners, so aliases survive moving endpoints to main one
+		service.WithReservedPaths(cfg.MetricsPath, cfg.DebugPrefix),
+	)
+	instance := app.NewInstance(svc, app.WithLogger(logger))
+
+	ln, err := net.Listen("tcp", cfg.RunPort)
+	if err != nil {
+		return fmt.Errorf("cannot listen %s: %w", cfg.RunPort, err)
+	}
+	ac := authConfig{
+		codec:  codec,
+		keys:   svc,
+		cookie: authCookie(cfg),
+		strict: cfg.StrictAuth,
+		logger: logger,
+	}
+	al := accessLog{logger: logger.Named("access"), sampleRate: cfg.LogSampleRate}
+	proxies, err := cfg.TrustedProxyNets()
+	if err != nil {
+		return err
+	}
+	rl := newRateLimits(cfg, newLimiter)
+	rl.proxies, rl.metrics, rl.logger = proxies, m, logger.Named("ratelimit")
+	router := newRouter(instance, ac, al, m, rl)
+
+	// side listeners by address, metrics and profiling endpoints may share one
+	side := make(map[string]*http.ServeMux)
+	sideMux := func(addr string) *http.ServeMux {
+		if side[addr] == nil {
+			side[addr] = http.NewServeMux()
+		}
+		return side[addr]
+	}
+
+	if cfg.MetricsAddress == "" {
+		router.Handle(cfg.MetricsPath, m.Handler())
+	} else {
+		sideMux(cfg.MetricsAddress).Handle(cfg.MetricsPath, m.Handler())
+	}
+
+	if guard := newDebugGuard(cfg); guard != nil {
+		debugRouter := newDebugRouter(guard)
+		if cfg.DebugAddress == "" {
+			router.Mount(cfg.DebugPrefix, debugRouter)
+		} else {
+			sideMux(cfg.DebugAddress).Handle(cfg.DebugPrefix+"/", http.StripPrefix(cfg.DebugPrefix, debugRouter))
+		}
+		logger.Info("profiling endpoints enabled", zap.String("prefix", cfg.DebugPrefix))
+	}
+
+	// failure of any server stops all of them
+	ctx, stopServing := context.WithCancel(ctx)
+	defer stopServing()
+
+	sideLns := make(map[string]net.Listener, len(side))
+	for addr := range side {
+		sln, err := net.Listen("tcp", addr)
+		if err != nil {
+			_ = ln.Close()
+			for _, opened := range sideLns {
+				_ = opened.Close()
+			}
+			return fmt.Errorf("cannot listen %s: %w", addr, err)
+		}
+		sideLns[addr] = sln
+	}
+	var grpcLn net.Listener
+	if cfg.GRPCAddress != "" {
+		grpcLn, err = net.Listen("tcp", cfg.GRPCAddress)
+		if err != nil {
+			_ = ln.Close()
+			for _, opened := range sideLns {
+				_ = opened.Close()
+			}
+			return fmt.Errorf("cannot listen %s: %w", cfg.GRPCAddress, err)
+		}
+	}
+
+	servers := len(side)
+	sideErrs := make(chan error, len(side)+1)
+	for addr, mux := range side {
+		go func(srv *http.Server, ln net.Listener) {
+			err := serve(ctx, srv, ln, cfg.ShutdownTimeout, logger)
+			if err != nil {
+				stopServing()
+				err = fmt.Errorf("server at %s: %w", ln.Addr(), err)
+			}
+			sideErrs <- err
+		}(&http.Server{Handler: mux}, sideLns[addr])
+	}
+	if grpcLn != nil {
+		servers++
+		gs := newGRPCServer(svc, ac, logger.Named("grpc"))
+		go func() {
+			err := serveGRPC(ctx, gs, grpcLn, cfg.ShutdownTimeout, logger)
+			if err != nil {
+				stopServing()
+				err = fmt.Errorf("gRPC server at %s: %w", grpcLn.Addr(), err)
+			}
+			sideErrs <- err
+		}()
+		logger.Info("gRPC API enabled", zap.String("address", cfg.GRPCAddress))
+	}
+	defer func() {
+		stopServing()
+		for n := 0; n < servers; n++ {
+			if serr := <-sideErrs; serr != nil && err == nil {
+				err = serr
+			}
+		}
+	}()
+
+	srv := &http.Server{Handler: router}
+	return serve(ctx, srv, ln, cfg.ShutdownTimeout, logger)
+}
+
+// serve runs srv until ctx is done, then waits for in-flight requests up to timeout
+func serve(ctx context.Context, srv *http.Server, ln net.Listener, timeout time.Duration, logger *zap.Logger) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("server stopped unexpectedly: %w", err)
+	case <-ctx.Done():
+	}
+
+	logger.Info("stopping HTTP server, waiting for in-flight requests", zap.Duration("timeout", timeout))
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		_ = srv.Close()
+		return fmt.Errorf("cannot shutdown server gracefully: %w", err)
+	}
+	return nil
+}
+
+// newCodec returns auth cookie codec of configured keys,
+// without configured keys a random one is used, so cookies do not survive restart
+func newCodec(cfg *config.Config, logger *zap.Logger) (*auth.Codec, error) {
+	var keys []auth.Key
+	for _, key := range cfg.AuthKeys {
+		if key.Retired {
+			continue
+		}
+		keys = append(keys, auth.Key{ID: key.ID, Secret: []byte(key.Secret)})
+	}
+	if cfg.AuthLegacySecret != "" {
+		keys = append(keys, auth.Key{Secret: []byte(cfg.AuthLegacySecret), Legacy: true})
+	}
+
+	activeID := cfg.ActiveAuthKey()
+	if activeID == "" {
+		// users of persistent storage lose access to their links along with their cookies
+		if cfg.PersistFile != "" || cfg.DatabaseDSN != "" {
+			logger.Error("no auth keys configured for persistent storage, using ephemeral one, users lose their links on restart")
+		} else {
+			logger.Warn("no auth keys configured, using ephemeral one")
+		}
+		key := auth.Key{ID: "ephemeral", Secret: make([]byte, 32)}
+		if _, err := rand.Read(key.Secret); err != nil {
+			return nil, fmt.Errorf("cannot generate auth key: %w", err)
+		}
+		activeID, keys = key.ID, append(keys, key)
+	}
+	return auth.NewCodec(activeID, keys...)
+}
 
-	instance := app.NewInstance(config.BaseURL, storage)
+// newIPHashKey returns configured key of visitor IP hashes,
+// without one a random key is used, so unique visitors are not told apart across restarts
+func newIPHashKey(cfg *config.Config) (key []byte, ephemeral bool, err error) {
+	if cfg.IPHashKey != "" {
+		return []byte(cfg.IPHashKey), false, nil
+	}
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, false, fmt.Errorf("cannot generate IP hash key: %w", err)
+	}
+	return key, true, nil
+}
 
-	return http.ListenAndServe(config.RunPort, newRouter(instance))
+// authCookie returns attributes of issued auth cookie
+func authCookie(cfg *config.Config) http.Cookie {
+	cookie := http.Cookie{
+		Path:     cfg.CookiePath,
+		MaxAge:   int(cfg.CookieMaxAge / time.Second),
+		HttpOnly: cfg.CookieHTTPOnly,
+		Secure:   cfg.CookieSecure,
+	}
+	switch cfg.CookieSameSite {
+	case "lax":
+		cookie.SameSite = http.SameSiteLaxMode
+	case "strict":
+		cookie.SameSite = http.SameSiteStrictMode
+	case "none":
+		cookie.SameSite = http.SameSiteNoneMode
+	}
+	return cookie
 }
 
-func newStore(ctx context.Context) (storage store.AuthStore, err error) {
-	if config.DatabaseDSN != "" {
-		rdb, err := newRDBStore(ctx, config.DatabaseDSN)
+// newStore returns configured storage along with its backend name
+func newStore(ctx context.Context, cfg *config.Config, logger *zap.Logger) (storage store.AuthStore, backend string, err error) {
+	opts := []store.Option{store.WithLogger(logger)}
+
+	gen, err := newIDGenerator(cfg)
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot create ID generator: %w", err)
+	}
+	if gen != nil {
+		opts = append(opts, store.WithIDGenerator(gen))
+	}
+
+	if cfg.DatabaseDSN != "" {
+		opts = append(opts, store.WithInsertChunkSize(cfg.InsertChunkSize))
+		rdb, err := newRDBStore(ctx, cfg.DatabaseDSN, opts...)
 		if err != nil {
-			return nil, fmt.Errorf("cannot create RDB store: %w", err)
+			return nil, "", fmt.Errorf("cannot create RDB store: %w", err)
 		}
 		if err := rdb.Bootstrap(ctx); err != nil {
-			return nil, fmt.Errorf("cannot bootstrap RDB store: %w", err)
+			return nil, "", fmt.Errorf("cannot bootstrap RDB store: %w", err)
 		}
-		return rdb, nil
+		return rdb, "postgres", nil
 	}
-	if config.PersistFile != "" {
-		storage, err = store.NewFileStore(config.PersistFile)
+	if cfg.PersistFile != "" {
+		opts = append(opts,
+			store.WithSnapshotInterval(cfg.SnapshotInterval),
+			store.WithMaxLogSize(cfg.MaxLogSize),
+		)
+		storage, err = store.NewFileStore(cfg.PersistFile, opts...)
 		if err != nil {
-			return nil, fmt.Errorf("cannot create file store: %w", err)
+			return nil, "", fmt.Errorf("cannot create file store: %w", err)
 		}
-		return
+		return storage, "file", nil
+	}
+	return store.NewInMemory(opts...), "memory", nil
+}
+
+// newIDGenerator returns nil if storage should use its own default scheme
+func newIDGenerator(cfg *config.Config) (store.IDGenerator, error) {
+	switch cfg.IDGenerator {
+	case "":
+		return nil, nil
+	case "hex":
+		return store.NewHexGenerator(), nil
+	case "base62":
+		return store.NewBase62Generator(), nil
+	case "random":
+		return store.NewRandomGenerator(cfg.IDLength)
+	case "hashids":
+		return store.NewHashidsGenerator(cfg.IDSalt, cfg.IDLength), nil
+	default:
+		return nil, fmt.Errorf("unknown ID generator %q", cfg.IDGenerator)
 	}
-	return store.NewInMemory(), nil
 }
 
-func newRDBStore(ctx context.Context, dsn string) (*store.RDB, error) {
+func newRDBStore(ctx context.Context, dsn string, opts ...store.Option) (*store.RDB, error) {
+	conn, err := openDB(ctx, dsn)
+	if err != nil {
+		return nil, err
+	}
+	return store.NewRDB(conn, opts...), nil
+}
+
+// openDB returns checked connection pool of database
+func openDB(ctx context.Context, dsn string) (*sql.DB, error) {
 	// disable prepared statements
 	driverConfig := stdlib.DriverConfig{
 		ConnConfig: pgx.ConnConfig{
@@ -74,8 +434,8 @@ func newRDBStore(ctx context.Context, dsn string) (*store.RDB, error) {
 	}
 
 	if err = conn.PingContext(ctx); err != nil {
+		_ = conn.Close()
 		return nil, fmt.Errorf("cannot perform initial ping: %w", err)
 	}
-
-	return store.NewRDB(conn), nil
+	return conn, nil
 }
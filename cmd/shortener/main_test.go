@@ -3,10 +3,12 @@ package main
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -17,15 +19,19 @@ import (
 	"github.com/go-resty/resty/v2"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
 
 	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/config"
 	"github.com/Yandex-Praktikum/go-profilable-shortener/models"
 )
 
 func TestMain(m *testing.M) {
-	config.Parse()
+	cfg, err := config.Load(nil, func(string) string { return "" })
+	if err != nil {
+		panic(err)
+	}
 	go func() {
-		err := run()
+		err := run(context.Background(), cfg, zap.NewNop())
 		if err != nil {
 			panic(err)
 		}
@@ -38,15 +44,17 @@ func Test_run(t *testing.T) {
 
 	targetURL := "https://praktikum.yandex.ru/"
 
+	// every URL is shortened only once, so each iteration uses its own one
 	for i := 0; i < 50; i++ {
 		expectedID := fmt.Sprintf("%x", i)
+		originalURL := fmt.Sprintf("%s?n=%d", targetURL, i)
 
 		t.Run("shorten", func(t *testing.T) {
 			expectResponse := "http://localhost:8080/" + expectedID
 			var actualResponse string
 
 			{
-				body := bytes.NewBufferString(targetURL)
+				body := bytes.NewBufferString(originalURL)
 				r := httptest.NewRequest("POST", "http://localhost:8080/", body)
 				r.RequestURI = ""
 
@@ -73,20 +81,21 @@ func Test_run(t *testing.T) {
 				defer resp.Body.Close()
 
 				assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
-				assert.Equal(t, targetURL, resp.Header.Get("Location"))
+				assert.Equal(t, originalURL, resp.Header.Get("Location"))
 			}
 		})
 	}
 
 	for i := 50; i < 100; i++ {
 		expectedID := fmt.Sprintf("%x", i)
+		originalURL := fmt.Sprintf("%s?n=%d", targetURL, i)
 
 		t.Run("shortenAPI", func(t *testing.T) {
 			expectResponse := "{\"result\":\"http://localhost:8080/" + expectedID + "\"}\n"
 			var actualResponse string
 
 			{
-				body := bytes.NewBufferString(`{"url":"` + targetURL + `"}`)
+				body := bytes.NewBufferString(`{"url":"` + originalURL + `"}`)
 				r := httptest.NewRequest("POST", "http://localhost:8080/api/shorten", body)
 				r.RequestURI = ""
 
@@ -117,7 +126,7 @@ func Test_run(t *testing.T) {
 				defer resp.Body.Close()
 
 				assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
-				assert.Equal(t, targetURL, resp.Header.Get("Location"))
+				assert.Equal(t, originalURL, resp.Header.Get("Location"))
 			}
 		})
 	}
@@ -150,7 +159,7 @@ func Test_run(t *testing.T) {
 	})
 
 	t.Run("accepts_gzip", func(t *testing.T) {
-		buf := bytes.NewBufferString(targetURL)
+		buf := bytes.NewBufferString(targetURL + "?gzip")
 		r := httptest.NewRequest("POST", "http://localhost:8080/", buf)
 		r.RequestURI = ""
 		r.Header.Set("Accept-Encoding", "gzip")
@@ -172,6 +181,21 @@ func Test_run(t *testing.T) {
 
 		require.Equal(t, expectResponse, actualResponse)
 	})
+
+	t.Run("conflict", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "http://localhost:8080/", bytes.NewBufferString(targetURL))
+		r.RequestURI = ""
+
+		resp, err := http.DefaultClient.Do(r)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusConflict, resp.StatusCode)
+
+		defer resp.Body.Close()
+
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, "http://localhost:8080/64", string(b))
+	})
 }
 
 func TestEndToEnd(t *testing.T) {
@@ -212,4 +236,52 @@ func TestEndToEnd(t *testing.T) {
 
 	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode())
 	assert.Equal(t, originalURL, resp.Header().Get("Location"))
+
+	// scrape metrics
+	resp, err = httpc.R().Get("/metrics")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Contains(t, string(resp.Body()), `shortener_redirects_total{result="redirected"}`)
+	assert.Contains(t, string(resp.Body()), `route="/{id}"`)
+}
+
+func Test_serve(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	started := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(ctx, srv, ln, time.Second, zap.NewNop())
+	}()
+
+	respCh := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			respCh <- nil
+			return
+		}
+		resp.Body.Close()
+		respCh <- resp
+	}()
+
+	// in-flight request is completed although shutdown has begun
+	<-started
+	cancel()
+
+	resp := <-respCh
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, <-served)
+
+	_, err = http.Get("http://" + ln.Addr().String())
+	assert.Error(t, err)
 }
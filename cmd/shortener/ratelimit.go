@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"go.uber.org/zap"
+
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/app"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/config"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/metrics"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/ratelimit"
+)
+
+// rateLimits holds limiters of route groups, nil limiter leaves its group unlimited
+type rateLimits struct {
+	shorten  ratelimit.Limiter
+	batch    ratelimit.Limiter
+	redirect ratelimit.Limiter
+	user     ratelimit.Limiter
+
+	// proxies are trusted to tell client address in X-Forwarded-For
+	proxies []*net.IPNet
+	metrics *metrics.Metrics
+	logger  *zap.Logger
+}
+
+// rateLimitPruneInterval is how often full buckets are deleted from shared limiter
+const rateLimitPruneInterval = time.Minute
+
+// newRateLimits returns limiters of configured route groups made by newLimiter
+func newRateLimits(cfg *config.Config, newLimiter func(name string, rate ratelimit.Rate) ratelimit.Limiter) rateLimits {
+	var rl rateLimits
+	for _, group := range []struct {
+		name    string
+		limit   config.RateLimit
+		limiter *ratelimit.Limiter
+	}{
+		{name: "shorten", limit: cfg.RateLimitShorten, limiter: &rl.shorten},
+		{name: "batch", limit: cfg.RateLimitBatch, limiter: &rl.batch},
+		{name: "redirect", limit: cfg.RateLimitRedirect, limiter: &rl.redirect},
+		{name: "user", limit: cfg.RateLimitUser, limiter: &rl.user},
+	} {
+		if !group.limit.Enabled() {
+			continue
+		}
+		rate := ratelimit.Rate{
+			Limit: float64(group.limit.Count) / group.limit.Period.Seconds(),
+			Burst: group.limit.Burst,
+		}
+		*group.limiter = newLimiter(group.name, rate)
+	}
+	return rl
+}
+
+type clientKeyCtxKey struct{}
+
+// withClientKey marks request with identity client cannot mint on its own, i.e. valid API key;
+// auth cookies do not count as anyone may get any number of them, so their holders
+// are told apart by address
+func withClientKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, clientKeyCtxKey{}, key)
+}
+
+// clientKey returns key of request bucket, client IP is used for requests without API key
+func (rl rateLimits) clientKey(r *http.Request) string {
+	if key, ok := r.Context().Value(clientKeyCtxKey{}).(string); ok {
+		return key
+	}
+	return "ip:" + clientIP(r, rl.proxies)
+}
+
+// middleware limits requests of group, it must follow authMiddleware to tell users apart;
+// limiter failures let requests in, so shared limiter outage does not stop the service
+func (rl rateLimits) middleware(group string, l ratelimit.Limiter) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		if l == nil {
+			return h
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ok, retryAfter, err := l.Allow(r.Context(), rl.clientKey(r))
+			if err != nil {
+				rl.logger.Error("cannot check rate limit",
+					zap.String("request_id", middleware.GetReqID(r.Context())),
+					zap.String("group", group), zap.Error(err))
+				h.ServeHTTP(w, r)
+				return
+			}
+			if !ok {
+				rl.metrics.RateLimited(group)
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(retryAfter)))
+				_ = app.WriteProblem(w, r, http.StatusTooManyRequests, "rate_limited", "too many requests", nil)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// retryAfterSeconds rounds wait up, so clients following header are not rejected again
+func retryAfterSeconds(d time.Duration) int {
+	s := int(math.Ceil(d.Seconds()))
+	if s < 1 {
+		return 1
+	}
+	return s
+}
+
+// clientIP returns address of client. X-Forwarded-For is read only if request comes
+// from trusted proxy and is walked from the right up to the first untrusted hop,
+// so clients cannot spoof address by prepending entries. IPv6 clients are told apart
+// by /64 networks as a single host usually owns the whole one.
+func clientIP(r *http.Request, proxies []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+
+	if trusted(ip, proxies) {
+		hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+		for n := len(hops) - 1; n >= 0; n-- {
+			hop := net.ParseIP(strings.TrimSpace(hops[n]))
+			if hop == nil {
+				break
+			}
+			ip = hop
+			if !trusted(ip, proxies) {
+				break
+			}
+		}
+	}
+
+	if ip.To4() == nil {
+		ip = ip.Mask(net.CIDRMask(64, 128))
+	}
+	return ip.String()
+}
+
+func trusted(ip net.IP, proxies []*net.IPNet) bool {
+	for _, p := range proxies {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
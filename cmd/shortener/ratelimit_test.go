@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/auth"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/metrics"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/ratelimit"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/service"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/store"
+)
+
+func Test_clientIP(t *testing.T) {
+	proxies := []*net.IPNet{
+		{IP: net.ParseIP("10.0.0.0"), Mask: net.CIDRMask(8, 32)},
+		{IP: net.ParseIP("fd00::"), Mask: net.CIDRMask(8, 128)},
+	}
+
+	testCases := []struct {
+		name       string
+		remoteAddr string
+		xff        []string
+		want       string
+	}{
+		{name: "direct", remoteAddr: "203.0.113.7:1234", want: "203.0.113.7"},
+		{name: "untrusted_forwarded", remoteAddr: "203.0.113.7:1234", xff: []string{"198.51.100.1"}, want: "203.0.113.7"},
+		{name: "trusted_proxy", remoteAddr: "10.0.0.2:1234", xff: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "proxy_chain", remoteAddr: "10.0.0.2:1234", xff: []string{"198.51.100.1, 10.0.0.3", "10.1.1.1"}, want: "198.51.100.1"},
+		{name: "spoofed_prefix", remoteAddr: "10.0.0.2:1234", xff: []string{"192.0.2.66, 198.51.100.1"}, want: "198.51.100.1"},
+		{name: "garbage_hop", remoteAddr: "10.0.0.2:1234", xff: []string{"198.51.100.1, unknown, 10.0.0.3"}, want: "10.0.0.3"},
+		{name: "only_proxies", remoteAddr: "10.0.0.2:1234", xff: []string{"10.0.0.3"}, want: "10.0.0.3"},
+		{name: "ipv6_network", remoteAddr: "[2001:db8:1:2:3:4:5:6]:1234", want: "2001:db8:1:2::"},
+		{name: "ipv6_proxy", remoteAddr: "[fd00::1]:1234", xff: []string{"2001:db8::1"}, want: "2001:db8::"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tc.remoteAddr
+			for _, v := range tc.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			assert.Equal(t, tc.want, clientIP(r, proxies))
+		})
+	}
+}
+
+type failingLimiter struct{}
+
+func (failingLimiter) Allow(context.Context, string) (bool, time.Duration, error) {
+	return false, 0, errors.New("database is down")
+}
+
+func Test_rateLimits(t *testing.T) {
+	codec, err := auth.NewCodec("k", auth.Key{ID: "k", Secret: []byte("0123456789abcdef")})
+	require.NoError(t, err)
+	storage := store.NewInMemory()
+	svc := service.New("http://localhost:8080", storage)
+	ac := authConfig{codec: codec, keys: svc, cookie: http.Cookie{Path: "/"}, logger: zap.NewNop()}
+
+	newHandler := func(l ratelimit.Limiter) http.Handler {
+		rl := rateLimits{metrics: metrics.New(), logger: zap.NewNop()}
+		return authMiddleware(ac, false)(rl.middleware("batch", l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	}
+	serve := func(h http.Handler, remoteAddr string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/api/shorten/batch", nil)
+		r.RemoteAddr = remoteAddr
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("anonymous_by_ip", func(t *testing.T) {
+		h := newHandler(ratelimit.NewMemory(ratelimit.Every(1, time.Minute), 10))
+
+		w := serve(h, "203.0.113.7:1", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		// dropping issued cookie does not give new bucket
+		w = serve(h, "203.0.113.7:2", nil)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "60", w.Header().Get("Retry-After"))
+
+		w = serve(h, "203.0.113.8:1", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("cookies_by_ip", func(t *testing.T) {
+		h := newHandler(ratelimit.NewMemory(ratelimit.Every(1, time.Minute), 10))
+		alice := &http.Cookie{Name: authCookieName, Value: userCookie(t, codec)}
+		bob := &http.Cookie{Name: authCookieName, Value: userCookie(t, codec)}
+
+		// fresh cookies do not give new buckets
+		assert.Equal(t, http.StatusOK, serve(h, "203.0.113.7:1", alice).Code)
+		assert.Equal(t, http.StatusTooManyRequests, serve(h, "203.0.113.7:2", bob).Code)
+		assert.Equal(t, http.StatusTooManyRequests, serve(h, "203.0.113.7:3", nil).Code)
+		assert.Equal(t, http.StatusOK, serve(h, "203.0.113.8:1", alice).Code)
+	})
+
+	t.Run("api_keys_by_key", func(t *testing.T) {
+		h := newHandler(ratelimit.NewMemory(ratelimit.Every(1, time.Minute), 10))
+		token, hash, err := auth.NewAPIKey()
+		require.NoError(t, err)
+		require.NoError(t, storage.SaveAPIKey(context.Background(), store.APIKey{ID: "ci", UID: ensureRandom(), Name: "ci", Hash: hash}))
+		withKey := func(remoteAddr string) int {
+			r := httptest.NewRequest("POST", "/api/shorten/batch", nil)
+			r.RemoteAddr = remoteAddr
+			r.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			return w.Code
+		}
+
+		// key holder has own bucket wherever requests come from
+		assert.Equal(t, http.StatusOK, serve(h, "203.0.113.7:1", nil).Code)
+		assert.Equal(t, http.StatusOK, withKey("203.0.113.7:2"))
+		assert.Equal(t, http.StatusTooManyRequests, withKey("203.0.113.8:1"))
+	})
+
+	t.Run("fail_open", func(t *testing.T) {
+		w := serve(newHandler(failingLimiter{}), "203.0.113.7:1", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("unlimited", func(t *testing.T) {
+		h := newHandler(nil)
+		for i := 0; i < 10; i++ {
+			assert.Equal(t, http.StatusOK, serve(h, "203.0.113.7:1", nil).Code)
+		}
+	})
+}
+
+func userCookie(t *testing.T, codec *auth.Codec) string {
+	value, err := codec.EncodeUIDToHex(ensureRandom())
+	require.NoError(t, err)
+	return value
+}
@@ -1,91 +1,167 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"strings"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/gofrs/uuid"
+	"go.uber.org/zap"
 
 	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/app"
 	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/auth"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/metrics"
 )
 
-func newRouter(i *app.Instance) http.Handler {
+func newRouter(i *app.Instance, ac authConfig, al accessLog, m *metrics.Metrics, rl rateLimits) chi.Router {
 	r := chi.NewRouter()
 
-	r.Use(gzipMiddleware, authMiddleware)
-	r.Post("/", i.ShortenHandler)
-	r.Post("/api/shorten", i.ShortenAPIHandler)
-	r.Post("/api/shorten/batch", i.BatchShortenAPIHandler)
-	r.Delete("/api/user/urls", i.BatchRemoveAPIHandler)
-	r.Get("/{id}", i.ExpandHandler)
-	r.Get("/api/user/urls", i.UserURLsHandler)
-	r.Get("/ping", i.PingHandler)
+	r.Use(middleware.RequestID, al.middleware, m.Middleware, gzipMiddleware(m))
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		_ = app.WriteProblem(w, r, http.StatusNotFound, "not_found", "route not found", nil)
+	})
+	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		_ = app.WriteProblem(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed", nil)
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(authMiddleware(ac, false))
+		r.Group(func(r chi.Router) {
+			r.Use(rl.middleware("shorten", rl.shorten))
+			r.Post("/", i.ShortenHandler)
+			r.Post("/api/shorten", i.ShortenAPIHandler)
+		})
+		r.With(rl.middleware("batch", rl.batch)).Post("/api/shorten/batch", i.BatchShortenAPIHandler)
+		r.With(rl.middleware("redirect", rl.redirect)).Get("/{id}", i.ExpandHandler)
+		r.Get("/ping", i.PingHandler)
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(authMiddleware(ac, true), rl.middleware("user", rl.user))
+		r.Delete("/api/user/urls", i.BatchRemoveAPIHandler)
+		r.Get("/api/user/urls", i.UserURLsHandler)
+		r.Get("/api/user/urls/{id}/stats", i.URLStatsHandler)
+		r.Post("/api/user/keys", i.CreateAPIKeyHandler)
+		r.Get("/api/user/keys", i.APIKeysHandler)
+		r.Delete("/api/user/keys/{id}", i.RevokeAPIKeyHandler)
+	})
 
 	return r
 }
 
-func gzipMiddleware(h http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ow := w
-
-		acceptEncoding := r.Header.Get("Accept-Encoding")
-		supportsGzip := strings.Contains(acceptEncoding, "gzip")
-		if supportsGzip {
-			cw := newCompressWriter(w)
-			ow = cw
-			defer cw.Close()
-		}
-
-		contentEncoding := r.Header.Get("Content-Encoding")
-		sendsGzip := strings.Contains(contentEncoding, "gzip")
-		if sendsGzip {
-			cr, err := newCompressReader(r.Body)
-			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				return
+func gzipMiddleware(m *metrics.Metrics) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ow := w
+
+			acceptEncoding := r.Header.Get("Accept-Encoding")
+			supportsGzip := strings.Contains(acceptEncoding, "gzip")
+			if supportsGzip {
+				cw := newCompressWriter(w)
+				ow = cw
+				defer func() {
+					_ = cw.Close()
+					m.ObserveCompression(cw.raw, cw.compressed.n)
+				}()
 			}
-			r.Body = cr
-			defer cr.Close()
-		}
 
-		h.ServeHTTP(ow, r)
-	})
+			contentEncoding := r.Header.Get("Content-Encoding")
+			sendsGzip := strings.Contains(contentEncoding, "gzip")
+			if sendsGzip {
+				cr, err := newCompressReader(r.Body)
+				if err != nil {
+					_ = app.WriteProblem(w, r, http.StatusBadRequest, "validation", "cannot decompress request body", nil)
+					return
+				}
+				r.Body = cr
+				defer cr.Close()
+			}
+
+			h.ServeHTTP(ow, r)
+		})
+	}
 }
 
-func authMiddleware(h http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var uid *uuid.UUID
-
-		cookie, err := r.Cookie("auth")
-		if cookie != nil {
-			uid, err = auth.DecodeUIDFromHex(cookie.Value)
-		}
-		// generate new uid if failed to obtain existing
-		if uid == nil {
-			userID := ensureRandom()
-			uid = &userID
-		}
-
-		// set new auth cookie in case of absence or decode error
-		if err != nil {
-			value, err := auth.EncodeUIDToHex(*uid)
-			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				_, _ = w.Write([]byte("cannot encode auth cookie"))
+// apiKeyResolver maps bearer token to its owner
+type apiKeyResolver interface {
+	APIKeyUID(ctx context.Context, token string) (uuid.UUID, error)
+}
+
+const authCookieName = "auth"
+
+// authConfig tunes authMiddleware
+type authConfig struct {
+	codec *auth.Codec
+	keys  apiKeyResolver
+	// cookie holds attributes of issued auth cookie, its name and value are set by middleware
+	cookie http.Cookie
+	// strict makes user scoped routes answer 401 instead of issuing new identity
+	strict bool
+	logger *zap.Logger
+}
+
+// authMiddleware identifies user by bearer API key if one is given,
+// otherwise by auth cookie which is issued to anonymous users
+// unless route is user scoped and strict mode is on
+func authMiddleware(ac authConfig, userScoped bool) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token, ok := auth.BearerToken(r.Header.Get("Authorization")); ok {
+				uid, err := ac.keys.APIKeyUID(r.Context(), token)
+				if errors.Is(err, auth.ErrInvalidAPIKey) {
+					w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+					_ = app.WriteProblem(w, r, http.StatusUnauthorized, "unauthorized", "invalid API key given", nil)
+					return
+				}
+				if err != nil {
+					ac.logger.Error("cannot resolve API key",
+						zap.String("request_id", middleware.GetReqID(r.Context())), zap.Error(err))
+					_ = app.WriteProblem(w, r, http.StatusInternalServerError, "internal", "internal error", nil)
+					return
+				}
+				annotateUID(r.Context(), uid)
+				ctx := withClientKey(auth.Context(r.Context(), uid), "key:"+auth.HashAPIKey(token))
+				h.ServeHTTP(w, r.WithContext(ctx))
 				return
 			}
-			cookie = &http.Cookie{Name: "auth", Value: value}
-			http.SetCookie(w, cookie)
-		}
 
-		// set uid to context
-		ctx := auth.Context(r.Context(), *uid)
-		r = r.WithContext(ctx)
+			var uid *uuid.UUID
+			var stale bool
 
-		h.ServeHTTP(w, r)
-	})
+			cookie, err := r.Cookie(authCookieName)
+			if cookie != nil {
+				uid, stale, err = ac.codec.DecodeUIDFromHex(cookie.Value)
+			}
+			if uid == nil && userScoped && ac.strict {
+				_ = app.WriteProblem(w, r, http.StatusUnauthorized, "unauthorized", "authentication is required", nil)
+				return
+			}
+			// generate new uid if failed to obtain existing
+			if uid == nil {
+				userID := ensureRandom()
+				uid = &userID
+			}
+
+			// set new auth cookie in case of absence, decode error or rotated key
+			if err != nil || stale {
+				value, err := ac.codec.EncodeUIDToHex(*uid)
+				if err != nil {
+					_ = app.WriteProblem(w, r, http.StatusInternalServerError, "internal", "cannot encode auth cookie", nil)
+					return
+				}
+				cookie := ac.cookie
+				cookie.Name, cookie.Value = authCookieName, value
+				http.SetCookie(w, &cookie)
+			}
+
+			// set uid to context
+			annotateUID(r.Context(), *uid)
+			r = r.WithContext(auth.Context(r.Context(), *uid))
+
+			h.ServeHTTP(w, r)
+		})
+	}
 }
 
 func ensureRandom() (res uuid.UUID) {
@@ -1,23 +1,47 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/gofrs/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
 
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/app"
 	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/auth"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/metrics"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/service"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/store"
 )
 
 func Test_authMiddleware(t *testing.T) {
+	oldKey := auth.Key{ID: "old", Secret: []byte("ololo-trololo-shimba-boomba-look")}
+	legacyKey := auth.Key{Secret: []byte("ololo-trololo-shimba-boomba-look"), Legacy: true}
+	codec, err := auth.NewCodec("new", auth.Key{ID: "new", Secret: []byte("0123456789abcdef")}, oldKey, legacyKey)
+	require.NoError(t, err)
+
+	storage := store.NewInMemory()
+	svc := service.New("http://localhost:8080", storage)
+	ac := authConfig{codec: codec, keys: svc, cookie: http.Cookie{Path: "/"}, logger: zap.NewNop()}
+
 	t.Run("no_cookie", func(t *testing.T) {
 		r := httptest.NewRequest("GET", "/api/user/urls", nil)
 		w := httptest.NewRecorder()
 
-		mw := authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mw := authMiddleware(ac, false)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			assert.NotNil(t, auth.UIDFromContext(r.Context()))
 		}))
 		mw.ServeHTTP(w, r)
@@ -30,7 +54,7 @@ func Test_authMiddleware(t *testing.T) {
 		r.AddCookie(&http.Cookie{Name: "auth", Value: "ololo"})
 		w := httptest.NewRecorder()
 
-		mw := authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mw := authMiddleware(ac, false)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			assert.NotNil(t, auth.UIDFromContext(r.Context()))
 		}))
 		mw.ServeHTTP(w, r)
@@ -40,18 +64,257 @@ func Test_authMiddleware(t *testing.T) {
 
 	t.Run("existing_cookie", func(t *testing.T) {
 		uid := uuid.Must(uuid.NewV4())
-		cookie, err := auth.EncodeUIDToHex(uid)
+		cookie, err := codec.EncodeUIDToHex(uid)
+		require.NoError(t, err)
+
+		r := httptest.NewRequest("GET", "/api/user/urls", nil)
+		r.AddCookie(&http.Cookie{Name: "auth", Value: cookie})
+		w := httptest.NewRecorder()
+
+		mw := authMiddleware(ac, false)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, &uid, auth.UIDFromContext(r.Context()))
+		}))
+		mw.ServeHTTP(w, r)
+
+		assert.Empty(t, w.Header().Get("Set-Cookie"))
+	})
+
+	t.Run("rotated_key", func(t *testing.T) {
+		old, err := auth.NewCodec(oldKey.ID, oldKey)
+		require.NoError(t, err)
+		uid := uuid.Must(uuid.NewV4())
+		cookie, err := old.EncodeUIDToHex(uid)
 		require.NoError(t, err)
 
 		r := httptest.NewRequest("GET", "/api/user/urls", nil)
 		r.AddCookie(&http.Cookie{Name: "auth", Value: cookie})
 		w := httptest.NewRecorder()
 
-		mw := authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mw := authMiddleware(ac, false)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, &uid, auth.UIDFromContext(r.Context()))
+		}))
+		mw.ServeHTTP(w, r)
+
+		// the same identity is re-issued with active key
+		cookies := w.Result().Cookies()
+		require.Len(t, cookies, 1)
+		reissued, stale, err := codec.DecodeUIDFromHex(cookies[0].Value)
+		require.NoError(t, err)
+		assert.Equal(t, &uid, reissued)
+		assert.False(t, stale)
+	})
+
+	t.Run("legacy_cookie", func(t *testing.T) {
+		// cookie of the format preceding key IDs is | nonce | sealed UID |
+		block, err := aes.NewCipher(legacyKey.Secret)
+		require.NoError(t, err)
+		gcm, err := cipher.NewGCM(block)
+		require.NoError(t, err)
+		nonce := make([]byte, gcm.NonceSize())
+		_, err = io.ReadFull(rand.Reader, nonce)
+		require.NoError(t, err)
+		uid := uuid.Must(uuid.NewV4())
+		cookie := hex.EncodeToString(gcm.Seal(nonce, nonce, uid.Bytes(), nil))
+
+		r := httptest.NewRequest("GET", "/api/user/urls", nil)
+		r.AddCookie(&http.Cookie{Name: "auth", Value: cookie})
+		w := httptest.NewRecorder()
+
+		strict := ac
+		strict.strict = true
+		mw := authMiddleware(strict, true)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			assert.Equal(t, &uid, auth.UIDFromContext(r.Context()))
 		}))
 		mw.ServeHTTP(w, r)
 
+		// the same identity is re-issued in the current format
+		assert.NotEqual(t, http.StatusUnauthorized, w.Code)
+		cookies := w.Result().Cookies()
+		require.Len(t, cookies, 1)
+		reissued, stale, err := codec.DecodeUIDFromHex(cookies[0].Value)
+		require.NoError(t, err)
+		assert.Equal(t, &uid, reissued)
+		assert.False(t, stale)
+	})
+
+	t.Run("api_key", func(t *testing.T) {
+		uid := uuid.Must(uuid.NewV4())
+		token, hash, err := auth.NewAPIKey()
+		require.NoError(t, err)
+		require.NoError(t, storage.SaveAPIKey(context.Background(), store.APIKey{ID: "ci", UID: uid, Name: "ci", Hash: hash}))
+
+		serve := func(token string) *httptest.ResponseRecorder {
+			r := httptest.NewRequest("GET", "/api/user/urls", nil)
+			r.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+
+			mw := authMiddleware(ac, false)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, &uid, auth.UIDFromContext(r.Context()))
+			}))
+			mw.ServeHTTP(w, r)
+			return w
+		}
+
+		w := serve(token)
+		assert.Equal(t, http.StatusOK, w.Code)
 		assert.Empty(t, w.Header().Get("Set-Cookie"))
+
+		w = serve("shk_unknown")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Empty(t, w.Header().Get("Set-Cookie"))
+
+		require.NoError(t, storage.RevokeAPIKey(context.Background(), uid, "ci"))
+		w = serve(token)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
 	})
+
+	t.Run("strict", func(t *testing.T) {
+		strict := ac
+		strict.strict = true
+		strict.cookie = http.Cookie{Path: "/api", MaxAge: 3600, HttpOnly: true, Secure: true, SameSite: http.SameSiteStrictMode}
+
+		serve := func(userScoped bool, cookie *http.Cookie) *httptest.ResponseRecorder {
+			r := httptest.NewRequest("GET", "/api/user/urls", nil)
+			if cookie != nil {
+				r.AddCookie(cookie)
+			}
+			w := httptest.NewRecorder()
+
+			mw := authMiddleware(strict, userScoped)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.NotNil(t, auth.UIDFromContext(r.Context()))
+			}))
+			mw.ServeHTTP(w, r)
+			return w
+		}
+
+		w := serve(true, nil)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Empty(t, w.Header().Get("Set-Cookie"))
+		assert.Equal(t, app.ProblemContentType, w.Header().Get("Content-Type"))
+		assert.Contains(t, w.Body.String(), `"code":"unauthorized"`)
+
+		w = serve(true, &http.Cookie{Name: "auth", Value: "ololo"})
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		// anonymous users still get identity on public routes
+		w = serve(false, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		cookies := w.Result().Cookies()
+		require.Len(t, cookies, 1)
+		assert.Equal(t, "auth", cookies[0].Name)
+		assert.Equal(t, "/api", cookies[0].Path)
+		assert.Equal(t, 3600, cookies[0].MaxAge)
+		assert.True(t, cookies[0].HttpOnly)
+		assert.True(t, cookies[0].Secure)
+		assert.Equal(t, http.SameSiteStrictMode, cookies[0].SameSite)
+
+		w = serve(true, cookies[0])
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("Set-Cookie"))
+	})
+}
+
+func Benchmark_authMiddleware(b *testing.B) {
+	codec, err := auth.NewCodec("new", auth.Key{ID: "new", Secret: []byte("0123456789abcdef")})
+	if err != nil {
+		b.Fatal(err)
+	}
+	storage := store.NewInMemory()
+	svc := service.New("http://localhost:8080", storage)
+	ac := authConfig{codec: codec, keys: svc, cookie: http.Cookie{Path: "/"}, logger: zap.NewNop()}
+	mw := authMiddleware(ac, false)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	uid := uuid.Must(uuid.NewV4())
+	cookie, err := codec.EncodeUIDToHex(uid)
+	if err != nil {
+		b.Fatal(err)
+	}
+	token, hash, err := auth.NewAPIKey()
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := storage.SaveAPIKey(context.Background(), store.APIKey{ID: "bench", UID: uid, Name: "bench", Hash: hash}); err != nil {
+		b.Fatal(err)
+	}
+
+	benchCases := []struct {
+		name    string
+		prepare func(r *http.Request)
+	}{
+		{name: "no_cookie", prepare: func(r *http.Request) {}},
+		{name: "cookie", prepare: func(r *http.Request) {
+			r.AddCookie(&http.Cookie{Name: authCookieName, Value: cookie})
+		}},
+		{name: "api_key", prepare: func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}},
+	}
+
+	for _, bc := range benchCases {
+		b.Run(bc.name, func(b *testing.B) {
+			r := httptest.NewRequest("GET", "/api/user/urls", nil)
+			bc.prepare(r)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				mw.ServeHTTP(httptest.NewRecorder(), r)
+			}
+		})
+	}
+}
+
+func Benchmark_gzipMiddleware(b *testing.B) {
+	var sb strings.Builder
+	sb.WriteString("[")
+	for n := 0; n < 100; n++ {
+		if n > 0 {
+			sb.WriteString(",")
+		}
+		fmt.Fprintf(&sb, `{"short_url":"http://localhost:8080/%x","original_url":"https://praktikum.yandex.ru/%d"}`, n, n)
+	}
+	sb.WriteString("]")
+	body := []byte(sb.String())
+
+	var gzipped bytes.Buffer
+	zw := gzip.NewWriter(&gzipped)
+	_, _ = zw.Write(body)
+	_ = zw.Close()
+
+	mw := gzipMiddleware(metrics.New())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(body)
+	}))
+
+	benchCases := []struct {
+		name           string
+		acceptEncoding string
+		compressedBody bool
+	}{
+		{name: "identity"},
+		{name: "compress_response", acceptEncoding: "gzip"},
+		{name: "decompress_request", compressedBody: true},
+		{name: "both", acceptEncoding: "gzip", compressedBody: true},
+	}
+
+	for _, bc := range benchCases {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(body)))
+			for i := 0; i < b.N; i++ {
+				var r *http.Request
+				if bc.compressedBody {
+					r = httptest.NewRequest("POST", "/api/shorten/batch", bytes.NewReader(gzipped.Bytes()))
+					r.Header.Set("Content-Encoding", "gzip")
+				} else {
+					r = httptest.NewRequest("POST", "/api/shorten/batch", bytes.NewReader(body))
+				}
+				if bc.acceptEncoding != "" {
+					r.Header.Set("Accept-Encoding", bc.acceptEncoding)
+				}
+				mw.ServeHTTP(httptest.NewRecorder(), r)
+			}
+		})
+	}
 }
@@ -0,0 +1,58 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"github.com/Yandex-Praktikum/go-profilable-shortener/models"
+)
+
+func (i *Instance) CreateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var req models.APIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		i.writeError(w, r, badBody(err))
+		return
+	}
+
+	resp, err := i.svc.CreateAPIKey(r.Context(), req.Name)
+	if err != nil {
+		i.writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		i.requestLogger(r).Warn("cannot write response", zap.Error(err))
+	}
+}
+
+func (i *Instance) APIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	resp, err := i.svc.APIKeys(r.Context())
+	if err != nil {
+		i.writeError(w, r, err)
+		return
+	}
+	if len(resp) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		i.requestLogger(r).Warn("cannot write response", zap.Error(err))
+	}
+}
+
+func (i *Instance) RevokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if err := i.svc.RevokeAPIKey(r.Context(), chi.URLParam(r, "id")); err != nil {
+		i.writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,75 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/auth"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/service"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/store"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/models"
+)
+
+func Test_apiKeys(t *testing.T) {
+	uid := uuid.Must(uuid.NewV4())
+	svc := service.New("http://localhost:8080", store.NewInMemory())
+	instance := NewInstance(svc)
+
+	request := func(method, target, body string, uid uuid.UUID, id string) *http.Request {
+		r := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", id)
+		ctx := context.WithValue(r.Context(), chi.RouteCtxKey, rctx)
+		return r.WithContext(auth.Context(ctx, uid))
+	}
+
+	w := httptest.NewRecorder()
+	instance.CreateAPIKeyHandler(w, request("POST", "/api/user/keys", `{"name":""}`, uid, ""))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	instance.CreateAPIKeyHandler(w, request("POST", "/api/user/keys", `{"name":"ci"}`, uid, ""))
+	require.Equal(t, http.StatusCreated, w.Code)
+	var created models.APIKeyResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&created))
+	assert.Equal(t, "ci", created.Name)
+	require.NotEmpty(t, created.Key)
+
+	owner, err := svc.APIKeyUID(context.Background(), created.Key)
+	require.NoError(t, err)
+	assert.Equal(t, uid, owner)
+
+	// token is never shown again
+	w = httptest.NewRecorder()
+	instance.APIKeysHandler(w, request("GET", "/api/user/keys", "", uid, ""))
+	require.Equal(t, http.StatusOK, w.Code)
+	var listed []models.APIKeyResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&listed))
+	require.Len(t, listed, 1)
+	assert.Equal(t, created.ID, listed[0].ID)
+	assert.Empty(t, listed[0].Key)
+
+	w = httptest.NewRecorder()
+	instance.RevokeAPIKeyHandler(w, request("DELETE", "/api/user/keys/"+created.ID, "", uuid.Must(uuid.NewV4()), created.ID))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	instance.RevokeAPIKeyHandler(w, request("DELETE", "/api/user/keys/"+created.ID, "", uid, created.ID))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	_, err = svc.APIKeyUID(context.Background(), created.Key)
+	assert.ErrorIs(t, err, auth.ErrInvalidAPIKey)
+
+	w = httptest.NewRecorder()
+	instance.APIKeysHandler(w, request("GET", "/api/user/keys", "", uid, ""))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
@@ -1,18 +1,48 @@
 package app
 
 import (
-	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/store"
+	"go.uber.org/zap"
+
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/service"
 )
 
+// Instance serves HTTP API of service
 type Instance struct {
-	baseURL string
+	svc *service.Service
+
+	logger *zap.Logger
 
-	store store.AuthStore
+	// batchChunkSize is a number of batch links decoded, saved and encoded at once
+	batchChunkSize int
 }
 
-func NewInstance(baseURL string, storage store.AuthStore) *Instance {
-	return &Instance{
-		baseURL: baseURL,
-		store:   storage,
+// defaultBatchChunkSize keeps memory of batch request independent of its size
+const defaultBatchChunkSize = 1000
+
+// Option tunes Instance
+type Option func(i *Instance)
+
+// WithLogger sets logger of request failures, nothing is logged by default
+func WithLogger(l *zap.Logger) Option {
+	return func(i *Instance) {
+		i.logger = l
+	}
+}
+
+// WithBatchChunkSize sets number of batch links processed at once
+func WithBatchChunkSize(n int) Option {
+	return func(i *Instance) {
+		i.batchChunkSize = n
+	}
+}
+
+func NewInstance(svc *service.Service, opts ...Option) *Instance {
+	i := &Instance{
+		svc:            svc,
+		batchChunkSize: defaultBatchChunkSize,
+	}
+	for _, opt := range opts {
+		opt(i)
 	}
+	return i
 }
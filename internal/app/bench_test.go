@@ -0,0 +1,174 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gofrs/uuid"
+
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/auth"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/service"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/store"
+)
+
+// benchLinks is a number of links owned by benchmark user
+const benchLinks = 100
+
+// benchInstance returns instance over store with benchLinks links of uid which have visits
+func benchInstance(b *testing.B, uid uuid.UUID) (*Instance, []string) {
+	ctx := context.Background()
+	storage := store.NewInMemory()
+
+	urls := make([]*url.URL, benchLinks)
+	for n := range urls {
+		urls[n], _ = url.Parse(fmt.Sprintf("https://praktikum.yandex.ru/%d", n))
+	}
+	ids, err := storage.SaveUserBatch(ctx, uid, urls)
+	if err != nil {
+		b.Fatal(err)
+	}
+	visits := make([]store.Visit, 0, len(ids))
+	for _, id := range ids {
+		visits = append(visits, store.Visit{ID: id, UserAgent: "bench"})
+	}
+	if err := storage.SaveVisits(ctx, visits); err != nil {
+		b.Fatal(err)
+	}
+
+	return NewInstance(service.New("http://localhost:8080", storage)), ids
+}
+
+// benchHandler serves request built by newRequest on every iteration, expected status is checked
+func benchHandler(b *testing.B, h http.HandlerFunc, status int, newRequest func(i int) *http.Request) {
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		r := newRequest(i)
+		w := httptest.NewRecorder()
+		b.StartTimer()
+
+		h(w, r)
+
+		if w.Code != status {
+			b.Fatalf("unexpected status %d: %s", w.Code, w.Body)
+		}
+	}
+}
+
+// benchRequest returns request of uid with id route parameter
+func benchRequest(method, target, body string, uid uuid.UUID, id string) *http.Request {
+	var rd io.Reader = http.NoBody
+	if body != "" {
+		rd = strings.NewReader(body)
+	}
+	r := httptest.NewRequest(method, target, rd)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", id)
+	ctx := context.WithValue(r.Context(), chi.RouteCtxKey, rctx)
+	return r.WithContext(auth.Context(ctx, uid))
+}
+
+func BenchmarkHandlers(b *testing.B) {
+	uid := uuid.Must(uuid.NewV4())
+
+	b.Run("Shorten", func(b *testing.B) {
+		instance, _ := benchInstance(b, uid)
+		benchHandler(b, instance.ShortenHandler, http.StatusCreated, func(i int) *http.Request {
+			return benchRequest("POST", "/", fmt.Sprintf("https://example.com/%d", i), uid, "")
+		})
+	})
+
+	b.Run("ShortenAPI", func(b *testing.B) {
+		instance, _ := benchInstance(b, uid)
+		benchHandler(b, instance.ShortenAPIHandler, http.StatusCreated, func(i int) *http.Request {
+			return benchRequest("POST", "/api/shorten", fmt.Sprintf(`{"url":"https://example.com/%d"}`, i), uid, "")
+		})
+	})
+
+	b.Run("BatchShortenAPI", func(b *testing.B) {
+		instance, _ := benchInstance(b, uid)
+		benchHandler(b, instance.BatchShortenAPIHandler, http.StatusCreated, func(i int) *http.Request {
+			var sb strings.Builder
+			sb.WriteString("[")
+			for n := 0; n < 100; n++ {
+				if n > 0 {
+					sb.WriteString(",")
+				}
+				fmt.Fprintf(&sb, `{"correlation_id":"%d","original_url":"https://example.com/%d/%d"}`, n, i, n)
+			}
+			sb.WriteString("]")
+			return benchRequest("POST", "/api/shorten/batch", sb.String(), uid, "")
+		})
+	})
+
+	b.Run("Expand", func(b *testing.B) {
+		instance, ids := benchInstance(b, uid)
+		benchHandler(b, instance.ExpandHandler, http.StatusTemporaryRedirect, func(i int) *http.Request {
+			id := ids[i%len(ids)]
+			return benchRequest("GET", "/"+id, "", uid, id)
+		})
+	})
+
+	b.Run("UserURLs", func(b *testing.B) {
+		instance, _ := benchInstance(b, uid)
+		benchHandler(b, instance.UserURLsHandler, http.StatusOK, func(int) *http.Request {
+			return benchRequest("GET", "/api/user/urls", "", uid, "")
+		})
+	})
+
+	b.Run("URLStats", func(b *testing.B) {
+		instance, ids := benchInstance(b, uid)
+		benchHandler(b, instance.URLStatsHandler, http.StatusOK, func(i int) *http.Request {
+			id := ids[i%len(ids)]
+			return benchRequest("GET", "/api/user/urls/"+id+"/stats", "", uid, id)
+		})
+	})
+
+	b.Run("BatchRemoveAPI", func(b *testing.B) {
+		instance, ids := benchInstance(b, uid)
+		benchHandler(b, instance.BatchRemoveAPIHandler, http.StatusAccepted, func(i int) *http.Request {
+			return benchRequest("DELETE", "/api/user/urls", fmt.Sprintf(`["%s"]`, ids[i%len(ids)]), uid, "")
+		})
+	})
+
+	b.Run("CreateAPIKey", func(b *testing.B) {
+		instance, _ := benchInstance(b, uid)
+		benchHandler(b, instance.CreateAPIKeyHandler, http.StatusCreated, func(int) *http.Request {
+			return benchRequest("POST", "/api/user/keys", `{"name":"bench"}`, uuid.Must(uuid.NewV4()), "")
+		})
+	})
+
+	b.Run("APIKeys", func(b *testing.B) {
+		instance, _ := benchInstance(b, uid)
+		for n := 0; n < 10; n++ {
+			w := httptest.NewRecorder()
+			instance.CreateAPIKeyHandler(w, benchRequest("POST", "/api/user/keys", `{"name":"bench"}`, uid, ""))
+		}
+		benchHandler(b, instance.APIKeysHandler, http.StatusOK, func(int) *http.Request {
+			return benchRequest("GET", "/api/user/keys", "", uid, "")
+		})
+	})
+
+	b.Run("RevokeAPIKey", func(b *testing.B) {
+		instance, _ := benchInstance(b, uid)
+		// revoking unknown key walks the same path as revoking own one without changing store
+		benchHandler(b, instance.RevokeAPIKeyHandler, http.StatusNotFound, func(int) *http.Request {
+			return benchRequest("DELETE", "/api/user/keys/missing", "", uid, "missing")
+		})
+	})
+
+	b.Run("Ping", func(b *testing.B) {
+		instance, _ := benchInstance(b, uid)
+		benchHandler(b, instance.PingHandler, http.StatusOK, func(int) *http.Request {
+			return benchRequest("GET", "/ping", "", uid, "")
+		})
+	})
+}
@@ -0,0 +1,108 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"go.uber.org/zap"
+
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/service"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/models"
+)
+
+// ProblemContentType is a media type of error documents
+const ProblemContentType = "application/problem+json"
+
+// errorStatuses maps kinds of service errors to HTTP status codes
+var errorStatuses = map[service.Kind]int{
+	service.Internal:    http.StatusInternalServerError,
+	service.Validation:  http.StatusBadRequest,
+	service.NotFound:    http.StatusNotFound,
+	service.Gone:        http.StatusGone,
+	service.Conflict:    http.StatusConflict,
+	service.Forbidden:   http.StatusForbidden,
+	service.Unavailable: http.StatusServiceUnavailable,
+	service.TooLarge:    http.StatusRequestEntityTooLarge,
+}
+
+// badBody returns error reported for request bodies which cannot be decoded,
+// mistyped field is pointed to if decoder knows it
+func badBody(err error) *service.Error {
+	e := &service.Error{Kind: service.Validation, Message: "bad request body given", Err: err}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		e.Details = []service.Detail{{
+			Field:   typeErr.Field,
+			Message: fmt.Sprintf("cannot use %s as %s", typeErr.Value, typeErr.Type),
+		}}
+	}
+	return e
+}
+
+// WantsProblem tells if client should be answered with problem document rather than plain text:
+// API routes always are, other routes only if client accepts JSON
+func WantsProblem(r *http.Request) bool {
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		return true
+	}
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, ProblemContentType) || strings.Contains(accept, "application/json")
+}
+
+// WriteProblem answers request with error, problem document is written
+// if client wants one, message is written as plain text otherwise
+func WriteProblem(w http.ResponseWriter, r *http.Request, status int, code, msg string, details []models.ProblemDetail) error {
+	if !WantsProblem(r) {
+		http.Error(w, msg, status)
+		return nil
+	}
+
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(models.Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    msg,
+		Instance:  r.URL.Path,
+		Code:      code,
+		RequestID: middleware.GetReqID(r.Context()),
+		Errors:    details,
+	})
+}
+
+// writeError answers request with err,
+// internal errors are logged and their causes are not exposed to client
+func (i *Instance) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	i.writeErrorDetails(w, r, err, func(d service.Detail) models.ProblemDetail {
+		return models.ProblemDetail{Index: d.Index, Field: d.Field, Message: d.Message}
+	})
+}
+
+// writeErrorDetails is writeError with details of err converted by detail
+func (i *Instance) writeErrorDetails(w http.ResponseWriter, r *http.Request, err error, detail func(service.Detail) models.ProblemDetail) {
+	kind, msg, details := service.Describe(err)
+	if kind == service.Internal {
+		i.requestLogger(r).Error("request failed", zap.Error(err))
+	}
+	if kind == service.Unavailable {
+		w.Header().Set("Retry-After", "1")
+	}
+	status, ok := errorStatuses[kind]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+
+	var problems []models.ProblemDetail
+	for _, d := range details {
+		problems = append(problems, detail(d))
+	}
+	if err := WriteProblem(w, r, status, kind.String(), msg, problems); err != nil {
+		i.requestLogger(r).Warn("cannot write response", zap.Error(err))
+	}
+}
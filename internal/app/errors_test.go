@@ -0,0 +1,90 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/service"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/store"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/models"
+)
+
+func Test_writeError(t *testing.T) {
+	instance := NewInstance(service.New("http://localhost:8080", store.NewInMemory()))
+	h := middleware.RequestID(http.HandlerFunc(instance.ShortenHandler))
+
+	testCases := []struct {
+		name                string
+		target              string
+		accept              string
+		expectedContentType string
+	}{
+		{
+			name:                "legacy_text",
+			target:              "/",
+			expectedContentType: "text/plain; charset=utf-8",
+		},
+		{
+			name:                "accepts_json",
+			target:              "/",
+			accept:              "application/json",
+			expectedContentType: ProblemContentType,
+		},
+		{
+			name:                "api_route",
+			target:              "/api/shorten",
+			expectedContentType: ProblemContentType,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", tc.target, bytes.NewBufferString("htt_p://o.com"))
+			if tc.accept != "" {
+				r.Header.Set("Accept", tc.accept)
+			}
+			w := httptest.NewRecorder()
+
+			h.ServeHTTP(w, r)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Equal(t, tc.expectedContentType, w.Header().Get("Content-Type"))
+			if tc.expectedContentType != ProblemContentType {
+				assert.Equal(t, "cannot parse given string as URL: htt_p://o.com\n", w.Body.String())
+				return
+			}
+
+			var problem models.Problem
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&problem))
+			assert.Equal(t, "about:blank", problem.Type)
+			assert.Equal(t, http.StatusBadRequest, problem.Status)
+			assert.Equal(t, "validation", problem.Code)
+			assert.Equal(t, tc.target, problem.Instance)
+			assert.NotEmpty(t, problem.RequestID)
+		})
+	}
+}
+
+func Test_badBody(t *testing.T) {
+	instance := NewInstance(service.New("http://localhost:8080", store.NewInMemory()))
+
+	r := httptest.NewRequest("POST", "/api/shorten", bytes.NewBufferString(`{"url":"https://yandex.ru/","ttl":"1h"}`))
+	w := httptest.NewRecorder()
+
+	instance.ShortenAPIHandler(w, r)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var problem models.Problem
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&problem))
+	assert.Equal(t, "bad request body given", problem.Detail)
+	require.Len(t, problem.Errors, 1)
+	assert.Equal(t, "ttl", problem.Errors[0].Field)
+	assert.Equal(t, "cannot use string as int64", problem.Errors[0].Message)
+}
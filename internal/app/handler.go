@@ -1,45 +1,33 @@
 package app
 
 import (
-	"context"
 	"encoding/json"
-	"errors"
-	"fmt"
 	"io"
 	"net/http"
-	"net/url"
+	"strconv"
 
 	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
 
-	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/auth"
-	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/store"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/service"
 	"github.com/Yandex-Praktikum/go-profilable-shortener/models"
 )
 
 func (i *Instance) ShortenHandler(w http.ResponseWriter, r *http.Request) {
 	b, err := io.ReadAll(r.Body)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte("Cannot read request body"))
+		i.writeError(w, r, service.Errorf(service.Validation, "cannot read request body"))
 		return
 	}
 
-	u, err := url.Parse(string(b))
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte("Cannot parse given string as URL"))
-		return
-	}
-
-	shortURL, err := i.shorten(r.Context(), u)
-	if err != nil && !errors.Is(err, store.ErrConflict) {
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(err.Error()))
+	shortURL, err := i.svc.Shorten(r.Context(), service.LinkRequest{URL: string(b)})
+	if err != nil && service.KindOf(err) != service.Conflict {
+		i.writeError(w, r, err)
 		return
 	}
 
 	status := http.StatusCreated
-	if errors.Is(err, store.ErrConflict) {
+	if err != nil {
 		status = http.StatusConflict
 	}
 
@@ -51,29 +39,26 @@ func (i *Instance) ShortenAPIHandler(w http.ResponseWriter, r *http.Request) {
 	var req models.ShortenRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte("Bad request body given"))
-		return
-	}
-
-	u, err := url.Parse(req.URL)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte("Cannot parse given string as URL"))
+		i.writeError(w, r, badBody(err))
 		return
 	}
 
-	shortURL, err := i.shorten(r.Context(), u)
-	if err != nil && !errors.Is(err, store.ErrConflict) {
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(err.Error()))
+	shortURL, err := i.svc.Shorten(r.Context(), service.LinkRequest{
+		URL:       req.URL,
+		Alias:     req.Alias,
+		TTL:       req.TTL,
+		ExpiresAt: req.ExpiresAt,
+	})
+	// conflict without short URL is a taken alias
+	if err != nil && (service.KindOf(err) != service.Conflict || shortURL == "") {
+		i.writeError(w, r, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 
 	status := http.StatusCreated
-	if errors.Is(err, store.ErrConflict) {
+	if err != nil {
 		status = http.StatusConflict
 	}
 
@@ -83,199 +68,248 @@ func (i *Instance) ShortenAPIHandler(w http.ResponseWriter, r *http.Request) {
 	})
 
 	if err != nil {
-		fmt.Printf("cannot write response: %s", err)
+		i.requestLogger(r).Warn("cannot write response", zap.Error(err))
 	}
 }
 
 func (i *Instance) ExpandHandler(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	if id == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte("Bad ID given"))
-		return
-	}
 
-	target, err := i.store.Load(r.Context(), id)
+	target, err := i.svc.Expand(r.Context(), id)
 	if err != nil {
-		if errors.Is(err, store.ErrNotFound) {
-			w.WriteHeader(http.StatusNotFound)
-			return
-		}
-		if errors.Is(err, store.ErrDeleted) {
-			w.WriteHeader(http.StatusGone)
-			return
-		}
-		w.WriteHeader(http.StatusInternalServerError)
+		i.writeError(w, r, err)
 		return
 	}
 
+	i.recordVisit(r, id)
+
 	w.Header().Set("Location", target.String())
 	w.WriteHeader(http.StatusTemporaryRedirect)
 }
 
 func (i *Instance) UserURLsHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-
-	uid := auth.UIDFromContext(ctx)
-	if uid == nil {
-		w.WriteHeader(http.StatusUnprocessableEntity)
+	resp, err := i.svc.UserURLs(r.Context())
+	if err != nil {
+		i.writeError(w, r, err)
 		return
 	}
-
-	urls, err := i.store.LoadUsers(ctx, *uid)
-	if errors.Is(err, store.ErrNotFound) || len(urls) == 0 {
+	if len(resp) == 0 {
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
-	var resp []models.URLResponse
-	for id, u := range urls {
-		resp = append(resp, models.URLResponse{
-			ShortURL:    i.baseURL + "/" + id,
-			OriginalURL: u.String(),
-		})
-	}
 
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(resp)
 }
 
+// BatchShortenAPIHandler decodes, saves and encodes batch chunk by chunk, so memory it takes
+// does not depend on batch size. Chunks of non-partial batch are saved within a single store batch
+// committed after the last chunk. Failed first chunk is answered with problem, failure of a later one
+// can only abort already started response, in which case nothing of the batch is saved.
 func (i *Instance) BatchShortenAPIHandler(w http.ResponseWriter, r *http.Request) {
-	var req []models.BatchShortenRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
+	partial, err := partialMode(r)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte("Bad request body given"))
+		i.writeError(w, r, err)
 		return
 	}
 
-	if len(req) == 0 {
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte("Empty URLs list given"))
+	dec, err := newBatchDecoder(r.Body)
+	if err != nil {
+		i.writeError(w, r, err)
 		return
 	}
 
-	var urls []*url.URL
-	for _, pair := range req {
-		u, err := url.Parse(pair.OriginalURL)
+	// batch stays nil in partial mode, as its items are saved independently
+	var batch *service.Batch
+	if !partial {
+		batch, err = i.svc.BeginBatch(r.Context())
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			msg := fmt.Sprintf("Cannot parse given string as URL: %s", pair.OriginalURL)
-			_, _ = w.Write([]byte(msg))
+			i.writeError(w, r, err)
 			return
 		}
-		urls = append(urls, u)
+		defer batch.Rollback()
 	}
 
-	shortURLs, err := i.shortenBatch(r.Context(), urls)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(err.Error()))
-		return
-	}
+	var aw *arrayWriter
+	for !dec.done {
+		offset := dec.read
+		req, err := dec.next(i.chunkSize(offset))
+		if err == nil {
+			err = i.svc.CheckBatchSize(dec.read)
+		}
+		if err != nil {
+			i.failBatch(w, r, aw != nil, err, chunkDetail(nil, 0))
+			return
+		}
 
-	if len(shortURLs) != len(req) {
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte("invalid shorten URLs length"))
-		return
-	}
+		items, err := i.shortenChunk(r, batch, req)
+		if err != nil {
+			i.failBatch(w, r, aw != nil, err, chunkDetail(req, offset))
+			return
+		}
 
-	res := make([]models.BatchShortenResponse, 0, len(shortURLs))
-	for i, shortURL := range shortURLs {
-		res = append(res, models.BatchShortenResponse{
-			CorrelationID: req[i].CorrelationID,
-			ShortURL:      shortURL,
-		})
+		if aw == nil {
+			status := http.StatusCreated
+			if partial {
+				status = http.StatusMultiStatus
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			aw = newArrayWriter(w)
+		}
+		for _, item := range items {
+			if err := aw.write(item); err != nil {
+				i.failBatch(w, r, true, err, chunkDetail(nil, 0))
+				return
+			}
+		}
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-
-	err = json.NewEncoder(w).Encode(res)
-	if err != nil {
-		fmt.Printf("cannot write response: %s", err)
+	// array is closed only once batch is committed, so client never takes uncommitted one for saved
+	if batch != nil {
+		if err := batch.Commit(r.Context()); err != nil {
+			i.failBatch(w, r, aw != nil, err, chunkDetail(nil, 0))
+			return
+		}
 	}
-}
-
-func (i *Instance) BatchRemoveAPIHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	uid := auth.UIDFromContext(ctx)
-	if uid == nil {
-		w.WriteHeader(http.StatusAccepted)
-		return
+	if err := aw.close(); err != nil {
+		i.requestLogger(r).Warn("cannot write response", zap.Error(err))
 	}
+}
 
-	var ids []string
-	err := json.NewDecoder(r.Body).Decode(&ids)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte("Bad request body given"))
-		return
+// chunkSize returns number of items to read after offset ones,
+// at most one item beyond configured batch size is read
+func (i *Instance) chunkSize(offset int) int {
+	n := i.batchChunkSize
+	if max := i.svc.MaxBatchSize(); max > 0 && max+1-offset < n {
+		n = max + 1 - offset
 	}
+	return n
+}
 
-	if len(ids) == 0 {
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte("Empty IDs list given"))
+// failBatch answers with problem unless response is started, in which case it is aborted,
+// so client cannot take truncated array for complete one
+func (i *Instance) failBatch(w http.ResponseWriter, r *http.Request, started bool, err error, detail func(service.Detail) models.ProblemDetail) {
+	if !started {
+		i.writeErrorDetails(w, r, err, detail)
 		return
 	}
+	i.requestLogger(r).Error("batch failed after response is started", zap.Error(err))
+	panic(http.ErrAbortHandler)
+}
 
-	err = i.store.DeleteUsers(ctx, *uid, ids...)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(err.Error()))
-		return
+// chunkDetail converts details of chunk starting at offset, so they point to items of the whole batch
+func chunkDetail(req []models.BatchShortenRequest, offset int) func(service.Detail) models.ProblemDetail {
+	return func(d service.Detail) models.ProblemDetail {
+		pd := models.ProblemDetail{Field: batchField(d.Field), Message: d.Message}
+		if d.Index != nil {
+			index := offset + *d.Index
+			pd.Index = &index
+			if *d.Index < len(req) {
+				pd.CorrelationID = req[*d.Index].CorrelationID
+			}
+		}
+		return pd
 	}
-
-	w.WriteHeader(http.StatusAccepted)
 }
 
-func (i *Instance) PingHandler(w http.ResponseWriter, r *http.Request) {
-	// ensure everything is okay
-	for j := 0; j < 3; j++ {
-		if err := i.store.Ping(r.Context()); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+// partialMode tells if batch items should be shortened independently of each other
+func partialMode(r *http.Request) (bool, error) {
+	v := r.URL.Query().Get("partial")
+	if v == "" {
+		return false, nil
+	}
+	partial, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, &service.Error{
+			Kind:    service.Validation,
+			Message: "bad partial parameter given",
+			Details: []service.Detail{{Field: "partial", Message: "must be a boolean"}},
 		}
 	}
+	return partial, nil
 }
 
-func (i *Instance) shorten(ctx context.Context, rawURL *url.URL) (shortURL string, err error) {
-	uid := auth.UIDFromContext(ctx)
+// shortenChunk saves chunk of batch and returns its response items. Chunk of non-partial batch
+// is saved with the batch, the first failed item fails it; nil batch means partial mode,
+// in which items are shortened independently of each other.
+func (i *Instance) shortenChunk(r *http.Request, batch *service.Batch, req []models.BatchShortenRequest) ([]interface{}, error) {
+	links := make([]service.LinkRequest, 0, len(req))
+	for _, pair := range req {
+		links = append(links, service.LinkRequest{
+			URL:       pair.OriginalURL,
+			Alias:     pair.Alias,
+			TTL:       pair.TTL,
+			ExpiresAt: pair.ExpiresAt,
+		})
+	}
 
-	var id string
-	if uid != nil {
-		id, err = i.store.SaveUser(ctx, *uid, rawURL)
-	} else {
-		id, err = i.store.Save(ctx, rawURL)
+	items := make([]interface{}, 0, len(req))
+	if batch != nil {
+		shortURLs, err := batch.Shorten(r.Context(), links)
+		if err != nil {
+			return nil, err
+		}
+		for n, shortURL := range shortURLs {
+			items = append(items, models.BatchShortenResponse{
+				CorrelationID: req[n].CorrelationID,
+				ShortURL:      shortURL,
+			})
+		}
+		return items, nil
 	}
 
-	if err != nil && !errors.Is(err, store.ErrConflict) {
-		return "", fmt.Errorf("cannot save URL to storage: %w", err)
+	results, err := i.svc.ShortenEach(r.Context(), links)
+	if err != nil {
+		return nil, err
+	}
+	for n, result := range results {
+		item := models.BatchShortenResult{
+			CorrelationID: req[n].CorrelationID,
+			Status:        string(result.Status),
+			ShortURL:      result.ShortURL,
+		}
+		if result.Err != nil {
+			kind, msg, details := service.Describe(result.Err)
+			if kind == service.Internal {
+				i.requestLogger(r).Error("batch item failed", zap.String("correlation_id", item.CorrelationID), zap.Error(result.Err))
+			}
+			item.Error = &models.ProblemDetail{Message: msg}
+			if len(details) > 0 {
+				item.Error.Field = batchField(details[0].Field)
+			}
+		}
+		items = append(items, item)
 	}
-	return fmt.Sprintf("%s/%s", i.baseURL, id), err
+	return items, nil
 }
 
-func (i *Instance) shortenBatch(ctx context.Context, rawURLs []*url.URL) (shortURLs []string, err error) {
-	uid := auth.UIDFromContext(ctx)
-
-	var ids []string
-	if uid != nil {
-		ids, err = i.store.SaveUserBatch(ctx, *uid, rawURLs)
-	} else {
-		ids, err = i.store.SaveBatch(ctx, rawURLs)
+// batchField maps name of service field to batch request one
+func batchField(field string) string {
+	if field == "url" {
+		return "original_url"
 	}
+	return field
+}
 
+func (i *Instance) BatchRemoveAPIHandler(w http.ResponseWriter, r *http.Request) {
+	var ids []string
+	err := json.NewDecoder(r.Body).Decode(&ids)
 	if err != nil {
-		return nil, fmt.Errorf("cannot save URL to storage: %w", err)
+		i.writeError(w, r, badBody(err))
+		return
 	}
 
-	for _, id := range ids {
-		shortURLs = append(shortURLs, fmt.Sprintf("%s/%s", i.baseURL, id))
+	if err := i.svc.DeleteUserURLs(r.Context(), ids); err != nil {
+		i.writeError(w, r, err)
+		return
 	}
 
-	return shortURLs, nil
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (i *Instance) PingHandler(w http.ResponseWriter, r *http.Request) {
+	if err := i.svc.Ping(r.Context()); err != nil {
+		i.writeError(w, r, err)
+	}
 }
@@ -4,17 +4,23 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/gofrs/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
 
 	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/auth"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/deletion"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/service"
 	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/store"
 	"github.com/Yandex-Praktikum/go-profilable-shortener/models"
 )
@@ -22,10 +28,7 @@ import (
 func Test_ShortenAPIHandler(t *testing.T) {
 	targetURL := "https://praktikum.yandex.ru/"
 
-	instance := &Instance{
-		baseURL: "http://localhost:8080",
-		store:   store.NewInMemory(),
-	}
+	instance := NewInstance(service.New("http://localhost:8080", store.NewInMemory()))
 
 	testCases := []struct {
 		name             string
@@ -37,7 +40,7 @@ func Test_ShortenAPIHandler(t *testing.T) {
 			name:             "bad_request",
 			url:              "htt_p://o.com",
 			expectedStatus:   http.StatusBadRequest,
-			expectedResponse: []byte("Cannot parse given string as URL"),
+			expectedResponse: []byte("{\"type\":\"about:blank\",\"title\":\"Bad Request\",\"status\":400,\"detail\":\"cannot parse given string as URL: htt_p://o.com\",\"instance\":\"/api/shorten\",\"code\":\"validation\",\"errors\":[{\"field\":\"url\",\"message\":\"cannot parse given string as URL: htt_p://o.com\"}]}\n"),
 		},
 		{
 			name:             "success",
@@ -64,17 +67,359 @@ func Test_ShortenAPIHandler(t *testing.T) {
 	}
 }
 
+func Test_alias(t *testing.T) {
+	instance := NewInstance(service.New("http://localhost:8080", store.NewInMemory()))
+
+	testCases := []struct {
+		name             string
+		alias            string
+		expectedStatus   int
+		expectedResponse string
+	}{
+		{
+			name:             "success",
+			alias:            "spring-sale",
+			expectedStatus:   http.StatusCreated,
+			expectedResponse: "{\"result\":\"http://localhost:8080/spring-sale\"}\n",
+		},
+		{
+			name:             "taken",
+			alias:            "spring-sale",
+			expectedStatus:   http.StatusConflict,
+			expectedResponse: "{\"type\":\"about:blank\",\"title\":\"Conflict\",\"status\":409,\"detail\":\"alias \\\"spring-sale\\\" is already taken\",\"instance\":\"/api/shorten\",\"code\":\"conflict\",\"errors\":[{\"field\":\"alias\",\"message\":\"alias \\\"spring-sale\\\" is already taken\"}]}\n",
+		},
+		{
+			name:             "reserved",
+			alias:            "API",
+			expectedStatus:   http.StatusBadRequest,
+			expectedResponse: "{\"type\":\"about:blank\",\"title\":\"Bad Request\",\"status\":400,\"detail\":\"bad alias: \\\"API\\\" is reserved\",\"instance\":\"/api/shorten\",\"code\":\"validation\",\"errors\":[{\"field\":\"alias\",\"message\":\"bad alias: \\\"API\\\" is reserved\"}]}\n",
+		},
+		{
+			name:             "bad_charset",
+			alias:            "spring/sale",
+			expectedStatus:   http.StatusBadRequest,
+			expectedResponse: "{\"type\":\"about:blank\",\"title\":\"Bad Request\",\"status\":400,\"detail\":\"bad alias: only latin letters, digits, '-' and '_' are allowed\",\"instance\":\"/api/shorten\",\"code\":\"validation\",\"errors\":[{\"field\":\"alias\",\"message\":\"bad alias: only latin letters, digits, '-' and '_' are allowed\"}]}\n",
+		},
+		{
+			name:             "too_short",
+			alias:            "ab",
+			expectedStatus:   http.StatusBadRequest,
+			expectedResponse: "{\"type\":\"about:blank\",\"title\":\"Bad Request\",\"status\":400,\"detail\":\"bad alias: length must be from 3 to 32 characters\",\"instance\":\"/api/shorten\",\"code\":\"validation\",\"errors\":[{\"field\":\"alias\",\"message\":\"bad alias: length must be from 3 to 32 characters\"}]}\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b, err := json.Marshal(models.ShortenRequest{URL: "https://praktikum.yandex.ru/", Alias: tc.alias})
+			require.NoError(t, err)
+
+			r := httptest.NewRequest("POST", "http://localhost:8080/api/shorten", bytes.NewBuffer(b))
+			w := httptest.NewRecorder()
+
+			instance.ShortenAPIHandler(w, r)
+
+			assert.Equal(t, tc.expectedStatus, w.Code)
+			assert.Equal(t, tc.expectedResponse, w.Body.String())
+		})
+	}
+
+	t.Run("batch", func(t *testing.T) {
+		b, err := json.Marshal([]models.BatchShortenRequest{
+			{CorrelationID: "1", OriginalURL: "https://yandex.ru/"},
+			{CorrelationID: "2", OriginalURL: "https://yandex.ru/", Alias: "summer-sale"},
+			{CorrelationID: "3", OriginalURL: "https://go.dev/"},
+		})
+		require.NoError(t, err)
+
+		r := httptest.NewRequest("POST", "http://localhost:8080/api/shorten/batch", bytes.NewBuffer(b))
+		w := httptest.NewRecorder()
+
+		instance.BatchShortenAPIHandler(w, r)
+
+		require.Equal(t, http.StatusCreated, w.Code)
+		var resp []models.BatchShortenResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		require.Len(t, resp, 3)
+		assert.Equal(t, "http://localhost:8080/summer-sale", resp[1].ShortURL)
+		assert.Equal(t, "3", resp[2].CorrelationID)
+		assert.NotEqual(t, resp[0].ShortURL, resp[2].ShortURL)
+	})
+
+	t.Run("batch_taken", func(t *testing.T) {
+		b, err := json.Marshal([]models.BatchShortenRequest{
+			{CorrelationID: "1", OriginalURL: "https://yandex.ru/", Alias: "spring-sale"},
+		})
+		require.NoError(t, err)
+
+		r := httptest.NewRequest("POST", "http://localhost:8080/api/shorten/batch", bytes.NewBuffer(b))
+		w := httptest.NewRecorder()
+
+		instance.BatchShortenAPIHandler(w, r)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+		var problem models.Problem
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&problem))
+		assert.Equal(t, "conflict", problem.Code)
+		require.Len(t, problem.Errors, 1)
+		assert.Equal(t, "1", problem.Errors[0].CorrelationID)
+		assert.Equal(t, "alias", problem.Errors[0].Field)
+	})
+
+	t.Run("batch_invalid", func(t *testing.T) {
+		b, err := json.Marshal([]models.BatchShortenRequest{
+			{CorrelationID: "a", OriginalURL: "https://yandex.ru/"},
+			{CorrelationID: "b", OriginalURL: "htt_p://o.com"},
+		})
+		require.NoError(t, err)
+
+		r := httptest.NewRequest("POST", "http://localhost:8080/api/shorten/batch", bytes.NewBuffer(b))
+		w := httptest.NewRecorder()
+
+		instance.BatchShortenAPIHandler(w, r)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, ProblemContentType, w.Header().Get("Content-Type"))
+		var problem models.Problem
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&problem))
+		require.Len(t, problem.Errors, 1)
+		require.NotNil(t, problem.Errors[0].Index)
+		assert.Equal(t, 1, *problem.Errors[0].Index)
+		assert.Equal(t, "b", problem.Errors[0].CorrelationID)
+		assert.Equal(t, "original_url", problem.Errors[0].Field)
+	})
+}
+
+func Test_batchPartial(t *testing.T) {
+	instance := NewInstance(service.New("http://localhost:8080", store.NewInMemory()))
+
+	b, err := json.Marshal([]models.BatchShortenRequest{
+		{CorrelationID: "1", OriginalURL: "https://yandex.ru/"},
+		{CorrelationID: "2", OriginalURL: "htt_p://o.com"},
+		{CorrelationID: "3", OriginalURL: "https://yandex.ru/"},
+		{CorrelationID: "4", OriginalURL: "https://go.dev/", Alias: "golang"},
+		{CorrelationID: "5", OriginalURL: "https://ya.ru/", Alias: "golang"},
+	})
+	require.NoError(t, err)
+
+	r := httptest.NewRequest("POST", "http://localhost:8080/api/shorten/batch?partial=true", bytes.NewBuffer(b))
+	w := httptest.NewRecorder()
+
+	instance.BatchShortenAPIHandler(w, r)
+
+	require.Equal(t, http.StatusMultiStatus, w.Code)
+	var resp []models.BatchShortenResult
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	require.Len(t, resp, 5)
+
+	statuses := make([]string, 0, len(resp))
+	for n, item := range resp {
+		assert.Equal(t, strconv.Itoa(n+1), item.CorrelationID)
+		statuses = append(statuses, item.Status)
+	}
+	assert.Equal(t, []string{"created", "invalid", "existing", "created", "conflict"}, statuses)
+	assert.Equal(t, resp[0].ShortURL, resp[2].ShortURL)
+	assert.Equal(t, "http://localhost:8080/golang", resp[3].ShortURL)
+	require.NotNil(t, resp[1].Error)
+	assert.Equal(t, "original_url", resp[1].Error.Field)
+	assert.Equal(t, "cannot parse given string as URL: htt_p://o.com", resp[1].Error.Message)
+	require.NotNil(t, resp[4].Error)
+	assert.Equal(t, "alias", resp[4].Error.Field)
+
+	r = httptest.NewRequest("POST", "http://localhost:8080/api/shorten/batch?partial=maybe", bytes.NewBuffer(b))
+	w = httptest.NewRecorder()
+
+	instance.BatchShortenAPIHandler(w, r)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func Test_batchSize(t *testing.T) {
+	// batch fitting a single chunk is rejected before anything is saved
+	instance := NewInstance(service.New("http://localhost:8080", store.NewInMemory(), service.WithMaxBatchSize(5000)), WithBatchChunkSize(10000))
+
+	batch := func(n int) *http.Request {
+		req := make([]models.BatchShortenRequest, n)
+		for i := range req {
+			req[i] = models.BatchShortenRequest{CorrelationID: strconv.Itoa(i), OriginalURL: "https://yandex.ru/" + strconv.Itoa(i)}
+		}
+		b, err := json.Marshal(req)
+		require.NoError(t, err)
+		return httptest.NewRequest("POST", "http://localhost:8080/api/shorten/batch", bytes.NewBuffer(b))
+	}
+
+	w := httptest.NewRecorder()
+	instance.BatchShortenAPIHandler(w, batch(5000))
+	require.Equal(t, http.StatusCreated, w.Code)
+	var resp []models.BatchShortenResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	require.Len(t, resp, 5000)
+	assert.Equal(t, "4999", resp[4999].CorrelationID)
+
+	w = httptest.NewRecorder()
+	instance.BatchShortenAPIHandler(w, batch(5001))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"too_large"`)
+}
+
+func Test_batchChunks(t *testing.T) {
+	storage := store.NewInMemory()
+	instance := NewInstance(service.New("http://localhost:8080", storage, service.WithMaxBatchSize(5)), WithBatchChunkSize(2))
+
+	batch := func(query string, req ...models.BatchShortenRequest) *http.Request {
+		b, err := json.Marshal(req)
+		require.NoError(t, err)
+		return httptest.NewRequest("POST", "http://localhost:8080/api/shorten/batch"+query, bytes.NewBuffer(b))
+	}
+	item := func(n int) models.BatchShortenRequest {
+		return models.BatchShortenRequest{CorrelationID: strconv.Itoa(n), OriginalURL: "https://yandex.ru/" + strconv.Itoa(n)}
+	}
+
+	w := httptest.NewRecorder()
+	instance.BatchShortenAPIHandler(w, batch("", item(0), item(1), item(2), item(3), item(4)))
+	require.Equal(t, http.StatusCreated, w.Code)
+	var resp []models.BatchShortenResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	require.Len(t, resp, 5)
+	for n, item := range resp {
+		assert.Equal(t, strconv.Itoa(n), item.CorrelationID)
+	}
+
+	w = httptest.NewRecorder()
+	instance.BatchShortenAPIHandler(w, batch("?partial=true", item(0), item(5), item(6)))
+	require.Equal(t, http.StatusMultiStatus, w.Code)
+	var results []models.BatchShortenResult
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&results))
+	require.Len(t, results, 3)
+	assert.Equal(t, "existing", results[0].Status)
+	assert.Equal(t, "created", results[2].Status)
+
+	// failure of the first chunk is answered with problem pointing to item of the whole batch
+	bad := models.BatchShortenRequest{CorrelationID: "bad", OriginalURL: "htt_p://o.com"}
+	w = httptest.NewRecorder()
+	instance.BatchShortenAPIHandler(w, batch("", item(7), bad))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var problem models.Problem
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&problem))
+	require.Len(t, problem.Errors, 1)
+	require.NotNil(t, problem.Errors[0].Index)
+	assert.Equal(t, 1, *problem.Errors[0].Index)
+	assert.Equal(t, "bad", problem.Errors[0].CorrelationID)
+
+	// failure of a later chunk aborts started response and leaves earlier chunks unsaved
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		instance.BatchShortenAPIHandler(httptest.NewRecorder(), batch("", item(8), item(9), bad))
+	})
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		instance.BatchShortenAPIHandler(httptest.NewRecorder(), batch("", item(10), item(11), item(12), item(13), item(14), item(15)))
+	})
+	for n := 7; n <= 15; n++ {
+		u, err := url.Parse(item(n).OriginalURL)
+		require.NoError(t, err)
+		_, err = storage.Save(context.Background(), u)
+		assert.NoError(t, err, "item %d is saved", n)
+	}
+}
+
+func Test_batchAtomic(t *testing.T) {
+	storage := store.NewInMemory()
+	instance := NewInstance(service.New("http://localhost:8080", storage))
+
+	const size = 1500
+	req := make([]models.BatchShortenRequest, 0, size)
+	for n := 0; n < size; n++ {
+		req = append(req, models.BatchShortenRequest{CorrelationID: strconv.Itoa(n), OriginalURL: "https://yandex.ru/" + strconv.Itoa(n)})
+	}
+	req[1200].OriginalURL = "htt_p://o.com"
+	b, err := json.Marshal(req)
+	require.NoError(t, err)
+
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		r := httptest.NewRequest("POST", "http://localhost:8080/api/shorten/batch", bytes.NewBuffer(b))
+		instance.BatchShortenAPIHandler(httptest.NewRecorder(), r)
+	})
+
+	// none of items is stored, not even ones of the first chunk
+	for n, item := range req {
+		if n == 1200 {
+			continue
+		}
+		u, err := url.Parse(item.OriginalURL)
+		require.NoError(t, err)
+		_, err = storage.Save(context.Background(), u)
+		require.NoError(t, err, "item %d is saved", n)
+	}
+}
+
+func Test_expiry(t *testing.T) {
+	instance := NewInstance(service.New("http://localhost:8080", store.NewInMemory()))
+	past := time.Now().Add(-time.Hour)
+
+	testCases := []struct {
+		name           string
+		req            models.ShortenRequest
+		expectedStatus int
+	}{
+		{
+			name:           "ttl",
+			req:            models.ShortenRequest{URL: "https://yandex.ru/", TTL: 60},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "negative_ttl",
+			req:            models.ShortenRequest{URL: "https://yandex.ru/", TTL: -1},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "max_ttl",
+			req:            models.ShortenRequest{URL: "https://yandex.ru/", TTL: service.MaxTTL},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "ttl_above_max",
+			req:            models.ShortenRequest{URL: "https://yandex.ru/", TTL: service.MaxTTL + 1},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "overflowing_ttl",
+			req:            models.ShortenRequest{URL: "https://yandex.ru/", TTL: math.MaxInt64},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "past_expires_at",
+			req:            models.ShortenRequest{URL: "https://yandex.ru/", ExpiresAt: &past},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "both",
+			req:            models.ShortenRequest{URL: "https://yandex.ru/", TTL: 60, ExpiresAt: &past},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b, err := json.Marshal(tc.req)
+			require.NoError(t, err)
+
+			r := httptest.NewRequest("POST", "http://localhost:8080/api/shorten", bytes.NewBuffer(b))
+			w := httptest.NewRecorder()
+
+			instance.ShortenAPIHandler(w, r)
+
+			assert.Equal(t, tc.expectedStatus, w.Code)
+		})
+	}
+}
+
 func Test_expander(t *testing.T) {
 	expectedURL := "https://praktikum.yandex.ru/"
 	parsedURL, _ := url.Parse(expectedURL)
 
 	storage := store.NewInMemory()
 	id, _ := storage.Save(context.Background(), parsedURL)
+	expiredID, _ := storage.SaveLink(context.Background(), store.Link{URL: parsedURL, ExpiresAt: time.Now().Add(-time.Second)})
 
-	instance := &Instance{
-		baseURL: "http://localhost:8080",
-		store:   storage,
-	}
+	instance := NewInstance(service.New("http://localhost:8080", storage))
 
 	testCases := []struct {
 		name             string
@@ -94,6 +439,12 @@ func Test_expander(t *testing.T) {
 			expectedStatus:   http.StatusNotFound,
 			expectedLocation: "",
 		},
+		{
+			name:             "expired",
+			id:               expiredID,
+			expectedStatus:   http.StatusGone,
+			expectedLocation: "",
+		},
 		{
 			name:             "success",
 			id:               id,
@@ -126,10 +477,7 @@ func Test_userURLs(t *testing.T) {
 	storage := store.NewInMemory()
 	id, _ := storage.SaveUser(context.Background(), uid, u)
 
-	instance := &Instance{
-		baseURL: "http://localhost:8080",
-		store:   storage,
-	}
+	instance := NewInstance(service.New("http://localhost:8080", storage))
 
 	testCases := []struct {
 		name           string
@@ -140,8 +488,8 @@ func Test_userURLs(t *testing.T) {
 		{
 			name:           "no_uid",
 			ctx:            context.Background(),
-			expectedStatus: http.StatusUnprocessableEntity,
-			expectedBody:   nil,
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   []byte("{\"type\":\"about:blank\",\"title\":\"Forbidden\",\"status\":403,\"detail\":\"user identity is required\",\"instance\":\"/api/user/urls\",\"code\":\"forbidden\"}\n"),
 		},
 		{
 			name:           "no_urls",
@@ -159,7 +507,7 @@ func Test_userURLs(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			r := httptest.NewRequest("GET", "http://localhost:8080/user/urls", nil)
+			r := httptest.NewRequest("GET", "http://localhost:8080/api/user/urls", nil)
 			r = r.WithContext(tc.ctx)
 
 			w := httptest.NewRecorder()
@@ -170,3 +518,32 @@ func Test_userURLs(t *testing.T) {
 		})
 	}
 }
+
+func Test_batchRemove(t *testing.T) {
+	uid := uuid.Must(uuid.NewV4())
+	u, _ := url.Parse("https://praktikum.yandex.ru/")
+
+	storage := store.NewInMemory()
+	id, _ := storage.SaveUser(context.Background(), uid, u)
+
+	queue := deletion.NewQueue(storage, 1, 1, 100, time.Hour, zap.NewNop())
+	instance := NewInstance(service.New("http://localhost:8080", storage, service.WithDeletionQueue(queue)))
+
+	remove := func() int {
+		r := httptest.NewRequest("DELETE", "http://localhost:8080/api/user/urls", bytes.NewBufferString(`["`+id+`"]`))
+		r = r.WithContext(auth.Context(r.Context(), uid))
+		w := httptest.NewRecorder()
+
+		instance.BatchRemoveAPIHandler(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusAccepted, remove())
+
+	// deletion happens after response
+	require.NoError(t, queue.Close())
+	_, err := storage.Load(context.Background(), id)
+	assert.ErrorIs(t, err, store.ErrDeleted)
+
+	assert.Equal(t, http.StatusServiceUnavailable, remove())
+}
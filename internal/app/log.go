@@ -0,0 +1,17 @@
+package app
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"go.uber.org/zap"
+)
+
+// requestLogger returns logger annotated with ID of request
+func (i *Instance) requestLogger(r *http.Request) *zap.Logger {
+	logger := i.logger
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return logger.With(zap.String("request_id", middleware.GetReqID(r.Context())))
+}
@@ -0,0 +1,41 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/service"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/store"
+)
+
+// brokenStore fails every ping
+type brokenStore struct {
+	store.AuthStore
+}
+
+func (brokenStore) Ping(context.Context) error {
+	return errors.New("connection refused")
+}
+
+func Test_internalError(t *testing.T) {
+	core, logs := observer.New(zapcore.ErrorLevel)
+	instance := NewInstance(service.New("http://localhost:8080", brokenStore{store.NewInMemory()}), WithLogger(zap.New(core)))
+
+	w := httptest.NewRecorder()
+	instance.PingHandler(w, httptest.NewRequest("GET", "/ping", nil))
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.NotContains(t, w.Body.String(), "connection refused")
+
+	require.Equal(t, 1, logs.Len())
+	assert.Equal(t, "cannot ping storage: connection refused", logs.All()[0].ContextMap()["error"])
+}
@@ -0,0 +1,32 @@
+package app
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+func (i *Instance) URLStatsHandler(w http.ResponseWriter, r *http.Request) {
+	resp, err := i.svc.LinkStats(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		i.writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		i.requestLogger(r).Warn("cannot write response", zap.Error(err))
+	}
+}
+
+// recordVisit hands visit over to recorder, it never blocks redirect
+func (i *Instance) recordVisit(r *http.Request, id string) {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	i.svc.RecordVisit(id, r.Referer(), r.UserAgent(), ip)
+}
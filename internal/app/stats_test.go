@@ -0,0 +1,96 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/auth"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/service"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/stats"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/store"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/models"
+)
+
+func Test_urlStats(t *testing.T) {
+	uid := uuid.Must(uuid.NewV4())
+	u, _ := url.Parse("https://praktikum.yandex.ru/")
+
+	storage := store.NewInMemory()
+	id, _ := storage.SaveUser(context.Background(), uid, u)
+
+	recorder := stats.NewRecorder(storage, 10, 10, time.Hour, zap.NewNop())
+	instance := NewInstance(service.New("http://localhost:8080", storage, service.WithRecorder(recorder, []byte("key"))))
+
+	withID := func(ctx context.Context) context.Context {
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", id)
+		return context.WithValue(ctx, chi.RouteCtxKey, rctx)
+	}
+
+	for n := 0; n < 3; n++ {
+		r := httptest.NewRequest("GET", "http://localhost:8080/"+id, nil)
+		r.Header.Set("Referer", "https://ya.ru/")
+		r = r.WithContext(withID(r.Context()))
+		w := httptest.NewRecorder()
+
+		instance.ExpandHandler(w, r)
+		require.Equal(t, http.StatusTemporaryRedirect, w.Code)
+	}
+	// closing recorder flushes buffered visits
+	require.NoError(t, recorder.Close())
+
+	testCases := []struct {
+		name           string
+		ctx            context.Context
+		expectedStatus int
+	}{
+		{
+			name:           "no_uid",
+			ctx:            withID(context.Background()),
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "foreign_url",
+			ctx:            withID(auth.Context(context.Background(), uuid.Must(uuid.NewV4()))),
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "success",
+			ctx:            withID(auth.Context(context.Background(), uid)),
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "http://localhost:8080/api/user/urls/"+id+"/stats", nil)
+			r = r.WithContext(tc.ctx)
+			w := httptest.NewRecorder()
+
+			instance.URLStatsHandler(w, r)
+
+			require.Equal(t, tc.expectedStatus, w.Code)
+			if tc.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var resp models.LinkStatsResponse
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+			assert.Equal(t, "http://localhost:8080/"+id, resp.ShortURL)
+			assert.Equal(t, int64(3), resp.Total)
+			require.Len(t, resp.Daily, 1)
+			assert.Equal(t, time.Now().UTC().Format("2006-01-02"), resp.Daily[0].Date)
+		})
+	}
+}
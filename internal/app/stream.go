@@ -0,0 +1,98 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/Yandex-Praktikum/go-profilable-shortener/models"
+)
+
+// batchDecoder reads JSON array item by item, so batch is decoded in chunks
+// and items beyond configured batch size are never read
+type batchDecoder struct {
+	dec *json.Decoder
+	// read is a number of items decoded so far
+	read int
+	done bool
+}
+
+func newBatchDecoder(r io.Reader) (*batchDecoder, error) {
+	dec := json.NewDecoder(r)
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, badBody(err)
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return nil, badBody(errors.New("array expected"))
+	}
+	return &batchDecoder{dec: dec}, nil
+}
+
+// next returns up to n following items, it returns none once array is over.
+// Malformed item is pointed to by its index in the whole array.
+func (d *batchDecoder) next(n int) ([]models.BatchShortenRequest, error) {
+	if d.done {
+		return nil, nil
+	}
+
+	var reqs []models.BatchShortenRequest
+	for len(reqs) < n && d.dec.More() {
+		var req models.BatchShortenRequest
+		if err := d.dec.Decode(&req); err != nil {
+			e := badBody(err)
+			index := d.read
+			for k := range e.Details {
+				e.Details[k].Index = &index
+			}
+			return nil, e
+		}
+		reqs = append(reqs, req)
+		d.read++
+	}
+
+	if !d.dec.More() {
+		if _, err := d.dec.Token(); err != nil {
+			return nil, badBody(err)
+		}
+		d.done = true
+	}
+	return reqs, nil
+}
+
+// arrayWriter writes JSON array item by item, so large responses are not buffered entirely
+type arrayWriter struct {
+	w *bufio.Writer
+	n int
+}
+
+func newArrayWriter(w io.Writer) *arrayWriter {
+	return &arrayWriter{w: bufio.NewWriter(w)}
+}
+
+func (a *arrayWriter) write(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	sep := byte(',')
+	if a.n == 0 {
+		sep = '['
+	}
+	a.n++
+	_ = a.w.WriteByte(sep)
+	// write errors are sticky and reported by close
+	_, _ = a.w.Write(b)
+	return nil
+}
+
+// close ends array and flushes buffered items
+func (a *arrayWriter) close() error {
+	if a.n == 0 {
+		_ = a.w.WriteByte('[')
+	}
+	_, _ = a.w.WriteString("]\n")
+	return a.w.Flush()
+}
@@ -0,0 +1,73 @@
+package app
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/service"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/models"
+)
+
+func Test_batchDecoder(t *testing.T) {
+	dec, err := newBatchDecoder(strings.NewReader(`[{"correlation_id":"1","original_url":"https://ya.ru/"},{"correlation_id":"2"},{"correlation_id":"3"}]`))
+	require.NoError(t, err)
+	reqs, err := dec.next(2)
+	require.NoError(t, err)
+	require.Len(t, reqs, 2)
+	assert.Equal(t, "https://ya.ru/", reqs[0].OriginalURL)
+	assert.False(t, dec.done)
+	reqs, err = dec.next(2)
+	require.NoError(t, err)
+	require.Len(t, reqs, 1)
+	assert.Equal(t, "3", reqs[0].CorrelationID)
+	assert.True(t, dec.done)
+	reqs, err = dec.next(2)
+	require.NoError(t, err)
+	assert.Empty(t, reqs)
+
+	// items beyond requested ones are not read
+	dec, err = newBatchDecoder(strings.NewReader(`[{"correlation_id":"1"},{"correlation_id":"2"},garbage`))
+	require.NoError(t, err)
+	reqs, err = dec.next(2)
+	require.NoError(t, err)
+	assert.Len(t, reqs, 2)
+
+	for _, body := range []string{`{"correlation_id":"1"}`, `null`, ``} {
+		_, err = newBatchDecoder(strings.NewReader(body))
+		assert.Equal(t, service.Validation, service.KindOf(err), "body %q", body)
+	}
+	dec, err = newBatchDecoder(strings.NewReader(`[{"correlation_id":"1"}`))
+	require.NoError(t, err)
+	_, err = dec.next(2)
+	assert.Equal(t, service.Validation, service.KindOf(err))
+
+	// mistyped item is pointed to by index in the whole array
+	dec, err = newBatchDecoder(strings.NewReader(`[{"correlation_id":"1"},{"correlation_id":"2","ttl":"1h"}]`))
+	require.NoError(t, err)
+	_, err = dec.next(1)
+	require.NoError(t, err)
+	_, err = dec.next(1)
+	_, _, details := service.Describe(err)
+	require.Len(t, details, 1)
+	require.NotNil(t, details[0].Index)
+	assert.Equal(t, 1, *details[0].Index)
+	assert.Equal(t, "ttl", details[0].Field)
+}
+
+func Test_arrayWriter(t *testing.T) {
+	var buf bytes.Buffer
+	aw := newArrayWriter(&buf)
+	require.NoError(t, aw.close())
+	assert.Equal(t, "[]\n", buf.String())
+
+	buf.Reset()
+	aw = newArrayWriter(&buf)
+	require.NoError(t, aw.write(models.BatchShortenResponse{CorrelationID: "1", ShortURL: "http://localhost:8080/a"}))
+	require.NoError(t, aw.write(models.BatchShortenResponse{CorrelationID: "2", ShortURL: "http://localhost:8080/b"}))
+	require.NoError(t, aw.close())
+	assert.Equal(t, `[{"correlation_id":"1","short_url":"http://localhost:8080/a"},{"correlation_id":"2","short_url":"http://localhost:8080/b"}]`+"\n", buf.String())
+}
@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// apiKeyPrefix makes tokens recognizable in configs and leaked logs
+const apiKeyPrefix = "shk_"
+
+// ErrInvalidAPIKey is returned for unknown and revoked API keys
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+// NewAPIKey returns random bearer token along with its hash to be stored
+func NewAPIKey() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", "", fmt.Errorf("cannot generate API key: %w", err)
+	}
+	token = apiKeyPrefix + hex.EncodeToString(b)
+	return token, HashAPIKey(token), nil
+}
+
+// HashAPIKey returns hex encoded SHA-256 of token,
+// tokens are random enough for plain hash to be safe
+func HashAPIKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// BearerToken returns token of Authorization header, ok is false if there is no bearer token
+func BearerToken(header string) (token string, ok bool) {
+	const scheme = "Bearer "
+	if len(header) < len(scheme) || !strings.EqualFold(header[:len(scheme)], scheme) {
+		return "", false
+	}
+	return strings.TrimSpace(header[len(scheme):]), true
+}
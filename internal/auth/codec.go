@@ -10,56 +10,146 @@ import (
 	"io"
 
 	"github.com/gofrs/uuid"
-
-	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/config"
 )
 
-func EncodeUID(uid uuid.UUID) ([]byte, error) {
-	c, err := aes.NewCipher(config.AuthSecret)
+// ErrUnknownKey is returned for cookies sealed with a key which is not in the ring
+var ErrUnknownKey = errors.New("unknown key")
+
+// Key is a secret of auth cookie encryption
+type Key struct {
+	ID     string
+	Secret []byte
+	// Legacy key opens cookies of | nonce | sealed UID | format which precedes key IDs,
+	// it never seals new cookies and its ID is not used
+	Legacy bool
+}
+
+// Codec encrypts user IDs for auth cookies with a ring of keys.
+// Cookie is | uint8 len | key ID | nonce | sealed UID |, key ID is authenticated as additional data.
+type Codec struct {
+	activeID string
+	active   cipher.AEAD
+	keys     map[string]cipher.AEAD
+	legacy   cipher.AEAD
+}
+
+// NewCodec returns codec which seals with active key and opens with any of given keys
+func NewCodec(activeID string, keys ...Key) (*Codec, error) {
+	c := &Codec{
+		activeID: activeID,
+		keys:     make(map[string]cipher.AEAD, len(keys)),
+	}
+	for _, key := range keys {
+		if key.Legacy {
+			if c.legacy != nil {
+				return nil, errors.New("only one legacy key is allowed")
+			}
+			gcm, err := newGCM(key.Secret)
+			if err != nil {
+				return nil, fmt.Errorf("legacy key: %w", err)
+			}
+			c.legacy = gcm
+			continue
+		}
+
+		if len(key.ID) == 0 || len(key.ID) > 255 {
+			return nil, fmt.Errorf("key ID %q must be from 1 to 255 bytes long", key.ID)
+		}
+		if _, ok := c.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate key ID %q", key.ID)
+		}
+
+		gcm, err := newGCM(key.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", key.ID, err)
+		}
+		c.keys[key.ID] = gcm
+	}
+
+	active, ok := c.keys[activeID]
+	if !ok {
+		return nil, fmt.Errorf("active key %q: %w", activeID, ErrUnknownKey)
+	}
+	c.active = active
+	return c, nil
+}
+
+func newGCM(secret []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(secret)
 	if err != nil {
 		return nil, fmt.Errorf("cannot create new cipher: %w", err)
 	}
-
-	gcm, err := cipher.NewGCM(c)
+	gcm, err := cipher.NewGCM(block)
 	if err != nil {
 		return nil, fmt.Errorf("cannot create gcm from cipher: %w", err)
 	}
+	return gcm, nil
+}
+
+func (c *Codec) EncodeUID(uid uuid.UUID) ([]byte, error) {
+	headerSize := 1 + len(c.activeID)
+	buf := make([]byte, headerSize+c.active.NonceSize(), headerSize+c.active.NonceSize()+len(uid)+c.active.Overhead())
+	buf[0] = byte(len(c.activeID))
+	copy(buf[1:], c.activeID)
 
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+	header, nonce := buf[:headerSize], buf[headerSize:]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
 		return nil, fmt.Errorf("cannot populate nonce: %w", err)
 	}
 
-	cyphertext := gcm.Seal(nonce, nonce, uid.Bytes(), nil)
+	cyphertext := c.active.Seal(buf, nonce, uid.Bytes(), header)
 	return cyphertext, nil
 }
 
-func EncodeUIDToHex(uid uuid.UUID) (string, error) {
-	b, err := EncodeUID(uid)
+func (c *Codec) EncodeUIDToHex(uid uuid.UUID) (string, error) {
+	b, err := c.EncodeUID(uid)
 	if err != nil {
 		return "", fmt.Errorf("cannot encode uid: %w", err)
 	}
 	return hex.EncodeToString(b), nil
 }
 
-func DecodeUID(ciphertext []byte) (*uuid.UUID, error) {
-	c, err := aes.NewCipher(config.AuthSecret)
-	if err != nil {
-		return nil, fmt.Errorf("cannot create new cipher: %w", err)
+// DecodeUID opens cookie, stale reports whether it is sealed with other than active key.
+// Cookie which cannot be opened by key ring is tried as legacy one, such cookie is always stale.
+func (c *Codec) DecodeUID(ciphertext []byte) (uid *uuid.UUID, stale bool, err error) {
+	uid, stale, err = c.decodeKeyed(ciphertext)
+	if err != nil && c.legacy != nil {
+		if legacyUID, legacyErr := openUID(c.legacy, ciphertext, nil); legacyErr == nil {
+			return legacyUID, true, nil
+		}
 	}
+	return uid, stale, err
+}
 
-	gcm, err := cipher.NewGCM(c)
+func (c *Codec) decodeKeyed(ciphertext []byte) (uid *uuid.UUID, stale bool, err error) {
+	if len(ciphertext) == 0 || len(ciphertext) < 1+int(ciphertext[0]) {
+		return nil, false, errors.New("bad key ID size")
+	}
+	headerSize := 1 + int(ciphertext[0])
+	header, ciphertext := ciphertext[:headerSize], ciphertext[headerSize:]
+	keyID := string(header[1:])
+
+	gcm, ok := c.keys[keyID]
+	if !ok {
+		return nil, false, fmt.Errorf("key %q: %w", keyID, ErrUnknownKey)
+	}
+
+	uid, err = openUID(gcm, ciphertext, header)
 	if err != nil {
-		return nil, fmt.Errorf("cannot create gcm from cipher: %w", err)
+		return nil, false, err
 	}
+	return uid, keyID != c.activeID, nil
+}
 
+// openUID opens | nonce | sealed UID | authenticated along with additional data
+func openUID(gcm cipher.AEAD, ciphertext, additionalData []byte) (*uuid.UUID, error) {
 	nonceSize := gcm.NonceSize()
 	if len(ciphertext) < nonceSize {
 		return nil, errors.New("bad nonce size")
 	}
 
 	nonce, ciphertext := ciphertext[:nonceSize], ciphertext[nonceSize:]
-	rawUID, err := gcm.Open(nil, nonce, ciphertext, nil)
+	rawUID, err := gcm.Open(nil, nonce, ciphertext, additionalData)
 	if err != nil {
 		return nil, fmt.Errorf("cannot decode cyphertest: %w", err)
 	}
@@ -71,10 +161,10 @@ func DecodeUID(ciphertext []byte) (*uuid.UUID, error) {
 	return &uid, nil
 }
 
-func DecodeUIDFromHex(s string) (*uuid.UUID, error) {
+func (c *Codec) DecodeUIDFromHex(s string) (uid *uuid.UUID, stale bool, err error) {
 	h, err := hex.DecodeString(s)
 	if err != nil {
-		return nil, fmt.Errorf("cannot decode hex string to bytes: %w", err)
+		return nil, false, fmt.Errorf("cannot decode hex string to bytes: %w", err)
 	}
-	return DecodeUID(h)
+	return c.DecodeUID(h)
 }
@@ -1,17 +1,131 @@
 package auth
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
 	"testing"
 
 	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+var (
+	oldKey = Key{ID: "2021-01", Secret: []byte("ololo-trololo-shimba-boomba-look")}
+	newKey = Key{ID: "2021-06", Secret: []byte("0123456789abcdef")}
+)
+
+// legacyCookie seals uid the way cookies were sealed before key IDs: | nonce | sealed UID |
+func legacyCookie(t *testing.T, secret []byte, uid uuid.UUID) string {
+	block, err := aes.NewCipher(secret)
+	require.NoError(t, err)
+	gcm, err := cipher.NewGCM(block)
+	require.NoError(t, err)
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	require.NoError(t, err)
+	return hex.EncodeToString(gcm.Seal(nonce, nonce, uid.Bytes(), nil))
+}
+
+func TestCodec(t *testing.T) {
+	uid := uuid.Must(uuid.NewV4())
+
+	old, err := NewCodec(oldKey.ID, oldKey)
+	require.NoError(t, err)
+	oldCookie, err := old.EncodeUIDToHex(uid)
+	require.NoError(t, err)
+
+	rotated, err := NewCodec(newKey.ID, newKey, oldKey)
+	require.NoError(t, err)
+
+	t.Run("active_key", func(t *testing.T) {
+		cookie, err := rotated.EncodeUIDToHex(uid)
+		require.NoError(t, err)
+
+		decoded, stale, err := rotated.DecodeUIDFromHex(cookie)
+		require.NoError(t, err)
+		assert.Equal(t, uid, *decoded)
+		assert.False(t, stale)
+	})
+
+	t.Run("old_key", func(t *testing.T) {
+		decoded, stale, err := rotated.DecodeUIDFromHex(oldCookie)
+		require.NoError(t, err)
+		assert.Equal(t, uid, *decoded)
+		assert.True(t, stale)
+	})
+
+	t.Run("retired_key", func(t *testing.T) {
+		retired, err := NewCodec(newKey.ID, newKey)
+		require.NoError(t, err)
+
+		_, _, err = retired.DecodeUIDFromHex(oldCookie)
+		assert.ErrorIs(t, err, ErrUnknownKey)
+	})
+
+	t.Run("forged_key_id", func(t *testing.T) {
+		b, err := old.EncodeUID(uid)
+		require.NoError(t, err)
+		// same length ID of other key must not open the cookie
+		copy(b[1:], "2021-06")
+
+		_, _, err = rotated.DecodeUID(b)
+		assert.Error(t, err)
+	})
+
+	t.Run("unknown_active_key", func(t *testing.T) {
+		_, err := NewCodec("2022-01", oldKey)
+		assert.ErrorIs(t, err, ErrUnknownKey)
+	})
+
+	t.Run("legacy_cookie", func(t *testing.T) {
+		legacy := Key{Secret: oldKey.Secret, Legacy: true}
+		cookie := legacyCookie(t, legacy.Secret, uid)
+
+		withLegacy, err := NewCodec(newKey.ID, newKey, legacy)
+		require.NoError(t, err)
+		decoded, stale, err := withLegacy.DecodeUIDFromHex(cookie)
+		require.NoError(t, err)
+		assert.Equal(t, uid, *decoded)
+		assert.True(t, stale)
+
+		// cookies of the current format are still opened by their keys
+		fresh, err := withLegacy.EncodeUIDToHex(uid)
+		require.NoError(t, err)
+		decoded, stale, err = withLegacy.DecodeUIDFromHex(fresh)
+		require.NoError(t, err)
+		assert.Equal(t, uid, *decoded)
+		assert.False(t, stale)
+
+		_, _, err = rotated.DecodeUIDFromHex(cookie)
+		assert.Error(t, err)
+
+		other, err := NewCodec(newKey.ID, newKey, Key{Secret: newKey.Secret, Legacy: true})
+		require.NoError(t, err)
+		_, _, err = other.DecodeUIDFromHex(cookie)
+		assert.Error(t, err)
+	})
+
+	t.Run("legacy_active_key", func(t *testing.T) {
+		_, err := NewCodec("legacy", Key{ID: "legacy", Secret: oldKey.Secret, Legacy: true})
+		assert.ErrorIs(t, err, ErrUnknownKey)
+	})
+}
+
 func BenchmarkEncodeUID(b *testing.B) {
+	codec, err := NewCodec(oldKey.ID, oldKey)
+	if err != nil {
+		b.Fatal(err)
+	}
 	uid := uuid.Must(uuid.NewV4())
 
 	b.ResetTimer()
 	b.ReportAllocs()
 	for i := 0; i < b.N; i++ {
-		_, _ = EncodeUID(uid)
+		_, _ = codec.EncodeUID(uid)
 	}
 }
@@ -1,39 +1,489 @@
 package config
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
-)
+	"time"
 
-var (
-	RunPort     = ":8080"
-	BaseURL     = "http://localhost:8080/"
-	PersistFile = ""
-	AuthSecret  = []byte("ololo-trololo-shimba-boomba-look")
-	DatabaseDSN = ""
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v3"
 )
 
-func Parse() {
-	flag.StringVar(&RunPort, "a", RunPort, "port to run server")
-	flag.StringVar(&BaseURL, "b", BaseURL, "base URL for shorten URL response")
-	flag.StringVar(&PersistFile, "f", PersistFile, "file to store shorten URLs")
-	flag.StringVar(&DatabaseDSN, "d", DatabaseDSN, "connection string to database")
+// minIPHashKeySize keeps IP hashes from being reversed by brute force of the key
+const minIPHashKeySize = 16
+
+// Config holds all service settings.
+// Every setting is taken from the first source it is set in:
+// environment variables, command line flags, config file and defaults.
+type Config struct {
+	// ConfigFile is a path to JSON or YAML file with settings
+	ConfigFile string `yaml:"-"`
+
+	RunPort     string `yaml:"server_address"`
+	BaseURL     string `yaml:"base_url"`
+	PersistFile string `yaml:"file_storage_path"`
+	DatabaseDSN string `yaml:"database_dsn"`
+
+	// AuthKeys are secrets of auth cookie encryption, retired ones no longer open cookies
+	AuthKeys AuthKeys `yaml:"auth_keys"`
+	// AuthActiveKey is ID of key sealing new cookies, the first non-retired key if empty
+	AuthActiveKey string `yaml:"auth_active_key"`
+	// AuthKeysFile is a path to file with "id:secret" lines which replace AuthKeys
+	AuthKeysFile string `yaml:"auth_keys_file"`
+	// AuthLegacySecret opens cookies issued before key IDs were introduced,
+	// they are re-issued with active key. It is empty unless such cookies must be kept.
+	AuthLegacySecret string `yaml:"auth_legacy_secret"`
+	// StrictAuth makes user scoped routes answer 401 instead of issuing new identity
+	StrictAuth bool `yaml:"strict_auth"`
+	// IPHashKey keys hashes of visitor IPs, it must stay the same for unique visitors
+	// to be counted across restarts. A random key is used if empty.
+	IPHashKey string `yaml:"ip_hash_key"`
+
+	CookiePath     string        `yaml:"cookie_path"`
+	CookieMaxAge   time.Duration `yaml:"cookie_max_age"`
+	CookieHTTPOnly bool          `yaml:"cookie_http_only"`
+	CookieSecure   bool          `yaml:"cookie_secure"`
+	// CookieSameSite is one of lax, strict or none, attribute is omitted if empty
+	CookieSameSite string `yaml:"cookie_same_site"`
+
+	// GRPCAddress is a listener of gRPC API, it is not served if empty
+	GRPCAddress string `yaml:"grpc_address"`
+
+	// MetricsAddress is a separate listener for metrics, they are served by main one if empty
+	MetricsAddress string `yaml:"metrics_address"`
+	MetricsPath    string `yaml:"metrics_path"`
+
+	// DebugAddress is a separate listener for profiling endpoints, they are served by main one if empty
+	DebugAddress string `yaml:"debug_address"`
+	DebugPrefix  string `yaml:"debug_prefix"`
+	// DebugToken and DebugTrustedSubnet let requests in profiling endpoints,
+	// endpoints are not served unless at least one of them is set
+	DebugToken         string `yaml:"debug_token"`
+	DebugTrustedSubnet string `yaml:"debug_trusted_subnet"`
+
+	// ProfileDir is a directory for periodic heap and CPU profiles, they are not written if empty
+	ProfileDir         string        `yaml:"profile_dir"`
+	ProfileInterval    time.Duration `yaml:"profile_interval"`
+	ProfileCPUDuration time.Duration `yaml:"profile_cpu_duration"`
+
+	// LogLevel is one of debug, info, warn or error
+	LogLevel string `yaml:"log_level"`
+	// LogFormat is json or console
+	LogFormat string `yaml:"log_format"`
+	// LogSampleRate is a share of requests logged, failed ones are always logged
+	LogSampleRate float64 `yaml:"log_sample_rate"`
+
+	// RateLimit* limit requests of every client to route groups, clients are told
+	// apart by API key, auth cookie or IP address
+	RateLimitShorten  RateLimit `yaml:"rate_limit_shorten"`
+	RateLimitBatch    RateLimit `yaml:"rate_limit_batch"`
+	RateLimitRedirect RateLimit `yaml:"rate_limit_redirect"`
+	RateLimitUser     RateLimit `yaml:"rate_limit_user"`
+	// RateLimitMaxClients bounds number of clients tracked by every in-process limiter
+	RateLimitMaxClients int `yaml:"rate_limit_max_clients"`
+	// RateLimitShared keeps limits in database, so they are shared by all instances
+	RateLimitShared bool `yaml:"rate_limit_shared"`
+	// TrustedProxies are comma separated CIDRs of proxies whose X-Forwarded-For is trusted
+	TrustedProxies string `yaml:"trusted_proxies"`
+
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+
+	SnapshotInterval time.Duration `yaml:"file_snapshot_interval"`
+	MaxLogSize       int64         `yaml:"file_max_log_size"`
+
+	IDGenerator string `yaml:"id_generator"`
+	IDLength    int    `yaml:"id_length"`
+	IDSalt      string `yaml:"id_salt"`
+
+	ReapInterval     time.Duration `yaml:"reap_interval"`
+	ExpiredRetention time.Duration `yaml:"expired_retention"`
+
+	StatsBufferSize    int           `yaml:"stats_buffer_size"`
+	StatsBatchSize     int           `yaml:"stats_batch_size"`
+	StatsFlushInterval time.Duration `yaml:"stats_flush_interval"`
+
+	DeleteQueueSize     int           `yaml:"delete_queue_size"`
+	DeleteWorkers       int           `yaml:"delete_workers"`
+	DeleteBatchSize     int           `yaml:"delete_batch_size"`
+	DeleteFlushInterval time.Duration `yaml:"delete_flush_interval"`
+
+	// MaxBatchSize bounds number of links in a single batch request, zero means unlimited
+	MaxBatchSize int `yaml:"max_batch_size"`
+	// InsertChunkSize is a number of URLs inserted to database by a single statement
+	InsertChunkSize int `yaml:"insert_chunk_size"`
+}
+
+// Default returns config with default settings
+func Default() *Config {
+	return &Config{
+		RunPort: ":8080",
+		BaseURL: "http://localhost:8080",
+
+		CookiePath:     "/",
+		CookieHTTPOnly: true,
+		CookieSameSite: "lax",
+
+		MetricsPath: "/metrics",
+
+		DebugPrefix: "/debug/pprof",
+
+		ProfileInterval:    time.Minute,
+		ProfileCPUDuration: 10 * time.Second,
+
+		LogLevel:      "info",
+		LogFormat:     "json",
+		LogSampleRate: 1,
+
+		RateLimitMaxClients: 100000,
+
+		ShutdownTimeout: 10 * time.Second,
+
+		SnapshotInterval: 5 * time.Minute,
+		MaxLogSize:       64 << 20,
+
+		IDLength: 8,
+
+		ReapInterval:     time.Hour,
+		ExpiredRetention: 24 * time.Hour,
+
+		StatsBufferSize:    10000,
+		StatsBatchSize:     500,
+		StatsFlushInterval: time.Second,
+
+		DeleteQueueSize:     1000,
+		DeleteWorkers:       2,
+		DeleteBatchSize:     500,
+		DeleteFlushInterval: 500 * time.Millisecond,
+
+		MaxBatchSize:    10000,
+		InsertChunkSize: 1000,
+	}
+}
+
+// envFlags maps environment variables to flags they override
+var envFlags = []struct {
+	env  string
+	flag string
+}{
+	{env: "CONFIG", flag: "c"},
+	{env: "SERVER_ADDRESS", flag: "a"},
+	{env: "BASE_URL", flag: "b"},
+	{env: "FILE_STORAGE_PATH", flag: "f"},
+	{env: "DATABASE_DSN", flag: "d"},
+	{env: "AUTH_KEYS", flag: "auth-keys"},
+	{env: "AUTH_ACTIVE_KEY", flag: "auth-active-key"},
+	{env: "AUTH_KEYS_FILE", flag: "auth-keys-file"},
+	{env: "AUTH_LEGACY_SECRET", flag: "auth-legacy-secret"},
+	{env: "STRICT_AUTH", flag: "strict-auth"},
+	{env: "IP_HASH_KEY", flag: "ip-hash-key"},
+	{env: "COOKIE_PATH", flag: "cookie-path"},
+	{env: "COOKIE_MAX_AGE", flag: "cookie-max-age"},
+	{env: "COOKIE_HTTP_ONLY", flag: "cookie-http-only"},
+	{env: "COOKIE_SECURE", flag: "cookie-secure"},
+	{env: "COOKIE_SAME_SITE", flag: "cookie-same-site"},
+	{env: "GRPC_ADDRESS", flag: "grpc-address"},
+	{env: "METRICS_ADDRESS", flag: "metrics-address"},
+	{env: "METRICS_PATH", flag: "metrics-path"},
+	{env: "DEBUG_ADDRESS", flag: "debug-address"},
+	{env: "DEBUG_PREFIX", flag: "debug-prefix"},
+	{env: "DEBUG_TOKEN", flag: "debug-token"},
+	{env: "DEBUG_TRUSTED_SUBNET", flag: "debug-trusted-subnet"},
+	{env: "PROFILE_DIR", flag: "profile-dir"},
+	{env: "PROFILE_INTERVAL", flag: "profile-interval"},
+	{env: "PROFILE_CPU_DURATION", flag: "profile-cpu-duration"},
+	{env: "LOG_LEVEL", flag: "log-level"},
+	{env: "LOG_FORMAT", flag: "log-format"},
+	{env: "LOG_SAMPLE_RATE", flag: "log-sample-rate"},
+	{env: "RATE_LIMIT_SHORTEN", flag: "rate-limit-shorten"},
+	{env: "RATE_LIMIT_BATCH", flag: "rate-limit-batch"},
+	{env: "RATE_LIMIT_REDIRECT", flag: "rate-limit-redirect"},
+	{env: "RATE_LIMIT_USER", flag: "rate-limit-user"},
+	{env: "RATE_LIMIT_MAX_CLIENTS", flag: "rate-limit-max-clients"},
+	{env: "RATE_LIMIT_SHARED", flag: "rate-limit-shared"},
+	{env: "TRUSTED_PROXIES", flag: "trusted-proxies"},
+	{env: "SHUTDOWN_TIMEOUT", flag: "shutdown-timeout"},
+	{env: "FILE_SNAPSHOT_INTERVAL", flag: "snapshot-interval"},
+	{env: "FILE_MAX_LOG_SIZE", flag: "max-log-size"},
+	{env: "ID_GENERATOR", flag: "id-generator"},
+	{env: "ID_LENGTH", flag: "id-length"},
+	{env: "ID_SALT", flag: "id-salt"},
+	{env: "REAP_INTERVAL", flag: "reap-interval"},
+	{env: "EXPIRED_RETENTION", flag: "expired-retention"},
+	{env: "STATS_BUFFER_SIZE", flag: "stats-buffer-size"},
+	{env: "STATS_BATCH_SIZE", flag: "stats-batch-size"},
+	{env: "STATS_FLUSH_INTERVAL", flag: "stats-flush-interval"},
+	{env: "DELETE_QUEUE_SIZE", flag: "delete-queue-size"},
+	{env: "DELETE_WORKERS", flag: "delete-workers"},
+	{env: "DELETE_BATCH_SIZE", flag: "delete-batch-size"},
+	{env: "DELETE_FLUSH_INTERVAL", flag: "delete-flush-interval"},
+	{env: "MAX_BATCH_SIZE", flag: "max-batch-size"},
+	{env: "INSERT_CHUNK_SIZE", flag: "insert-chunk-size"},
+}
+
+func (c *Config) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("shortener", flag.ContinueOnError)
+	fs.StringVar(&c.ConfigFile, "c", c.ConfigFile, "JSON or YAML config file")
+	fs.StringVar(&c.RunPort, "a", c.RunPort, "port to run server")
+	fs.StringVar(&c.BaseURL, "b", c.BaseURL, "base URL for shorten URL response")
+	fs.StringVar(&c.PersistFile, "f", c.PersistFile, "file to store shorten URLs")
+	fs.StringVar(&c.DatabaseDSN, "d", c.DatabaseDSN, "connection string to database")
+	fs.Var(&c.AuthKeys, "auth-keys", "comma separated id:secret pairs of auth cookie encryption, secrets are 16, 24 or 32 bytes long")
+	fs.StringVar(&c.AuthActiveKey, "auth-active-key", c.AuthActiveKey, "ID of key encrypting new auth cookies, the first non-retired key if empty")
+	fs.StringVar(&c.AuthKeysFile, "auth-keys-file", c.AuthKeysFile, "file with id:secret line per auth key, replaces keys given otherwise")
+	fs.StringVar(&c.AuthLegacySecret, "auth-legacy-secret", c.AuthLegacySecret, "secret of auth cookies issued before key IDs, they are re-issued with active key")
+	fs.BoolVar(&c.StrictAuth, "strict-auth", c.StrictAuth, "answer 401 on user routes to requests without valid identity")
+	fs.StringVar(&c.IPHashKey, "ip-hash-key", c.IPHashKey, "secret of at least 16 bytes keying hashes of visitor IPs, random if empty")
+	fs.StringVar(&c.CookiePath, "cookie-path", c.CookiePath, "path attribute of auth cookie")
+	fs.DurationVar(&c.CookieMaxAge, "cookie-max-age", c.CookieMaxAge, "lifetime of auth cookie, 0 makes it a session cookie")
+	fs.BoolVar(&c.CookieHTTPOnly, "cookie-http-only", c.CookieHTTPOnly, "hide auth cookie from scripts")
+	fs.BoolVar(&c.CookieSecure, "cookie-secure", c.CookieSecure, "send auth cookie over HTTPS only")
+	fs.StringVar(&c.CookieSameSite, "cookie-same-site", c.CookieSameSite, "SameSite attribute of auth cookie: lax, strict or none, omitted if empty")
+	fs.StringVar(&c.GRPCAddress, "grpc-address", c.GRPCAddress, "address to serve gRPC API on, disabled if empty")
+	fs.StringVar(&c.MetricsAddress, "metrics-address", c.MetricsAddress, "separate address to serve metrics on, main server is used if empty")
+	fs.StringVar(&c.MetricsPath, "metrics-path", c.MetricsPath, "path of Prometheus metrics endpoint")
+	fs.StringVar(&c.DebugAddress, "debug-address", c.DebugAddress, "separate address to serve profiling endpoints on, main server is used if empty")
+	fs.StringVar(&c.DebugPrefix, "debug-prefix", c.DebugPrefix, "path prefix of profiling endpoints")
+	fs.StringVar(&c.DebugToken, "debug-token", c.DebugToken, "token to pass in X-Debug-Token header to access profiling endpoints")
+	fs.StringVar(&c.DebugTrustedSubnet, "debug-trusted-subnet", c.DebugTrustedSubnet, "CIDR of clients allowed to access profiling endpoints")
+	fs.StringVar(&c.ProfileDir, "profile-dir", c.ProfileDir, "directory to write periodic heap and CPU profiles to, disabled if empty")
+	fs.DurationVar(&c.ProfileInterval, "profile-interval", c.ProfileInterval, "how often periodic profiles are written")
+	fs.DurationVar(&c.ProfileCPUDuration, "profile-cpu-duration", c.ProfileCPUDuration, "how long CPU is sampled for each periodic profile")
+	fs.StringVar(&c.LogLevel, "log-level", c.LogLevel, "minimal level of logged messages: debug, info, warn or error")
+	fs.StringVar(&c.LogFormat, "log-format", c.LogFormat, "log format: json or console")
+	fs.Float64Var(&c.LogSampleRate, "log-sample-rate", c.LogSampleRate, "share of requests to log from 0 to 1, failed ones are always logged")
+	fs.Var(&c.RateLimitShorten, "rate-limit-shorten", "rate limit of shortening single URLs per client, e.g. 100/m or 10/s:50, unlimited if empty")
+	fs.Var(&c.RateLimitBatch, "rate-limit-batch", "rate limit of batch shortening per client, unlimited if empty")
+	fs.Var(&c.RateLimitRedirect, "rate-limit-redirect", "rate limit of redirects per client, unlimited if empty")
+	fs.Var(&c.RateLimitUser, "rate-limit-user", "rate limit of user API per client, unlimited if empty")
+	fs.IntVar(&c.RateLimitMaxClients, "rate-limit-max-clients", c.RateLimitMaxClients, "number of clients tracked by in-process rate limiter of every route group")
+	fs.BoolVar(&c.RateLimitShared, "rate-limit-shared", c.RateLimitShared, "keep rate limits in database to share them among instances")
+	fs.StringVar(&c.TrustedProxies, "trusted-proxies", c.TrustedProxies, "comma separated CIDRs of proxies trusted to set X-Forwarded-For")
+	fs.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", c.ShutdownTimeout, "how long in-flight requests are waited for on shutdown")
+	fs.DurationVar(&c.SnapshotInterval, "snapshot-interval", c.SnapshotInterval, "file storage snapshot interval, 0 disables periodic snapshots")
+	fs.Int64Var(&c.MaxLogSize, "max-log-size", c.MaxLogSize, "file storage log size in bytes to trigger snapshot, 0 disables the threshold")
+	fs.StringVar(&c.IDGenerator, "id-generator", c.IDGenerator, "short ID generator: hex, base62, random or hashids, storage default if empty")
+	fs.IntVar(&c.IDLength, "id-length", c.IDLength, "length of random IDs and minimal length of hashids")
+	fs.StringVar(&c.IDSalt, "id-salt", c.IDSalt, "salt of hashids generator")
+	fs.DurationVar(&c.ReapInterval, "reap-interval", c.ReapInterval, "how often expired links are purged, 0 disables purging")
+	fs.DurationVar(&c.ExpiredRetention, "expired-retention", c.ExpiredRetention, "how long expired links are kept before purging")
+	fs.IntVar(&c.StatsBufferSize, "stats-buffer-size", c.StatsBufferSize, "number of visits buffered before new ones are dropped")
+	fs.IntVar(&c.StatsBatchSize, "stats-batch-size", c.StatsBatchSize, "number of visits written to storage at once")
+	fs.DurationVar(&c.StatsFlushInterval, "stats-flush-interval", c.StatsFlushInterval, "how often buffered visits are written to storage")
+	fs.IntVar(&c.DeleteQueueSize, "delete-queue-size", c.DeleteQueueSize, "number of pending deletion requests before new ones are rejected")
+	fs.IntVar(&c.DeleteWorkers, "delete-workers", c.DeleteWorkers, "number of deletion workers")
+	fs.IntVar(&c.DeleteBatchSize, "delete-batch-size", c.DeleteBatchSize, "number of IDs deleted by a single storage call")
+	fs.DurationVar(&c.DeleteFlushInterval, "delete-flush-interval", c.DeleteFlushInterval, "how long deletion requests are coalesced")
+	fs.IntVar(&c.MaxBatchSize, "max-batch-size", c.MaxBatchSize, "number of links in a single batch request before it is rejected, 0 is unlimited")
+	fs.IntVar(&c.InsertChunkSize, "insert-chunk-size", c.InsertChunkSize, "number of URLs inserted to database by a single statement of batch")
+	return fs
+}
+
+// Load builds validated config from command line arguments, environment and config file
+func Load(args []string, getenv func(key string) string) (*Config, error) {
+	// config file has to be known before flags are applied on top of it
+	probe := Default()
+	fs := probe.flagSet()
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil && !errors.Is(err, flag.ErrHelp) {
+		return nil, err
+	}
+	configFile := probe.ConfigFile
+	if val := getenv("CONFIG"); val != "" {
+		configFile = val
+	}
+
+	cfg := Default()
+	if configFile != "" {
+		if err := cfg.readFile(configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	fs = cfg.flagSet()
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	for _, ef := range envFlags {
+		if val := getenv(ef.env); val != "" {
+			if err := fs.Set(ef.flag, val); err != nil {
+				return nil, fmt.Errorf("cannot parse %s: %w", ef.env, err)
+			}
+		}
+	}
+	cfg.BaseURL = strings.TrimRight(cfg.BaseURL, "/")
+	if cfg.AuthKeysFile != "" {
+		b, err := os.ReadFile(cfg.AuthKeysFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read auth keys file: %w", err)
+		}
+		if err := cfg.AuthKeys.Set(string(b)); err != nil {
+			return nil, fmt.Errorf("cannot parse auth keys file: %w", err)
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return cfg, nil
+}
 
-	flag.Parse()
+// readFile applies settings of JSON or YAML file, JSON is read as YAML subset
+func (c *Config) readFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("cannot open config file: %w", err)
+	}
+	defer f.Close()
 
-	if val := os.Getenv("SERVER_ADDRESS"); val != "" {
-		RunPort = val
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("cannot parse config file %s: %w", path, err)
 	}
-	if val := os.Getenv("BASE_URL"); val != "" {
-		BaseURL = val
+	c.ConfigFile = path
+	return nil
+}
+
+// Validate checks settings which would otherwise fail deep inside the service
+func (c *Config) Validate() error {
+	if c.RunPort == "" {
+		return errors.New("server address is empty")
 	}
-	if val := os.Getenv("FILE_STORAGE_PATH"); val != "" {
-		PersistFile = val
+
+	u, err := url.Parse(c.BaseURL)
+	if err != nil {
+		return fmt.Errorf("bad base URL: %w", err)
 	}
-	if val := os.Getenv("DATABASE_DSN"); val != "" {
-		DatabaseDSN = val
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("base URL %q must be absolute http(s) URL", c.BaseURL)
 	}
 
-	BaseURL = strings.TrimRight(BaseURL, "/")
+	if err := c.validateAuthKeys(); err != nil {
+		return err
+	}
+	if c.IPHashKey != "" && len(c.IPHashKey) < minIPHashKeySize {
+		return fmt.Errorf("IP hash key must be at least %d bytes long, got %d", minIPHashKeySize, len(c.IPHashKey))
+	}
+
+	switch c.CookieSameSite {
+	case "", "lax", "strict":
+	case "none":
+		// browsers drop SameSite=None cookies without Secure
+		if !c.CookieSecure {
+			return errors.New("cookie SameSite none requires secure cookie")
+		}
+	default:
+		return fmt.Errorf("unknown cookie SameSite %q", c.CookieSameSite)
+	}
+	if c.CookieMaxAge < 0 {
+		return fmt.Errorf("cookie max age must not be negative, got %s", c.CookieMaxAge)
+	}
+
+	if c.GRPCAddress != "" && (c.GRPCAddress == c.RunPort || c.GRPCAddress == c.MetricsAddress || c.GRPCAddress == c.DebugAddress) {
+		return fmt.Errorf("gRPC address %q must not be shared with HTTP servers", c.GRPCAddress)
+	}
+
+	if !strings.HasPrefix(c.MetricsPath, "/") {
+		return fmt.Errorf("metrics path %q must start with /", c.MetricsPath)
+	}
+
+	if !strings.HasPrefix(c.DebugPrefix, "/") || strings.HasSuffix(c.DebugPrefix, "/") {
+		return fmt.Errorf("debug prefix %q must start and must not end with /", c.DebugPrefix)
+	}
+	if c.DebugTrustedSubnet != "" {
+		if _, _, err := net.ParseCIDR(c.DebugTrustedSubnet); err != nil {
+			return fmt.Errorf("bad debug trusted subnet: %w", err)
+		}
+	}
+	if c.ProfileDir != "" && (c.ProfileCPUDuration <= 0 || c.ProfileCPUDuration >= c.ProfileInterval) {
+		return fmt.Errorf("profile CPU duration must be positive and shorter than interval %s, got %s",
+			c.ProfileInterval, c.ProfileCPUDuration)
+	}
+
+	if _, err := zapcore.ParseLevel(c.LogLevel); err != nil {
+		return fmt.Errorf("bad log level: %w", err)
+	}
+	if c.LogFormat != "json" && c.LogFormat != "console" {
+		return fmt.Errorf("unknown log format %q", c.LogFormat)
+	}
+	if c.LogSampleRate < 0 || c.LogSampleRate > 1 {
+		return fmt.Errorf("log sample rate must be from 0 to 1, got %g", c.LogSampleRate)
+	}
+
+	if c.RateLimitMaxClients <= 0 {
+		return fmt.Errorf("rate limit max clients must be positive, got %d", c.RateLimitMaxClients)
+	}
+	if c.RateLimitShared && c.DatabaseDSN == "" {
+		return errors.New("shared rate limits require database")
+	}
+	if _, err := c.TrustedProxyNets(); err != nil {
+		return err
+	}
+
+	if c.PersistFile != "" && c.DatabaseDSN == "" {
+		if err := checkPersistFile(c.PersistFile); err != nil {
+			return fmt.Errorf("file storage path is not usable: %w", err)
+		}
+	}
+
+	switch c.IDGenerator {
+	case "", "hex", "base62", "hashids":
+	case "random":
+		if c.IDLength < 4 {
+			return fmt.Errorf("random id length must be at least 4, got %d", c.IDLength)
+		}
+	default:
+		return fmt.Errorf("unknown ID generator %q", c.IDGenerator)
+	}
+
+	for name, size := range map[string]int{
+		"stats buffer size": c.StatsBufferSize,
+		"stats batch size":  c.StatsBatchSize,
+		"delete queue size": c.DeleteQueueSize,
+		"delete workers":    c.DeleteWorkers,
+		"delete batch size": c.DeleteBatchSize,
+		"insert chunk size": c.InsertChunkSize,
+	} {
+		if size <= 0 {
+			return fmt.Errorf("%s must be positive, got %d", name, size)
+		}
+	}
+	if c.MaxBatchSize < 0 {
+		return fmt.Errorf("max batch size must not be negative, got %d", c.MaxBatchSize)
+	}
+	for name, d := range map[string]time.Duration{
+		"stats flush interval":  c.StatsFlushInterval,
+		"delete flush interval": c.DeleteFlushInterval,
+	} {
+		if d <= 0 {
+			return fmt.Errorf("%s must be positive, got %s", name, d)
+		}
+	}
+	return nil
+}
+
+// checkPersistFile checks that existing file is writable or that it can be created later,
+// the file itself is left to store construction
+func checkPersistFile(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err == nil {
+		return f.Close()
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	probe, err := os.CreateTemp(filepath.Dir(path), ".shortener-probe-*")
+	if err != nil {
+		return fmt.Errorf("cannot create file in storage directory: %w", err)
+	}
+	_ = probe.Close()
+	return os.Remove(probe.Name())
 }
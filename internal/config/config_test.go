@@ -0,0 +1,275 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func envOf(vars map[string]string) func(string) string {
+	return func(key string) string {
+		return vars[key]
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		cfg, err := Load(nil, envOf(nil))
+		require.NoError(t, err)
+		assert.Equal(t, Default(), cfg)
+	})
+
+	t.Run("json_file", func(t *testing.T) {
+		path := writeFile(t, "config.json", `{
+	"server_address": ":9090",
+	"base_url": "https://sho.rt/",
+	"stats_flush_interval": "5s"
+}`)
+
+		cfg, err := Load([]string{"-c", path}, envOf(nil))
+		require.NoError(t, err)
+		assert.Equal(t, ":9090", cfg.RunPort)
+		assert.Equal(t, "https://sho.rt", cfg.BaseURL)
+		assert.Equal(t, 5*time.Second, cfg.StatsFlushInterval)
+		assert.Equal(t, 500, cfg.StatsBatchSize)
+	})
+
+	t.Run("yaml_file", func(t *testing.T) {
+		path := writeFile(t, "config.yaml", "server_address: \":9090\"\ndelete_workers: 4\n")
+
+		cfg, err := Load(nil, envOf(map[string]string{"CONFIG": path}))
+		require.NoError(t, err)
+		assert.Equal(t, ":9090", cfg.RunPort)
+		assert.Equal(t, 4, cfg.DeleteWorkers)
+	})
+
+	t.Run("precedence", func(t *testing.T) {
+		path := writeFile(t, "config.yaml", "server_address: \":9090\"\nbase_url: http://file\ndelete_workers: 4\n")
+
+		cfg, err := Load(
+			[]string{"-c", path, "-b", "http://flag", "-delete-workers", "8"},
+			envOf(map[string]string{"DELETE_WORKERS": "16"}),
+		)
+		require.NoError(t, err)
+		assert.Equal(t, ":9090", cfg.RunPort)
+		assert.Equal(t, "http://flag", cfg.BaseURL)
+		assert.Equal(t, 16, cfg.DeleteWorkers)
+	})
+
+	t.Run("unknown_field", func(t *testing.T) {
+		path := writeFile(t, "config.yaml", "server_adress: \":9090\"\n")
+
+		_, err := Load([]string{"-c", path}, envOf(nil))
+		assert.Error(t, err)
+	})
+
+	t.Run("missing_file", func(t *testing.T) {
+		_, err := Load([]string{"-c", filepath.Join(t.TempDir(), "none.yaml")}, envOf(nil))
+		assert.Error(t, err)
+	})
+
+	t.Run("auth_keys", func(t *testing.T) {
+		path := writeFile(t, "config.yaml", `auth_keys:
+  - id: "2021-01"
+    secret: ololo-trololo-shimba-boomba-look
+    retired: true
+  - id: "2021-06"
+    secret: 0123456789abcdef
+`)
+
+		cfg, err := Load([]string{"-c", path}, envOf(nil))
+		require.NoError(t, err)
+		assert.Len(t, cfg.AuthKeys, 2)
+		assert.Equal(t, "2021-06", cfg.ActiveAuthKey())
+
+		cfg, err = Load([]string{"-c", path}, envOf(map[string]string{
+			"AUTH_KEYS":       "a:0123456789abcdef,b:ololo-trololo-shimba-boomba-look",
+			"AUTH_ACTIVE_KEY": "b",
+		}))
+		require.NoError(t, err)
+		assert.Equal(t, AuthKeys{
+			{ID: "a", Secret: "0123456789abcdef"},
+			{ID: "b", Secret: "ololo-trololo-shimba-boomba-look"},
+		}, cfg.AuthKeys)
+		assert.Equal(t, "b", cfg.ActiveAuthKey())
+	})
+
+	t.Run("auth_keys_file", func(t *testing.T) {
+		path := writeFile(t, "keys", "# rotated monthly\n2021-06:0123456789abcdef\n\n2021-01:ololo-trololo-shimba-boomba-look\n")
+
+		cfg, err := Load([]string{"-auth-keys", "x:0123456789abcdef"}, envOf(map[string]string{"AUTH_KEYS_FILE": path}))
+		require.NoError(t, err)
+		require.Len(t, cfg.AuthKeys, 2)
+		assert.Equal(t, "2021-06", cfg.ActiveAuthKey())
+	})
+
+	t.Run("rate_limits", func(t *testing.T) {
+		path := writeFile(t, "config.yaml", "rate_limit_batch: 10/m\nrate_limit_redirect: 100/s:500\n")
+
+		cfg, err := Load([]string{"-c", path, "-rate-limit-user", "5/30s"}, envOf(map[string]string{"RATE_LIMIT_SHORTEN": "off"}))
+		require.NoError(t, err)
+		assert.False(t, cfg.RateLimitShorten.Enabled())
+		assert.Equal(t, RateLimit{Count: 10, Period: time.Minute, Burst: 10}, cfg.RateLimitBatch)
+		assert.Equal(t, RateLimit{Count: 100, Period: time.Second, Burst: 500}, cfg.RateLimitRedirect)
+		assert.Equal(t, RateLimit{Count: 5, Period: 30 * time.Second, Burst: 5}, cfg.RateLimitUser)
+		assert.Equal(t, "100/1s:500", cfg.RateLimitRedirect.String())
+
+		for _, bad := range []string{"10", "0/s", "10/d", "10/s:0", "x/s"} {
+			_, err := Load([]string{"-rate-limit-batch", bad}, envOf(nil))
+			assert.Error(t, err, bad)
+		}
+	})
+
+	t.Run("bad_env", func(t *testing.T) {
+		_, err := Load(nil, envOf(map[string]string{"SHUTDOWN_TIMEOUT": "soon"}))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "SHUTDOWN_TIMEOUT")
+	})
+}
+
+func TestConfig_Validate(t *testing.T) {
+	testCases := []struct {
+		name   string
+		modify func(c *Config)
+	}{
+		{
+			name:   "relative_base_url",
+			modify: func(c *Config) { c.BaseURL = "/short" },
+		},
+		{
+			name:   "bad_base_url_scheme",
+			modify: func(c *Config) { c.BaseURL = "ftp://localhost" },
+		},
+		{
+			name:   "short_secret",
+			modify: func(c *Config) { c.AuthKeys = AuthKeys{{ID: "1", Secret: "secret"}} },
+		},
+		{
+			name: "duplicate_key",
+			modify: func(c *Config) {
+				c.AuthKeys = AuthKeys{{ID: "1", Secret: "0123456789abcdef"}, {ID: "1", Secret: "fedcba9876543210"}}
+			},
+		},
+		{
+			name:   "short_legacy_secret",
+			modify: func(c *Config) { c.AuthLegacySecret = "secret" },
+		},
+		{
+			name:   "unknown_active_key",
+			modify: func(c *Config) { c.AuthActiveKey = "1" },
+		},
+		{
+			name: "retired_active_key",
+			modify: func(c *Config) {
+				c.AuthKeys = AuthKeys{{ID: "1", Secret: "0123456789abcdef", Retired: true}}
+				c.AuthActiveKey = "1"
+			},
+		},
+		{
+			name:   "unusable_file",
+			modify: func(c *Config) { c.PersistFile = filepath.Join(t.TempDir(), "missing", "urls.log") },
+		},
+		{
+			name:   "unknown_same_site",
+			modify: func(c *Config) { c.CookieSameSite = "loose" },
+		},
+		{
+			name:   "insecure_same_site_none",
+			modify: func(c *Config) { c.CookieSameSite = "none" },
+		},
+		{
+			name:   "grpc_on_http_address",
+			modify: func(c *Config) { c.GRPCAddress = c.RunPort },
+		},
+		{
+			name:   "debug_prefix_trailing_slash",
+			modify: func(c *Config) { c.DebugPrefix = "/debug/" },
+		},
+		{
+			name:   "bad_trusted_subnet",
+			modify: func(c *Config) { c.DebugTrustedSubnet = "10.0.0.1" },
+		},
+		{
+			name:   "long_cpu_profile",
+			modify: func(c *Config) { c.ProfileDir, c.ProfileCPUDuration = t.TempDir(), 2*time.Minute },
+		},
+		{
+			name:   "unknown_log_level",
+			modify: func(c *Config) { c.LogLevel = "verbose" },
+		},
+		{
+			name:   "sample_rate_above_one",
+			modify: func(c *Config) { c.LogSampleRate = 1.5 },
+		},
+		{
+			name:   "shared_rate_limits_without_database",
+			modify: func(c *Config) { c.RateLimitShared = true },
+		},
+		{
+			name:   "bad_trusted_proxy",
+			modify: func(c *Config) { c.TrustedProxies = "10.0.0.0/8,proxy" },
+		},
+		{
+			name:   "unknown_generator",
+			modify: func(c *Config) { c.IDGenerator = "uuid" },
+		},
+		{
+			name:   "short_random_id",
+			modify: func(c *Config) { c.IDGenerator, c.IDLength = "random", 2 },
+		},
+		{
+			name:   "no_workers",
+			modify: func(c *Config) { c.DeleteWorkers = 0 },
+		},
+		{
+			name:   "short_ip_hash_key",
+			modify: func(c *Config) { c.IPHashKey = "short" },
+		},
+		{
+			name:   "negative_max_batch",
+			modify: func(c *Config) { c.MaxBatchSize = -1 },
+		},
+		{
+			name:   "no_insert_chunk",
+			modify: func(c *Config) { c.InsertChunkSize = 0 },
+		},
+	}
+
+	require.NoError(t, Default().Validate())
+	unlimited := Default()
+	unlimited.MaxBatchSize = 0
+	require.NoError(t, unlimited.Validate())
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := Default()
+			tc.modify(cfg)
+			assert.Error(t, cfg.Validate())
+		})
+	}
+
+	t.Run("missing_file_not_created", func(t *testing.T) {
+		dir := t.TempDir()
+		cfg := Default()
+		cfg.PersistFile = filepath.Join(dir, "urls.log")
+		require.NoError(t, cfg.Validate())
+		assert.NoFileExists(t, cfg.PersistFile)
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+	})
+	t.Run("directory_as_file", func(t *testing.T) {
+		cfg := Default()
+		cfg.PersistFile = t.TempDir()
+		assert.Error(t, cfg.Validate())
+	})
+}
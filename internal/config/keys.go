@@ -0,0 +1,98 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// AuthKey is a secret of auth cookie encryption
+type AuthKey struct {
+	ID      string `yaml:"id"`
+	Secret  string `yaml:"secret"`
+	Retired bool   `yaml:"retired"`
+}
+
+// AuthKeys is a flag.Value of comma or newline separated "id:secret" pairs
+type AuthKeys []AuthKey
+
+func (keys *AuthKeys) String() string {
+	if keys == nil {
+		return ""
+	}
+	// secrets are never printed
+	ids := make([]string, 0, len(*keys))
+	for _, key := range *keys {
+		ids = append(ids, key.ID)
+	}
+	return strings.Join(ids, ",")
+}
+
+// Set replaces keys with parsed ones, blank lines and lines starting with '#' are skipped
+func (keys *AuthKeys) Set(s string) error {
+	var parsed AuthKeys
+	for _, line := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' }) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			return errors.New("auth key must be id:secret pair")
+		}
+		parsed = append(parsed, AuthKey{ID: line[:i], Secret: line[i+1:]})
+	}
+	*keys = parsed
+	return nil
+}
+
+// ActiveAuthKey returns ID of key sealing new cookies, empty if there are no usable keys
+func (c *Config) ActiveAuthKey() string {
+	if c.AuthActiveKey != "" {
+		return c.AuthActiveKey
+	}
+	for _, key := range c.AuthKeys {
+		if !key.Retired {
+			return key.ID
+		}
+	}
+	return ""
+}
+
+func (c *Config) validateAuthKeys() error {
+	seen := make(map[string]bool, len(c.AuthKeys))
+	for _, key := range c.AuthKeys {
+		if key.ID == "" || len(key.ID) > 255 || strings.ContainsAny(key.ID, ":,\n") {
+			return fmt.Errorf("auth key ID %q must be from 1 to 255 bytes long without ':' and ','", key.ID)
+		}
+		if seen[key.ID] {
+			return fmt.Errorf("duplicate auth key ID %q", key.ID)
+		}
+		seen[key.ID] = true
+
+		switch len(key.Secret) {
+		case 16, 24, 32:
+		default:
+			return fmt.Errorf("auth key %q secret must be 16, 24 or 32 bytes long, got %d", key.ID, len(key.Secret))
+		}
+	}
+
+	switch len(c.AuthLegacySecret) {
+	case 0, 16, 24, 32:
+	default:
+		return fmt.Errorf("auth legacy secret must be 16, 24 or 32 bytes long, got %d", len(c.AuthLegacySecret))
+	}
+
+	if c.AuthActiveKey == "" {
+		return nil
+	}
+	for _, key := range c.AuthKeys {
+		if key.ID == c.AuthActiveKey {
+			if key.Retired {
+				return fmt.Errorf("active auth key %q is retired", key.ID)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("active auth key %q is not configured", c.AuthActiveKey)
+}
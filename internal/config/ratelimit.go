@@ -0,0 +1,107 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RateLimit is a flag.Value of "count/period[:burst]", e.g. "100/m" or "10/s:50",
+// period is s, m, h or a duration; burst defaults to count. Empty one means no limit.
+type RateLimit struct {
+	Count  int
+	Period time.Duration
+	Burst  int
+}
+
+// Enabled reports whether requests are limited
+func (rl RateLimit) Enabled() bool {
+	return rl.Count > 0
+}
+
+func (rl *RateLimit) String() string {
+	if rl == nil || !rl.Enabled() {
+		return ""
+	}
+	s := fmt.Sprintf("%d/%s", rl.Count, rl.Period)
+	if rl.Burst != rl.Count {
+		s += ":" + strconv.Itoa(rl.Burst)
+	}
+	return s
+}
+
+func (rl *RateLimit) Set(s string) error {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "off" {
+		*rl = RateLimit{}
+		return nil
+	}
+
+	spec, burstStr := s, ""
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		spec, burstStr = s[:i], s[i+1:]
+	}
+	i := strings.IndexByte(spec, '/')
+	if i < 0 {
+		return fmt.Errorf("rate limit %q must be count/period", s)
+	}
+
+	count, err := strconv.Atoi(spec[:i])
+	if err != nil || count <= 0 {
+		return fmt.Errorf("rate limit %q must have positive count", s)
+	}
+	var period time.Duration
+	switch unit := spec[i+1:]; unit {
+	case "s":
+		period = time.Second
+	case "m":
+		period = time.Minute
+	case "h":
+		period = time.Hour
+	default:
+		period, err = time.ParseDuration(unit)
+		if err != nil || period <= 0 {
+			return fmt.Errorf("rate limit %q must have positive period", s)
+		}
+	}
+	burst := count
+	if burstStr != "" {
+		burst, err = strconv.Atoi(burstStr)
+		if err != nil || burst <= 0 {
+			return fmt.Errorf("rate limit %q must have positive burst", s)
+		}
+	}
+
+	*rl = RateLimit{Count: count, Period: period, Burst: burst}
+	return nil
+}
+
+// UnmarshalYAML reads rate limit in the same form as flag
+func (rl *RateLimit) UnmarshalYAML(value *yaml.Node) error {
+	var s string
+	if err := value.Decode(&s); err != nil {
+		return err
+	}
+	return rl.Set(s)
+}
+
+// TrustedProxyNets returns parsed TrustedProxies
+func (c *Config) TrustedProxyNets() ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, cidr := range strings.Split(c.TrustedProxies, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("bad trusted proxy: %w", err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
@@ -0,0 +1,172 @@
+// Package deletion deletes user URLs asynchronously in coalesced batches
+package deletion
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"go.uber.org/zap"
+
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/store"
+)
+
+// deleteTimeout limits a single bulk deletion
+const deleteTimeout = 30 * time.Second
+
+var (
+	ErrQueueFull = errors.New("deletion queue is full")
+	ErrClosed    = errors.New("deletion queue is closed")
+)
+
+// Deleter applies deletion requests of many users at once
+type Deleter interface {
+	DeleteBatch(ctx context.Context, reqs []store.DeleteRequest) error
+}
+
+// Queue is a bounded queue of deletion requests served by a pool of workers.
+// Every worker coalesces requests until batchSize IDs are collected
+// or flushInterval passes, then deletes them with a single store call.
+type Queue struct {
+	deleter       Deleter
+	reqs          chan store.DeleteRequest
+	batchSize     int
+	flushInterval time.Duration
+	logger        *zap.Logger
+
+	// depth is a number of IDs enqueued but not deleted yet
+	depth int64
+
+	// mu guards closed, so no request is enqueued after workers are drained
+	mu     sync.RWMutex
+	closed bool
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewQueue starts workers of queue holding up to capacity requests, failed deletions are reported to logger
+func NewQueue(deleter Deleter, capacity, workers, batchSize int, flushInterval time.Duration, logger *zap.Logger) *Queue {
+	q := &Queue{
+		deleter:       deleter,
+		reqs:          make(chan store.DeleteRequest, capacity),
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		logger:        logger,
+		done:          make(chan struct{}),
+	}
+
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+
+	return q
+}
+
+// Enqueue schedules deletion of user IDs without blocking
+func (q *Queue) Enqueue(uid uuid.UUID, ids []string) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		return ErrClosed
+	}
+
+	select {
+	case q.reqs <- store.DeleteRequest{UID: uid, IDs: ids}:
+		atomic.AddInt64(&q.depth, int64(len(ids)))
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Depth returns number of IDs waiting for deletion
+func (q *Queue) Depth() int64 {
+	return atomic.LoadInt64(&q.depth)
+}
+
+// Close stops accepting requests and waits until queued ones are deleted
+func (q *Queue) Close() error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return nil
+	}
+	q.closed = true
+	q.mu.Unlock()
+
+	close(q.done)
+	q.wg.Wait()
+	return nil
+}
+
+func (q *Queue) work() {
+	defer q.wg.Done()
+
+	ticker := time.NewTicker(q.flushInterval)
+	defer ticker.Stop()
+
+	var batch []store.DeleteRequest
+	var size int
+	for {
+		select {
+		case req := <-q.reqs:
+			batch = append(batch, req)
+			size += len(req.IDs)
+			if size < q.batchSize {
+				continue
+			}
+		case <-ticker.C:
+		case <-q.done:
+			// workers share the channel, so each drains until it is empty
+		drain:
+			for {
+				select {
+				case req := <-q.reqs:
+					batch = append(batch, req)
+					size += len(req.IDs)
+				default:
+					break drain
+				}
+			}
+			q.flush(batch, size)
+			return
+		}
+		q.flush(batch, size)
+		batch, size = nil, 0
+	}
+}
+
+func (q *Queue) flush(batch []store.DeleteRequest, size int) {
+	if len(batch) == 0 {
+		return
+	}
+	defer atomic.AddInt64(&q.depth, -int64(size))
+
+	ctx, cancel := context.WithTimeout(context.Background(), deleteTimeout)
+	defer cancel()
+
+	if err := q.deleter.DeleteBatch(ctx, coalesce(batch)); err != nil {
+		q.logger.Error("cannot delete ids", zap.Int("count", size), zap.Error(err))
+	}
+}
+
+// coalesce merges requests of the same user
+func coalesce(batch []store.DeleteRequest) []store.DeleteRequest {
+	index := make(map[uuid.UUID]int, len(batch))
+	merged := make([]store.DeleteRequest, 0, len(batch))
+	for _, req := range batch {
+		i, ok := index[req.UID]
+		if !ok {
+			index[req.UID] = len(merged)
+			merged = append(merged, store.DeleteRequest{UID: req.UID, IDs: append([]string(nil), req.IDs...)})
+			continue
+		}
+		merged[i].IDs = append(merged[i].IDs, req.IDs...)
+	}
+	return merged
+}
@@ -0,0 +1,98 @@
+package deletion
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/store"
+)
+
+type deleterMock struct {
+	mu      sync.Mutex
+	batches [][]store.DeleteRequest
+}
+
+func (m *deleterMock) DeleteBatch(_ context.Context, reqs []store.DeleteRequest) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.batches = append(m.batches, reqs)
+	return nil
+}
+
+func (m *deleterMock) deleted() map[uuid.UUID][]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	res := make(map[uuid.UUID][]string)
+	for _, batch := range m.batches {
+		for _, req := range batch {
+			res[req.UID] = append(res[req.UID], req.IDs...)
+		}
+	}
+	return res
+}
+
+func TestQueue(t *testing.T) {
+	alice := uuid.Must(uuid.NewV4())
+	bob := uuid.Must(uuid.NewV4())
+
+	t.Run("coalesce", func(t *testing.T) {
+		deleter := new(deleterMock)
+		q := NewQueue(deleter, 10, 1, 100, time.Hour, zap.NewNop())
+
+		require.NoError(t, q.Enqueue(alice, []string{"a"}))
+		require.NoError(t, q.Enqueue(bob, []string{"b"}))
+		require.NoError(t, q.Enqueue(alice, []string{"c", "d"}))
+		assert.Equal(t, int64(4), q.Depth())
+
+		// nothing is deleted until interval passes, so Close drains everything
+		require.NoError(t, q.Close())
+		assert.Zero(t, q.Depth())
+		require.Len(t, deleter.batches, 1)
+		assert.Equal(t, []store.DeleteRequest{
+			{UID: alice, IDs: []string{"a", "c", "d"}},
+			{UID: bob, IDs: []string{"b"}},
+		}, deleter.batches[0])
+
+		assert.ErrorIs(t, q.Enqueue(alice, []string{"e"}), ErrClosed)
+	})
+
+	t.Run("batch_size", func(t *testing.T) {
+		deleter := new(deleterMock)
+		q := NewQueue(deleter, 10, 2, 2, time.Hour, zap.NewNop())
+		defer q.Close()
+
+		require.NoError(t, q.Enqueue(alice, []string{"a", "b"}))
+		assert.Eventually(t, func() bool {
+			return len(deleter.deleted()[alice]) == 2
+		}, time.Second, time.Millisecond)
+		assert.Eventually(t, func() bool { return q.Depth() == 0 }, time.Second, time.Millisecond)
+	})
+
+	t.Run("interval", func(t *testing.T) {
+		deleter := new(deleterMock)
+		q := NewQueue(deleter, 10, 1, 100, 10*time.Millisecond, zap.NewNop())
+		defer q.Close()
+
+		require.NoError(t, q.Enqueue(bob, []string{"a"}))
+		assert.Eventually(t, func() bool {
+			return len(deleter.deleted()[bob]) == 1
+		}, time.Second, time.Millisecond)
+	})
+
+	t.Run("full", func(t *testing.T) {
+		// no workers are started, so queue is never drained
+		q := &Queue{reqs: make(chan store.DeleteRequest, 1), done: make(chan struct{})}
+
+		require.NoError(t, q.Enqueue(alice, []string{"a"}))
+		assert.ErrorIs(t, q.Enqueue(alice, []string{"b"}), ErrQueueFull)
+		assert.Equal(t, int64(1), q.Depth())
+	})
+}
@@ -0,0 +1,91 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gofrs/uuid"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/Yandex-Praktikum/go-profilable-shortener/api/shortenerpb"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/auth"
+)
+
+// AuthMetadata is a metadata key of caller identity, its value is the one of auth cookie
+const AuthMetadata = "auth"
+
+var errNoAuth = errors.New("no auth metadata")
+
+// userScoped are methods answering Unauthenticated in strict mode to callers without identity
+var userScoped = map[string]bool{
+	pb.Shortener_ListUserURLs_FullMethodName:   true,
+	pb.Shortener_DeleteUserURLs_FullMethodName: true,
+}
+
+// APIKeyResolver maps bearer token to its owner
+type APIKeyResolver interface {
+	APIKeyUID(ctx context.Context, token string) (uuid.UUID, error)
+}
+
+// Auth identifies callers the same way HTTP auth middleware does
+type Auth struct {
+	Codec *auth.Codec
+	Keys  APIKeyResolver
+	// Strict makes user scoped methods fail instead of issuing new identity
+	Strict bool
+	Logger *zap.Logger
+}
+
+// UnaryInterceptor puts caller UID to context, identity is taken from API key
+// in authorization metadata or from auth metadata, new one is issued otherwise
+func (a Auth) UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	if values := md.Get("authorization"); len(values) > 0 {
+		token, ok := auth.BearerToken(values[0])
+		if !ok {
+			return nil, status.Error(codes.Unauthenticated, "bearer API key expected")
+		}
+		uid, err := a.Keys.APIKeyUID(ctx, token)
+		if errors.Is(err, auth.ErrInvalidAPIKey) {
+			return nil, status.Error(codes.Unauthenticated, "invalid API key")
+		}
+		if err != nil {
+			a.Logger.Error("cannot resolve API key", zap.String("method", info.FullMethod), zap.Error(err))
+			return nil, status.Error(codes.Internal, "internal error")
+		}
+		return handler(auth.Context(ctx, uid), req)
+	}
+
+	var uid *uuid.UUID
+	var stale bool
+	err := errNoAuth
+	if values := md.Get(AuthMetadata); len(values) > 0 {
+		uid, stale, err = a.Codec.DecodeUIDFromHex(values[0])
+	}
+	if uid == nil && userScoped[info.FullMethod] && a.Strict {
+		return nil, status.Error(codes.Unauthenticated, "auth metadata is missing or invalid")
+	}
+	if uid == nil {
+		userID := uuid.Must(uuid.NewV4())
+		uid = &userID
+	}
+
+	// send new identity in case of absence, decode error or rotated key
+	if err != nil || stale {
+		value, err := a.Codec.EncodeUIDToHex(*uid)
+		if err != nil {
+			a.Logger.Error("cannot encode auth metadata", zap.Error(err))
+			return nil, status.Error(codes.Internal, "internal error")
+		}
+		if err := grpc.SetHeader(ctx, metadata.Pairs(AuthMetadata, value)); err != nil {
+			return nil, status.Errorf(codes.Internal, "cannot send auth metadata: %s", err)
+		}
+	}
+
+	return handler(auth.Context(ctx, *uid), req)
+}
@@ -0,0 +1,172 @@
+// Package grpcapi serves gRPC API of shortener on top of service.Service
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/Yandex-Praktikum/go-profilable-shortener/api/shortenerpb"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/auth"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/service"
+)
+
+// Server implements pb.ShortenerServer, callers are expected to be identified by Auth
+type Server struct {
+	pb.UnimplementedShortenerServer
+
+	svc    *service.Service
+	logger *zap.Logger
+}
+
+func NewServer(svc *service.Service, logger *zap.Logger) *Server {
+	return &Server{svc: svc, logger: logger}
+}
+
+func (s *Server) Shorten(ctx context.Context, req *pb.ShortenRequest) (*pb.ShortenResponse, error) {
+	shortURL, err := s.svc.Shorten(ctx, service.LinkRequest{
+		URL:       req.Url,
+		Alias:     req.Alias,
+		TTL:       req.TtlSeconds,
+		ExpiresAt: expiresAt(req.ExpiresAt),
+	})
+	// conflict without short URL is a taken alias
+	if err != nil && (service.KindOf(err) != service.Conflict || shortURL == "") {
+		return nil, s.status(ctx, err)
+	}
+	return &pb.ShortenResponse{ShortUrl: shortURL, AlreadyExists: err != nil}, nil
+}
+
+func (s *Server) BatchShorten(ctx context.Context, req *pb.BatchShortenRequest) (*pb.BatchShortenResponse, error) {
+	links := make([]service.LinkRequest, 0, len(req.Items))
+	for _, item := range req.Items {
+		links = append(links, service.LinkRequest{
+			URL:       item.OriginalUrl,
+			Alias:     item.Alias,
+			TTL:       item.TtlSeconds,
+			ExpiresAt: expiresAt(item.ExpiresAt),
+		})
+	}
+
+	shortURLs, err := s.svc.ShortenBatch(ctx, links)
+	if err != nil {
+		return nil, s.status(ctx, err)
+	}
+
+	resp := &pb.BatchShortenResponse{Items: make([]*pb.BatchShortenResponse_Item, 0, len(shortURLs))}
+	for n, shortURL := range shortURLs {
+		resp.Items = append(resp.Items, &pb.BatchShortenResponse_Item{
+			CorrelationId: req.Items[n].CorrelationId,
+			ShortUrl:      shortURL,
+		})
+	}
+	return resp, nil
+}
+
+func (s *Server) Expand(ctx context.Context, req *pb.ExpandRequest) (*pb.ExpandResponse, error) {
+	target, err := s.svc.Expand(ctx, req.Id)
+	if err != nil {
+		return nil, s.status(ctx, err)
+	}
+
+	var userAgent, ip string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("user-agent"); len(values) > 0 {
+			userAgent = values[0]
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		ip = p.Addr.String()
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+	}
+	s.svc.RecordVisit(req.Id, "", userAgent, ip)
+
+	return &pb.ExpandResponse{OriginalUrl: target.String()}, nil
+}
+
+func (s *Server) ListUserURLs(ctx context.Context, _ *pb.ListUserURLsRequest) (*pb.ListUserURLsResponse, error) {
+	urls, err := s.svc.UserURLs(ctx)
+	if err != nil {
+		return nil, s.status(ctx, err)
+	}
+
+	resp := &pb.ListUserURLsResponse{Items: make([]*pb.ListUserURLsResponse_Item, 0, len(urls))}
+	for _, u := range urls {
+		resp.Items = append(resp.Items, &pb.ListUserURLsResponse_Item{
+			ShortUrl:    u.ShortURL,
+			OriginalUrl: u.OriginalURL,
+		})
+	}
+	return resp, nil
+}
+
+func (s *Server) DeleteUserURLs(ctx context.Context, req *pb.DeleteUserURLsRequest) (*pb.DeleteUserURLsResponse, error) {
+	if err := s.svc.DeleteUserURLs(ctx, req.Ids); err != nil {
+		return nil, s.status(ctx, err)
+	}
+	return &pb.DeleteUserURLsResponse{}, nil
+}
+
+func (s *Server) Ping(ctx context.Context, _ *pb.PingRequest) (*pb.PingResponse, error) {
+	if err := s.svc.Ping(ctx); err != nil {
+		s.logger.Warn("ping failed", zap.Error(err))
+		return nil, status.Error(codes.Unavailable, "storage is unavailable")
+	}
+	return &pb.PingResponse{}, nil
+}
+
+// errorCodes maps kinds of service errors to gRPC codes
+var errorCodes = map[service.Kind]codes.Code{
+	service.Internal:    codes.Internal,
+	service.Validation:  codes.InvalidArgument,
+	service.NotFound:    codes.NotFound,
+	service.Gone:        codes.NotFound,
+	service.Conflict:    codes.AlreadyExists,
+	service.Forbidden:   codes.PermissionDenied,
+	service.Unavailable: codes.Unavailable,
+	service.TooLarge:    codes.InvalidArgument,
+}
+
+// status maps service error to gRPC status, causes of internal errors
+// are logged and not exposed to client
+func (s *Server) status(ctx context.Context, err error) error {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+
+	kind, msg, _ := service.Describe(err)
+	if kind == service.Internal {
+		fields := []zap.Field{zap.Error(err)}
+		if uid := auth.UIDFromContext(ctx); uid != nil {
+			fields = append(fields, zap.String("uid", uid.String()))
+		}
+		s.logger.Error("request failed", fields...)
+	}
+	code, ok := errorCodes[kind]
+	if !ok {
+		code = codes.Internal
+	}
+	return status.Error(code, msg)
+}
+
+// expiresAt converts optional timestamp, nil means link never expires
+func expiresAt(ts *timestamppb.Timestamp) *time.Time {
+	if ts == nil {
+		return nil
+	}
+	at := ts.AsTime()
+	return &at
+}
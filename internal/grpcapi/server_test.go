@@ -0,0 +1,190 @@
+package grpcapi
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/Yandex-Praktikum/go-profilable-shortener/api/shortenerpb"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/auth"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/service"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/store"
+)
+
+type keysMock map[string]uuid.UUID
+
+func (m keysMock) APIKeyUID(_ context.Context, token string) (uuid.UUID, error) {
+	uid, ok := m[token]
+	if !ok {
+		return uuid.Nil, auth.ErrInvalidAPIKey
+	}
+	return uid, nil
+}
+
+// newClient serves svc over in-process listener
+func newClient(t *testing.T, svc *service.Service, a Auth) pb.ShortenerClient {
+	t.Helper()
+
+	ln := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(grpc.UnaryInterceptor(a.UnaryInterceptor))
+	pb.RegisterShortenerServer(srv, NewServer(svc, zap.NewNop()))
+	go func() { _ = srv.Serve(ln) }()
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return ln.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return pb.NewShortenerClient(conn)
+}
+
+func newAuth(t *testing.T) Auth {
+	t.Helper()
+	codec, err := auth.NewCodec("k", auth.Key{ID: "k", Secret: []byte("0123456789abcdef")})
+	require.NoError(t, err)
+	return Auth{Codec: codec, Keys: keysMock{}, Logger: zap.NewNop()}
+}
+
+func TestServer(t *testing.T) {
+	ctx := context.Background()
+	a := newAuth(t)
+	client := newClient(t, service.New("http://localhost:8080", store.NewInMemory()), a)
+
+	// the first call issues identity which is passed along afterwards
+	var header metadata.MD
+	resp, err := client.Shorten(ctx, &pb.ShortenRequest{Url: "https://praktikum.yandex.ru/"}, grpc.Header(&header))
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost:8080/0", resp.ShortUrl)
+	assert.False(t, resp.AlreadyExists)
+	require.Len(t, header.Get(AuthMetadata), 1)
+	userCtx := metadata.AppendToOutgoingContext(ctx, AuthMetadata, header.Get(AuthMetadata)[0])
+
+	resp, err = client.Shorten(userCtx, &pb.ShortenRequest{Url: "https://praktikum.yandex.ru/"})
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost:8080/0", resp.ShortUrl)
+	assert.True(t, resp.AlreadyExists)
+
+	batch, err := client.BatchShorten(userCtx, &pb.BatchShortenRequest{Items: []*pb.BatchShortenRequest_Item{
+		{CorrelationId: "a", OriginalUrl: "https://ya.ru/"},
+		{CorrelationId: "b", OriginalUrl: "https://go.dev/", Alias: "golang", ExpiresAt: timestamppb.New(time.Now().Add(time.Hour))},
+	}})
+	require.NoError(t, err)
+	require.Len(t, batch.Items, 2)
+	assert.Equal(t, "a", batch.Items[0].CorrelationId)
+	assert.Equal(t, "b", batch.Items[1].CorrelationId)
+	assert.Equal(t, "http://localhost:8080/golang", batch.Items[1].ShortUrl)
+
+	expanded, err := client.Expand(ctx, &pb.ExpandRequest{Id: "golang"})
+	require.NoError(t, err)
+	assert.Equal(t, "https://go.dev/", expanded.OriginalUrl)
+
+	list, err := client.ListUserURLs(userCtx, &pb.ListUserURLsRequest{})
+	require.NoError(t, err)
+	assert.Len(t, list.Items, 3)
+
+	// other users do not see the links
+	list, err = client.ListUserURLs(ctx, &pb.ListUserURLsRequest{})
+	require.NoError(t, err)
+	assert.Empty(t, list.Items)
+
+	_, err = client.DeleteUserURLs(userCtx, &pb.DeleteUserURLsRequest{Ids: []string{"golang"}})
+	require.NoError(t, err)
+	_, err = client.Expand(ctx, &pb.ExpandRequest{Id: "golang"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Equal(t, "short URL is deleted", status.Convert(err).Message())
+
+	_, err = client.Ping(ctx, &pb.PingRequest{})
+	assert.NoError(t, err)
+}
+
+func TestServer_errors(t *testing.T) {
+	ctx := context.Background()
+	client := newClient(t, service.New("http://localhost:8080", store.NewInMemory()), newAuth(t))
+
+	_, err := client.Shorten(ctx, &pb.ShortenRequest{Url: "https://ya.ru/", Alias: "api"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Equal(t, `bad alias: "api" is reserved`, status.Convert(err).Message())
+
+	_, err = client.Shorten(ctx, &pb.ShortenRequest{Url: "https://ya.ru/", TtlSeconds: -1})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.BatchShorten(ctx, &pb.BatchShortenRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.Shorten(ctx, &pb.ShortenRequest{Url: "https://ya.ru/", Alias: "taken"})
+	require.NoError(t, err)
+	_, err = client.Shorten(ctx, &pb.ShortenRequest{Url: "https://go.dev/", Alias: "taken"})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	assert.Equal(t, `alias "taken" is already taken`, status.Convert(err).Message())
+	_, err = client.BatchShorten(ctx, &pb.BatchShortenRequest{Items: []*pb.BatchShortenRequest_Item{
+		{CorrelationId: "a", OriginalUrl: "https://go.dev/", Alias: "taken"},
+	}})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	_, err = client.Expand(ctx, &pb.ExpandRequest{Id: "missing"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = client.DeleteUserURLs(ctx, &pb.DeleteUserURLsRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestAuth_UnaryInterceptor(t *testing.T) {
+	ctx := context.Background()
+	a := newAuth(t)
+	owner := uuid.Must(uuid.NewV4())
+	a.Keys = keysMock{"secret": owner}
+	a.Strict = true
+	svc := service.New("http://localhost:8080", store.NewInMemory())
+	client := newClient(t, svc, a)
+
+	t.Run("api_key", func(t *testing.T) {
+		keyCtx := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer secret")
+		var header metadata.MD
+		_, err := client.Shorten(keyCtx, &pb.ShortenRequest{Url: "https://ya.ru/"}, grpc.Header(&header))
+		require.NoError(t, err)
+		assert.Empty(t, header.Get(AuthMetadata))
+
+		urls, err := svc.UserURLs(auth.Context(ctx, owner))
+		require.NoError(t, err)
+		assert.Len(t, urls, 1)
+	})
+
+	t.Run("invalid_api_key", func(t *testing.T) {
+		keyCtx := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer forged")
+		_, err := client.Shorten(keyCtx, &pb.ShortenRequest{Url: "https://ya.ru/"})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("strict_user_scoped", func(t *testing.T) {
+		forgedCtx := metadata.AppendToOutgoingContext(ctx, AuthMetadata, "ololo")
+		_, err := client.ListUserURLs(forgedCtx, &pb.ListUserURLsRequest{})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+		// identity is still issued on other methods
+		var header metadata.MD
+		_, err = client.Ping(forgedCtx, &pb.PingRequest{}, grpc.Header(&header))
+		require.NoError(t, err)
+		require.Len(t, header.Get(AuthMetadata), 1)
+
+		uid, _, err := a.Codec.DecodeUIDFromHex(header.Get(AuthMetadata)[0])
+		require.NoError(t, err)
+		assert.NotNil(t, uid)
+	})
+}
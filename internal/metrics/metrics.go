@@ -0,0 +1,150 @@
+// Package metrics exposes service telemetry in Prometheus format
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "shortener"
+
+// unmatchedRoute labels requests which hit no route, so random paths do not blow up label cardinality
+const unmatchedRoute = "unmatched"
+
+// Metrics holds all collectors of the service in its own registry
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+
+	storeDuration *prometheus.HistogramVec
+	storeErrors   *prometheus.CounterVec
+
+	compressionRatio prometheus.Histogram
+	redirects        *prometheus.CounterVec
+
+	rateLimited *prometheus.CounterVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "Number of HTTP requests by route pattern, method and status code.",
+		}, []string{"route", "method", "code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of HTTP requests by route pattern, method and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method", "code"}),
+		storeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "store_operation_duration_seconds",
+			Help:      "Latency of storage operations by backend and method.",
+			Buckets:   []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1, 5},
+		}, []string{"backend", "method"}),
+		storeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "store_operation_errors_total",
+			Help:      "Number of failed storage operations by backend and method, expected outcomes like conflicts are not counted.",
+		}, []string{"backend", "method"}),
+		compressionRatio: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_gzip_compression_ratio",
+			Help:      "Ratio of compressed to raw size of gzipped responses.",
+			Buckets:   prometheus.LinearBuckets(0.1, 0.1, 10),
+		}),
+		redirects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "redirects_total",
+			Help:      "Number of short link expansions by result: redirected, not_found or gone.",
+		}, []string{"result"}),
+		rateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rate_limited_requests_total",
+			Help:      "Number of requests rejected by rate limiter of route group.",
+		}, []string{"group"}),
+	}
+
+	m.registry.MustRegister(
+		m.requests,
+		m.requestDuration,
+		m.storeDuration,
+		m.storeErrors,
+		m.compressionRatio,
+		m.redirects,
+		m.rateLimited,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Handler serves metrics in Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	// responses are compressed by router middleware if client asks for it
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{DisableCompression: true})
+}
+
+// Middleware counts requests by chi route pattern, so it must be used by chi router
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		route := unmatchedRoute
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		labels := prometheus.Labels{"route": route, "method": r.Method, "code": strconv.Itoa(status)}
+
+		m.requests.With(labels).Inc()
+		m.requestDuration.With(labels).Observe(time.Since(start).Seconds())
+	})
+}
+
+// ObserveCompression records sizes of a gzipped response body
+func (m *Metrics) ObserveCompression(raw, compressed int) {
+	if raw == 0 {
+		return
+	}
+	m.compressionRatio.Observe(float64(compressed) / float64(raw))
+}
+
+// Redirect counts short link expansion with given result
+func (m *Metrics) Redirect(result string) {
+	m.redirects.WithLabelValues(result).Inc()
+}
+
+// RateLimited counts request rejected by rate limiter of route group
+func (m *Metrics) RateLimited(group string) {
+	m.rateLimited.WithLabelValues(group).Inc()
+}
+
+// ObserveDeletionQueue reports depth of deletion queue as gauge, it must be called once per Metrics
+func (m *Metrics) ObserveDeletionQueue(depth func() int64) {
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "deletion_queue_depth",
+		Help:      "Number of IDs waiting for asynchronous deletion.",
+	}, func() float64 {
+		return float64(depth())
+	}))
+}
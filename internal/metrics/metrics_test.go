@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/store"
+)
+
+func TestMetrics_Middleware(t *testing.T) {
+	m := New()
+
+	r := chi.NewRouter()
+	r.Use(m.Middleware)
+	r.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTemporaryRedirect)
+	})
+	r.Get("/ok", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})
+
+	for _, path := range []string{"/a", "/b", "/ok", "/no/such/route"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.requests.WithLabelValues("/{id}", "GET", "307")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.requests.WithLabelValues("/ok", "GET", "200")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.requests.WithLabelValues(unmatchedRoute, "GET", "404")))
+}
+
+func TestMetrics_InstrumentStore(t *testing.T) {
+	m := New()
+	s := m.InstrumentStore(store.NewInMemory(), "memory")
+	ctx := context.Background()
+
+	u, _ := url.Parse("https://praktikum.yandex.ru")
+	_, err := s.Save(ctx, u)
+	require.NoError(t, err)
+	_, err = s.Save(ctx, u)
+	require.True(t, errors.Is(err, store.ErrConflict))
+	_, err = s.Load(ctx, "missing")
+	require.True(t, errors.Is(err, store.ErrNotFound))
+
+	assert.Equal(t, 2, testutil.CollectAndCount(m.storeDuration))
+	// expected outcomes are not failures
+	assert.Equal(t, 0, testutil.CollectAndCount(m.storeErrors))
+}
+
+func TestMetrics_Handler(t *testing.T) {
+	m := New()
+	m.Redirect("redirected")
+	m.ObserveCompression(100, 25)
+	m.ObserveCompression(0, 0)
+	m.RateLimited("batch")
+
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	require.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.Contains(t, body, `shortener_redirects_total{result="redirected"} 1`)
+	assert.Contains(t, body, "shortener_http_gzip_compression_ratio_count 1")
+	assert.Contains(t, body, `shortener_rate_limited_requests_total{group="batch"} 1`)
+	assert.Contains(t, body, "go_goroutines")
+}
+
+func TestMetrics_ObserveDeletionQueue(t *testing.T) {
+	// every instance owns its gauge, so instances may coexist in one process
+	for _, depth := range []int64{3, 5} {
+		m := New()
+		m.ObserveDeletionQueue(func() int64 { return depth })
+
+		w := httptest.NewRecorder()
+		m.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		assert.Contains(t, w.Body.String(), "shortener_deletion_queue_depth "+strconv.FormatInt(depth, 10))
+	}
+}
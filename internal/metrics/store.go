@@ -0,0 +1,204 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/store"
+)
+
+var _ store.AuthStore = (*instrumentedStore)(nil)
+
+// instrumentedStore measures every call of wrapped store
+type instrumentedStore struct {
+	next    store.AuthStore
+	backend string
+	m       *Metrics
+}
+
+// InstrumentStore returns store which reports latency and errors of calls labeled with backend name
+func (m *Metrics) InstrumentStore(s store.AuthStore, backend string) store.AuthStore {
+	return &instrumentedStore{next: s, backend: backend, m: m}
+}
+
+// observe must be deferred with pointer to named error result
+func (s *instrumentedStore) observe(method string, start time.Time, err *error) {
+	s.m.storeDuration.WithLabelValues(s.backend, method).Observe(time.Since(start).Seconds())
+	if *err != nil && !expected(*err) {
+		s.m.storeErrors.WithLabelValues(s.backend, method).Inc()
+	}
+}
+
+// observeResults is observe of batch call which fails if any of its links has failed
+func (s *instrumentedStore) observeResults(method string, start time.Time, results []store.SaveResult, err error) {
+	for _, res := range results {
+		if err == nil && res.Err != nil && !expected(res.Err) {
+			err = res.Err
+		}
+	}
+	s.observe(method, start, &err)
+}
+
+// expected reports whether error is a regular outcome of operation rather than failure
+func expected(err error) bool {
+	return errors.Is(err, store.ErrNotFound) ||
+		errors.Is(err, store.ErrConflict) ||
+		errors.Is(err, store.ErrDeleted) ||
+		errors.Is(err, store.ErrExpired)
+}
+
+func (s *instrumentedStore) Save(ctx context.Context, u *url.URL) (id string, err error) {
+	defer s.observe("Save", time.Now(), &err)
+	return s.next.Save(ctx, u)
+}
+
+func (s *instrumentedStore) Load(ctx context.Context, id string) (u *url.URL, err error) {
+	defer s.observe("Load", time.Now(), &err)
+	return s.next.Load(ctx, id)
+}
+
+func (s *instrumentedStore) Ping(ctx context.Context) (err error) {
+	defer s.observe("Ping", time.Now(), &err)
+	return s.next.Ping(ctx)
+}
+
+func (s *instrumentedStore) Close() error {
+	return s.next.Close()
+}
+
+func (s *instrumentedStore) SaveBatch(ctx context.Context, urls []*url.URL) (ids []string, err error) {
+	defer s.observe("SaveBatch", time.Now(), &err)
+	return s.next.SaveBatch(ctx, urls)
+}
+
+func (s *instrumentedStore) SaveUser(ctx context.Context, uid uuid.UUID, u *url.URL) (id string, err error) {
+	defer s.observe("SaveUser", time.Now(), &err)
+	return s.next.SaveUser(ctx, uid, u)
+}
+
+func (s *instrumentedStore) SaveUserBatch(ctx context.Context, uid uuid.UUID, urls []*url.URL) (ids []string, err error) {
+	defer s.observe("SaveUserBatch", time.Now(), &err)
+	return s.next.SaveUserBatch(ctx, uid, urls)
+}
+
+func (s *instrumentedStore) LoadUser(ctx context.Context, uid uuid.UUID, id string) (u *url.URL, err error) {
+	defer s.observe("LoadUser", time.Now(), &err)
+	return s.next.LoadUser(ctx, uid, id)
+}
+
+func (s *instrumentedStore) LoadUsers(ctx context.Context, uid uuid.UUID) (urls map[string]*url.URL, err error) {
+	defer s.observe("LoadUsers", time.Now(), &err)
+	return s.next.LoadUsers(ctx, uid)
+}
+
+func (s *instrumentedStore) DeleteUsers(ctx context.Context, uid uuid.UUID, ids ...string) (err error) {
+	defer s.observe("DeleteUsers", time.Now(), &err)
+	return s.next.DeleteUsers(ctx, uid, ids...)
+}
+
+func (s *instrumentedStore) DeleteBatch(ctx context.Context, reqs []store.DeleteRequest) (err error) {
+	defer s.observe("DeleteBatch", time.Now(), &err)
+	return s.next.DeleteBatch(ctx, reqs)
+}
+
+func (s *instrumentedStore) SaveLink(ctx context.Context, link store.Link) (id string, err error) {
+	defer s.observe("SaveLink", time.Now(), &err)
+	return s.next.SaveLink(ctx, link)
+}
+
+func (s *instrumentedStore) SaveUserLink(ctx context.Context, uid uuid.UUID, link store.Link) (id string, err error) {
+	defer s.observe("SaveUserLink", time.Now(), &err)
+	return s.next.SaveUserLink(ctx, uid, link)
+}
+
+func (s *instrumentedStore) SaveLinks(ctx context.Context, links []store.Link) (results []store.SaveResult, err error) {
+	start := time.Now()
+	results, err = s.next.SaveLinks(ctx, links)
+	s.observeResults("SaveLinks", start, results, err)
+	return results, err
+}
+
+func (s *instrumentedStore) SaveUserLinks(ctx context.Context, uid uuid.UUID, links []store.Link) (results []store.SaveResult, err error) {
+	start := time.Now()
+	results, err = s.next.SaveUserLinks(ctx, uid, links)
+	s.observeResults("SaveUserLinks", start, results, err)
+	return results, err
+}
+
+func (s *instrumentedStore) BeginLinks(ctx context.Context) (b store.LinkBatch, err error) {
+	defer s.observe("BeginLinks", time.Now(), &err)
+	b, err = s.next.BeginLinks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedBatch{next: b, s: s}, nil
+}
+
+func (s *instrumentedStore) BeginUserLinks(ctx context.Context, uid uuid.UUID) (b store.LinkBatch, err error) {
+	defer s.observe("BeginUserLinks", time.Now(), &err)
+	b, err = s.next.BeginUserLinks(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedBatch{next: b, s: s}, nil
+}
+
+// instrumentedBatch measures calls of wrapped batch as methods of its store
+type instrumentedBatch struct {
+	next store.LinkBatch
+	s    *instrumentedStore
+}
+
+func (b *instrumentedBatch) Save(ctx context.Context, links []store.Link) (ids []string, err error) {
+	defer b.s.observe("LinkBatch.Save", time.Now(), &err)
+	return b.next.Save(ctx, links)
+}
+
+func (b *instrumentedBatch) Commit(ctx context.Context) (err error) {
+	defer b.s.observe("LinkBatch.Commit", time.Now(), &err)
+	return b.next.Commit(ctx)
+}
+
+func (b *instrumentedBatch) Rollback() (err error) {
+	defer b.s.observe("LinkBatch.Rollback", time.Now(), &err)
+	return b.next.Rollback()
+}
+
+func (s *instrumentedStore) PurgeExpired(ctx context.Context, before time.Time) (n int, err error) {
+	defer s.observe("PurgeExpired", time.Now(), &err)
+	return s.next.PurgeExpired(ctx, before)
+}
+
+func (s *instrumentedStore) SaveVisits(ctx context.Context, visits []store.Visit) (err error) {
+	defer s.observe("SaveVisits", time.Now(), &err)
+	return s.next.SaveVisits(ctx, visits)
+}
+
+func (s *instrumentedStore) LoadStats(ctx context.Context, id string) (stats store.Stats, err error) {
+	defer s.observe("LoadStats", time.Now(), &err)
+	return s.next.LoadStats(ctx, id)
+}
+
+func (s *instrumentedStore) SaveAPIKey(ctx context.Context, key store.APIKey) (err error) {
+	defer s.observe("SaveAPIKey", time.Now(), &err)
+	return s.next.SaveAPIKey(ctx, key)
+}
+
+func (s *instrumentedStore) LoadAPIKey(ctx context.Context, hash string) (key store.APIKey, err error) {
+	defer s.observe("LoadAPIKey", time.Now(), &err)
+	return s.next.LoadAPIKey(ctx, hash)
+}
+
+func (s *instrumentedStore) LoadAPIKeys(ctx context.Context, uid uuid.UUID) (keys []store.APIKey, err error) {
+	defer s.observe("LoadAPIKeys", time.Now(), &err)
+	return s.next.LoadAPIKeys(ctx, uid)
+}
+
+func (s *instrumentedStore) RevokeAPIKey(ctx context.Context, uid uuid.UUID, id string) (err error) {
+	defer s.observe("RevokeAPIKey", time.Now(), &err)
+	return s.next.RevokeAPIKey(ctx, uid, id)
+}
@@ -0,0 +1,89 @@
+// Package profiler periodically writes runtime profiles to compare them offline
+package profiler
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// fileTimeLayout keeps profile files sorted by time of capture
+const fileTimeLayout = "20060102T150405"
+
+// Run writes heap profile and CPU profile sampled for cpuDuration into dir every interval until ctx is done.
+// CPU profile is skipped if another one is already in progress, e.g. captured via pprof endpoint.
+func Run(ctx context.Context, dir string, interval, cpuDuration time.Duration, logger *zap.Logger) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		logger.Error("cannot create profile directory", zap.String("dir", dir), zap.Error(err))
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		at := time.Now().UTC().Format(fileTimeLayout)
+		if err := writeHeap(filepath.Join(dir, "heap-"+at+".pprof")); err != nil {
+			logger.Error("cannot write heap profile", zap.Error(err))
+		}
+		if err := writeCPU(ctx, filepath.Join(dir, "cpu-"+at+".pprof"), cpuDuration); err != nil {
+			logger.Warn("cannot write CPU profile", zap.Error(err))
+		}
+	}
+}
+
+func writeHeap(path string) (err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("cannot create profile file: %w", err)
+	}
+	defer func() {
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = fmt.Errorf("cannot close profile file: %w", cerr)
+		}
+	}()
+
+	if err := pprof.Lookup("heap").WriteTo(f, 0); err != nil {
+		return fmt.Errorf("cannot write profile: %w", err)
+	}
+	return nil
+}
+
+// writeCPU samples CPU for d or until ctx is done
+func writeCPU(ctx context.Context, path string, d time.Duration) (err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("cannot create profile file: %w", err)
+	}
+	defer func() {
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = fmt.Errorf("cannot close profile file: %w", cerr)
+		}
+		if err != nil {
+			_ = os.Remove(path)
+		}
+	}()
+
+	if err := pprof.StartCPUProfile(f); err != nil {
+		return fmt.Errorf("cannot start profiling: %w", err)
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+	pprof.StopCPUProfile()
+	return nil
+}
@@ -0,0 +1,52 @@
+package profiler
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestRun(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "profiles")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		Run(ctx, dir, 50*time.Millisecond, 10*time.Millisecond, zap.NewNop())
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool {
+		cpu, _ := filepath.Glob(filepath.Join(dir, "cpu-*.pprof"))
+		heap, _ := filepath.Glob(filepath.Join(dir, "heap-*.pprof"))
+		return len(cpu) > 0 && len(heap) > 0
+	}, 2*time.Second, 10*time.Millisecond)
+
+	cancel()
+	<-done
+}
+
+func Test_writeCPU(t *testing.T) {
+	// profile in progress is left alone
+	require.NoError(t, pprof.StartCPUProfile(nopWriter{}))
+	defer pprof.StopCPUProfile()
+
+	path := filepath.Join(t.TempDir(), "cpu.pprof")
+	assert.Error(t, writeCPU(context.Background(), path, time.Millisecond))
+
+	_, err := os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+type nopWriter struct{}
+
+func (nopWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
@@ -0,0 +1,118 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Postgres keeps buckets in database, so limits are shared by all service instances
+type Postgres struct {
+	db *sql.DB
+
+	mu sync.Mutex
+	// idle is the longest time a bucket of any limiter takes to fill up,
+	// buckets untouched for longer are full and may be forgotten
+	idle time.Duration
+}
+
+func NewPostgres(db *sql.DB) *Postgres {
+	return &Postgres{db: db}
+}
+
+// Bootstrap creates table of buckets
+func (p *Postgres) Bootstrap(ctx context.Context) error {
+	query := `
+		CREATE TABLE IF NOT EXISTS rate_limits (
+			key text PRIMARY KEY,
+			tokens double precision NOT NULL,
+			allowed boolean NOT NULL,
+			updated_at timestamp with time zone NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS rate_limits_updated_at_idx ON rate_limits (updated_at);
+	`
+	if _, err := p.db.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("cannot create rate limits table: %w", err)
+	}
+	return nil
+}
+
+// Limiter returns limiter of rate, name separates its buckets from ones of other limiters
+func (p *Postgres) Limiter(name string, rate Rate) Limiter {
+	p.mu.Lock()
+	if full := rate.fullAfter(0); full > p.idle {
+		p.idle = full
+	}
+	p.mu.Unlock()
+	return &postgresLimiter{db: p.db, name: name, rate: rate}
+}
+
+// Prune deletes buckets which have filled up, so table does not grow with every client seen
+func (p *Postgres) Prune(ctx context.Context) (int64, error) {
+	p.mu.Lock()
+	idle := p.idle
+	p.mu.Unlock()
+
+	res, err := p.db.ExecContext(ctx,
+		`DELETE FROM rate_limits WHERE updated_at < now() - $1 * interval '1 second'`,
+		math.Ceil(idle.Seconds()))
+	if err != nil {
+		return 0, fmt.Errorf("cannot prune rate limits: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// RunPruner prunes buckets every interval until ctx is done
+func (p *Postgres) RunPruner(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := p.Prune(ctx); err != nil && ctx.Err() == nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+type postgresLimiter struct {
+	db   *sql.DB
+	name string
+	rate Rate
+}
+
+// allowQuery refills and takes a token of bucket in a single statement,
+// so concurrent requests of all instances are serialized by row lock;
+// SET expressions see the row as it was before update
+const allowQuery = `
+	INSERT INTO rate_limits AS b (key, tokens, allowed, updated_at)
+	VALUES ($1, $2 - 1, true, now())
+	ON CONFLICT (key) DO UPDATE SET
+		allowed = LEAST($2, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at) * $3) >= 1,
+		tokens = LEAST($2, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at) * $3)
+			- (LEAST($2, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at) * $3) >= 1)::int,
+		updated_at = now()
+	RETURNING allowed, tokens
+`
+
+func (l *postgresLimiter) Allow(ctx context.Context, key string) (bool, time.Duration, error) {
+	var (
+		allowed bool
+		tokens  float64
+	)
+	err := l.db.QueryRowContext(ctx, allowQuery, l.name+":"+key, float64(l.rate.Burst), l.rate.Limit).
+		Scan(&allowed, &tokens)
+	if err != nil {
+		return false, 0, fmt.Errorf("cannot take rate limit token: %w", err)
+	}
+	if !allowed {
+		return false, l.rate.wait(tokens), nil
+	}
+	return true, 0, nil
+}
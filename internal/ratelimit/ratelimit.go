@@ -0,0 +1,114 @@
+// Package ratelimit limits request rates of clients with token buckets
+package ratelimit
+
+import (
+	"container/list"
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Rate is a token bucket refilled by Limit tokens per second up to Burst tokens
+type Rate struct {
+	Limit float64
+	Burst int
+}
+
+// Every returns rate of n tokens per period with burst of n
+func Every(n int, period time.Duration) Rate {
+	return Rate{Limit: float64(n) / period.Seconds(), Burst: n}
+}
+
+// refill returns number of tokens in bucket elapsed after it held given ones
+func (r Rate) refill(tokens float64, elapsed time.Duration) float64 {
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	return math.Min(float64(r.Burst), tokens+elapsed.Seconds()*r.Limit)
+}
+
+// wait returns time until bucket holding tokens gets a whole one
+func (r Rate) wait(tokens float64) time.Duration {
+	return time.Duration(math.Ceil((1 - tokens) / r.Limit * float64(time.Second)))
+}
+
+// fullAfter returns time bucket holding tokens takes to fill up
+func (r Rate) fullAfter(tokens float64) time.Duration {
+	return time.Duration((float64(r.Burst) - tokens) / r.Limit * float64(time.Second))
+}
+
+// Limiter takes tokens of client buckets
+type Limiter interface {
+	// Allow takes a token of key bucket, if there is none
+	// retryAfter is time until the next one is available
+	Allow(ctx context.Context, key string) (ok bool, retryAfter time.Duration, err error)
+}
+
+var _ Limiter = (*Memory)(nil)
+
+// Memory keeps buckets of single process. Number of buckets is bounded,
+// the least recently used one is forgotten, i.e. refilled, when limit is reached.
+type Memory struct {
+	rate    Rate
+	maxKeys int
+	now     func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*list.Element
+	// lru holds buckets from the most recently used one
+	lru *list.List
+}
+
+type bucket struct {
+	key     string
+	tokens  float64
+	updated time.Time
+}
+
+// NewMemory returns limiter of rate keeping up to maxKeys buckets
+func NewMemory(rate Rate, maxKeys int) *Memory {
+	return &Memory{
+		rate:    rate,
+		maxKeys: maxKeys,
+		now:     time.Now,
+		buckets: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+func (m *Memory) Allow(_ context.Context, key string) (bool, time.Duration, error) {
+	now := m.now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b *bucket
+	if el, ok := m.buckets[key]; ok {
+		m.lru.MoveToFront(el)
+		b = el.Value.(*bucket)
+		b.tokens = m.rate.refill(b.tokens, now.Sub(b.updated))
+	} else {
+		if m.lru.Len() >= m.maxKeys {
+			oldest := m.lru.Back()
+			m.lru.Remove(oldest)
+			delete(m.buckets, oldest.Value.(*bucket).key)
+		}
+		b = &bucket{key: key, tokens: float64(m.rate.Burst)}
+		m.buckets[key] = m.lru.PushFront(b)
+	}
+	b.updated = now
+
+	if b.tokens < 1 {
+		return false, m.rate.wait(b.tokens), nil
+	}
+	b.tokens--
+	return true, 0, nil
+}
+
+// Len returns number of tracked buckets
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lru.Len()
+}
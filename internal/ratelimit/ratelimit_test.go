@@ -0,0 +1,121 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	_ "github.com/jackc/pgx/stdlib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	m := NewMemory(Every(2, time.Second), 2)
+	m.now = func() time.Time { return now }
+
+	allow := func(key string) (bool, time.Duration) {
+		ok, retryAfter, err := m.Allow(ctx, key)
+		require.NoError(t, err)
+		return ok, retryAfter
+	}
+
+	// burst is spent at once
+	for i := 0; i < 2; i++ {
+		ok, _ := allow("a")
+		assert.True(t, ok)
+	}
+	ok, retryAfter := allow("a")
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, retryAfter)
+
+	// other keys have their own buckets
+	ok, _ = allow("b")
+	assert.True(t, ok)
+
+	// token is refilled over time
+	now = now.Add(250 * time.Millisecond)
+	ok, retryAfter = allow("a")
+	assert.False(t, ok)
+	assert.Equal(t, 250*time.Millisecond, retryAfter)
+	now = now.Add(250 * time.Millisecond)
+	ok, _ = allow("a")
+	assert.True(t, ok)
+
+	// the least recently used bucket is forgotten
+	ok, _ = allow("c")
+	assert.True(t, ok)
+	assert.Equal(t, 2, m.Len())
+	for i := 0; i < 2; i++ {
+		ok, _ := allow("b")
+		assert.True(t, ok, "bucket of b is full again")
+	}
+}
+
+func TestMemory_concurrent(t *testing.T) {
+	m := NewMemory(Every(100, time.Hour), 10)
+
+	allowed := make(chan bool, 1000)
+	for i := 0; i < 10; i++ {
+		go func() {
+			for j := 0; j < 100; j++ {
+				ok, _, _ := m.Allow(context.Background(), "key")
+				allowed <- ok
+			}
+		}()
+	}
+
+	var n int
+	for i := 0; i < 1000; i++ {
+		if <-allowed {
+			n++
+		}
+	}
+	assert.Equal(t, 100, n)
+}
+
+// TestPostgres runs against throwaway database given by TEST_DATABASE_DSN
+func TestPostgres(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+	ctx := context.Background()
+
+	db, err := sql.Open("pgx", dsn)
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.ExecContext(ctx, `DROP TABLE IF EXISTS rate_limits;`)
+	require.NoError(t, err)
+
+	p := NewPostgres(db)
+	require.NoError(t, p.Bootstrap(ctx))
+
+	// instances share buckets of the same limiter name
+	first := p.Limiter("batch", Every(2, time.Hour))
+	second := p.Limiter("batch", Every(2, time.Hour))
+	other := p.Limiter("redirect", Every(2, time.Hour))
+
+	for _, l := range []Limiter{first, second} {
+		ok, _, err := l.Allow(ctx, "client")
+		require.NoError(t, err)
+		assert.True(t, ok)
+	}
+	ok, retryAfter, err := first.Allow(ctx, "client")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.InDelta(t, 30*time.Minute, retryAfter, float64(time.Minute))
+
+	ok, _, err = other.Allow(ctx, "client")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	// fresh buckets are kept
+	n, err := p.Prune(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	minAliasLength = 3
+	maxAliasLength = 32
+)
+
+// reservedAliases collide with router paths
+var reservedAliases = map[string]bool{
+	"api":  true,
+	"ping": true,
+}
+
+// pathAlias returns alias colliding with routes under path, i.e. its first segment
+func pathAlias(path string) string {
+	segment := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0]
+	return strings.ToLower(segment)
+}
+
+var errBadAlias = errors.New("bad alias")
+
+// validateAlias checks user chosen short ID, empty alias is valid;
+// reserved holds lowercase aliases of configured routes in addition to reservedAliases
+func validateAlias(alias string, reserved map[string]bool) error {
+	if alias == "" {
+		return nil
+	}
+	if len(alias) < minAliasLength || len(alias) > maxAliasLength {
+		return fmt.Errorf("%w: length must be from %d to %d characters", errBadAlias, minAliasLength, maxAliasLength)
+	}
+	for _, c := range alias {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return fmt.Errorf("%w: only latin letters, digits, '-' and '_' are allowed", errBadAlias)
+		}
+	}
+	if lower := strings.ToLower(alias); reservedAliases[lower] || reserved[lower] {
+		return fmt.Errorf("%w: %q is reserved", errBadAlias, alias)
+	}
+	return nil
+}
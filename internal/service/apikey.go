@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/auth"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/store"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/models"
+)
+
+const maxAPIKeyNameLength = 64
+
+// CreateAPIKey issues API key of caller, its token is returned only here
+func (s *Service) CreateAPIKey(ctx context.Context, name string) (models.APIKeyResponse, error) {
+	uid, err := user(ctx)
+	if err != nil {
+		return models.APIKeyResponse{}, err
+	}
+	if name == "" || utf8.RuneCountInString(name) > maxAPIKeyNameLength {
+		return models.APIKeyResponse{}, Errorf(Validation, "name must be from 1 to %d characters", maxAPIKeyNameLength)
+	}
+
+	token, hash, err := auth.NewAPIKey()
+	if err != nil {
+		return models.APIKeyResponse{}, fmt.Errorf("cannot generate API key: %w", err)
+	}
+	key := store.APIKey{
+		ID:        uuid.Must(uuid.NewV4()).String(),
+		UID:       uid,
+		Name:      name,
+		Hash:      hash,
+		CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+	}
+	if err := s.store.SaveAPIKey(ctx, key); err != nil {
+		return models.APIKeyResponse{}, fmt.Errorf("cannot save API key: %w", err)
+	}
+
+	return models.APIKeyResponse{ID: key.ID, Name: key.Name, Key: token, CreatedAt: key.CreatedAt}, nil
+}
+
+// APIKeys returns active API keys of caller without their tokens
+func (s *Service) APIKeys(ctx context.Context) ([]models.APIKeyResponse, error) {
+	uid, err := user(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := s.store.LoadAPIKeys(ctx, uid)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load API keys: %w", err)
+	}
+
+	resp := make([]models.APIKeyResponse, 0, len(keys))
+	for _, key := range keys {
+		resp = append(resp, models.APIKeyResponse{ID: key.ID, Name: key.Name, CreatedAt: key.CreatedAt})
+	}
+	return resp, nil
+}
+
+// RevokeAPIKey revokes API key of caller
+func (s *Service) RevokeAPIKey(ctx context.Context, id string) error {
+	uid, err := user(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = s.store.RevokeAPIKey(ctx, uid, id)
+	if errors.Is(err, store.ErrNotFound) {
+		return &Error{Kind: NotFound, Message: "API key not found", Err: err}
+	}
+	if err != nil {
+		return fmt.Errorf("cannot revoke API key: %w", err)
+	}
+	return nil
+}
+
+// APIKeyUID returns owner of bearer token, unknown and revoked tokens are reported with auth.ErrInvalidAPIKey
+func (s *Service) APIKeyUID(ctx context.Context, token string) (uuid.UUID, error) {
+	key, err := s.store.LoadAPIKey(ctx, auth.HashAPIKey(token))
+	if errors.Is(err, store.ErrNotFound) || errors.Is(err, store.ErrDeleted) {
+		return uuid.Nil, auth.ErrInvalidAPIKey
+	}
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("cannot load API key: %w", err)
+	}
+	return key.UID, nil
+}
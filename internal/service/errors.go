@@ -0,0 +1,125 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Kind tells what went wrong, transports map it to their own status codes
+type Kind int
+
+const (
+	// Internal failures are not caller's fault, their causes are not shown to clients
+	Internal Kind = iota
+	// Validation means request is malformed
+	Validation
+	// NotFound means requested record does not exist
+	NotFound
+	// Gone means requested record is deleted or expired
+	Gone
+	// Conflict means record with the same URL or alias already exists
+	Conflict
+	// Forbidden means caller has no rights for the operation
+	Forbidden
+	// Unavailable means operation may succeed if retried later
+	Unavailable
+	// TooLarge means request exceeds configured size
+	TooLarge
+)
+
+var kindNames = [...]string{
+	Internal:    "internal",
+	Validation:  "validation",
+	NotFound:    "not_found",
+	Gone:        "gone",
+	Conflict:    "conflict",
+	Forbidden:   "forbidden",
+	Unavailable: "unavailable",
+	TooLarge:    "too_large",
+}
+
+func (k Kind) String() string {
+	if k < 0 || int(k) >= len(kindNames) {
+		return fmt.Sprintf("kind(%d)", int(k))
+	}
+	return kindNames[k]
+}
+
+// Error is a failure of known kind with message safe to show to clients
+type Error struct {
+	Kind    Kind
+	Message string
+	// Details point to parts of request which caused the error
+	Details []Detail
+	// Err is an underlying cause, it is not shown to clients
+	Err error
+}
+
+// Detail is a problem with single part of request
+type Detail struct {
+	// Index is a position of item in batch request, nil for other requests
+	Index *int
+	// Field is a name of request field, e.g. "alias"
+	Field   string
+	Message string
+}
+
+// Errorf returns error of kind with formatted message
+func Errorf(kind Kind, format string, args ...interface{}) error {
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, args...)}
+}
+
+// invalidField returns validation error of request field
+func invalidField(field, format string, args ...interface{}) error {
+	msg := fmt.Sprintf(format, args...)
+	return &Error{Kind: Validation, Message: msg, Details: []Detail{{Field: field, Message: msg}}}
+}
+
+// atIndex points details of err to batch item n
+func atIndex(err error, n int) error {
+	var e *Error
+	if !errors.As(err, &e) {
+		return err
+	}
+
+	indexed := *e
+	indexed.Details = make([]Detail, 0, len(e.Details))
+	for _, d := range e.Details {
+		d.Index = &n
+		indexed.Details = append(indexed.Details, d)
+	}
+	if len(indexed.Details) == 0 {
+		indexed.Details = append(indexed.Details, Detail{Index: &n, Message: e.Message})
+	}
+	return &indexed
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Message
+	}
+	return e.Message + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// KindOf returns kind of err, errors not made by service are internal
+func KindOf(err error) Kind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return Internal
+}
+
+// Describe returns kind of err with message and details to show to clients,
+// messages of internal errors are hidden
+func Describe(err error) (Kind, string, []Detail) {
+	var e *Error
+	if errors.As(err, &e) && e.Kind != Internal {
+		return e.Kind, e.Message, e.Details
+	}
+	return Internal, "internal error", nil
+}
@@ -0,0 +1,29 @@
+package service
+
+import (
+	"time"
+)
+
+// MaxTTL bounds link lifetime given in seconds, so expiry moment does not overflow
+const MaxTTL = int64(10 * 365 * 24 * time.Hour / time.Second)
+
+// linkExpiry returns moment link expires at, zero time means never.
+// ttl is given in seconds and is mutually exclusive with expiresAt.
+func linkExpiry(ttl int64, expiresAt *time.Time, now time.Time) (time.Time, error) {
+	switch {
+	case ttl != 0 && expiresAt != nil:
+		return time.Time{}, invalidField("expires_at", "bad expiry: ttl and expires_at are mutually exclusive")
+	case ttl < 0:
+		return time.Time{}, invalidField("ttl", "bad expiry: ttl must be positive")
+	case ttl > MaxTTL:
+		return time.Time{}, invalidField("ttl", "bad expiry: ttl must be at most %d seconds", MaxTTL)
+	case ttl > 0:
+		return now.Add(time.Duration(ttl) * time.Second), nil
+	case expiresAt != nil:
+		if !expiresAt.After(now) {
+			return time.Time{}, invalidField("expires_at", "bad expiry: expires_at must be in the future")
+		}
+		return *expiresAt, nil
+	}
+	return time.Time{}, nil
+}
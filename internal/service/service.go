@@ -0,0 +1,142 @@
+// Package service holds business logic of shortener shared by all transports.
+// Failures caused by callers are reported with *Error of matching Kind.
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/auth"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/deletion"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/metrics"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/stats"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/store"
+)
+
+// Service shortens links, caller is taken from context set by auth.Context
+type Service struct {
+	baseURL string
+
+	store store.AuthStore
+
+	recorder *stats.Recorder
+	ipKey    []byte
+
+	deletions *deletion.Queue
+
+	metrics *metrics.Metrics
+
+	// maxBatchSize bounds number of links in a batch, zero means unlimited
+	maxBatchSize int
+
+	// reserved aliases collide with configured routes
+	reserved map[string]bool
+}
+
+// Option tunes Service
+type Option func(s *Service)
+
+// WithRecorder enables visits recording, client IPs are hashed with ipKey
+func WithRecorder(recorder *stats.Recorder, ipKey []byte) Option {
+	return func(s *Service) {
+		s.recorder = recorder
+		s.ipKey = ipKey
+	}
+}
+
+// WithDeletionQueue makes URLs deletion asynchronous
+func WithDeletionQueue(q *deletion.Queue) Option {
+	return func(s *Service) {
+		s.deletions = q
+	}
+}
+
+// WithMetrics enables counting of short link expansions
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(s *Service) {
+		s.metrics = m
+	}
+}
+
+// WithMaxBatchSize rejects batches of more than n links
+func WithMaxBatchSize(n int) Option {
+	return func(s *Service) {
+		s.maxBatchSize = n
+	}
+}
+
+// WithReservedPaths rejects aliases colliding with routes served under paths,
+// e.g. "/metrics" or "/debug"
+func WithReservedPaths(paths ...string) Option {
+	return func(s *Service) {
+		if s.reserved == nil {
+			s.reserved = make(map[string]bool, len(paths))
+		}
+		for _, path := range paths {
+			if alias := pathAlias(path); alias != "" {
+				s.reserved[alias] = true
+			}
+		}
+	}
+}
+
+func New(baseURL string, storage store.AuthStore, opts ...Option) *Service {
+	s := &Service{
+		baseURL: baseURL,
+		store:   storage,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Ping checks storage is available
+func (s *Service) Ping(ctx context.Context) error {
+	// ensure everything is okay
+	for j := 0; j < 3; j++ {
+		if err := s.store.Ping(ctx); err != nil {
+			return fmt.Errorf("cannot ping storage: %w", err)
+		}
+	}
+	return nil
+}
+
+// MaxBatchSize returns number of links in a batch beyond which it is rejected, zero means unlimited
+func (s *Service) MaxBatchSize() int {
+	return s.maxBatchSize
+}
+
+// CheckBatchSize rejects empty batches and ones exceeding configured size,
+// transports reading batch in parts check its total size with it
+func (s *Service) CheckBatchSize(n int) error {
+	if n == 0 {
+		return Errorf(Validation, "empty URLs list given")
+	}
+	if s.maxBatchSize > 0 && n > s.maxBatchSize {
+		return Errorf(TooLarge, "batch must contain at most %d links", s.maxBatchSize)
+	}
+	return nil
+}
+
+// shortURL composes short URL of ID
+func (s *Service) shortURL(id string) string {
+	return s.baseURL + "/" + id
+}
+
+// user returns caller of user scoped operation
+func user(ctx context.Context) (uuid.UUID, error) {
+	uid := auth.UIDFromContext(ctx)
+	if uid == nil {
+		return uuid.Nil, Errorf(Forbidden, "user identity is required")
+	}
+	return *uid, nil
+}
+
+func (s *Service) countRedirect(result string) {
+	if s.metrics != nil {
+		s.metrics.Redirect(result)
+	}
+}
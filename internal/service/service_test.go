@@ -0,0 +1,246 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/auth"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/deletion"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/store"
+)
+
+func TestService_Shorten(t *testing.T) {
+	ctx := auth.Context(context.Background(), uuid.Must(uuid.NewV4()))
+	s := New("http://localhost:8080", store.NewInMemory())
+
+	shortURL, err := s.Shorten(ctx, LinkRequest{URL: "https://praktikum.yandex.ru/", Alias: "spring-sale"})
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost:8080/spring-sale", shortURL)
+
+	// existing short URL comes along with conflict
+	shortURL, err = s.Shorten(ctx, LinkRequest{URL: "https://praktikum.yandex.ru/"})
+	require.NoError(t, err)
+	again, err := s.Shorten(ctx, LinkRequest{URL: "https://praktikum.yandex.ru/"})
+	assert.Equal(t, Conflict, KindOf(err))
+	assert.ErrorIs(t, err, store.ErrConflict)
+	assert.Equal(t, shortURL, again)
+
+	// taken alias does not reveal short URL of its owner
+	shortURL, err = s.Shorten(ctx, LinkRequest{URL: "https://yandex.ru/", Alias: "spring-sale"})
+	assert.Equal(t, Conflict, KindOf(err))
+	assert.ErrorIs(t, err, store.ErrConflict)
+	assert.Empty(t, shortURL)
+	_, msg, details := Describe(err)
+	assert.Equal(t, `alias "spring-sale" is already taken`, msg)
+	require.Len(t, details, 1)
+	assert.Equal(t, "alias", details[0].Field)
+
+	past := time.Now().Add(-time.Hour)
+	for _, req := range []LinkRequest{
+		{URL: "htt_p://o.com"},
+		{URL: "https://yandex.ru/", Alias: "api"},
+		{URL: "https://yandex.ru/", TTL: -1},
+		{URL: "https://yandex.ru/", ExpiresAt: &past},
+	} {
+		_, err := s.Shorten(ctx, req)
+		assert.Equal(t, Validation, KindOf(err), "request %+v", req)
+	}
+}
+
+func TestService_ShortenReservedPaths(t *testing.T) {
+	ctx := auth.Context(context.Background(), uuid.Must(uuid.NewV4()))
+	s := New("http://localhost:8080", store.NewInMemory(), WithReservedPaths("/metrics", "/debug/", "/internal/stats"))
+
+	for _, alias := range []string{"metrics", "Debug", "internal", "api"} {
+		_, err := s.Shorten(ctx, LinkRequest{URL: "https://yandex.ru/", Alias: alias})
+		assert.Equal(t, Validation, KindOf(err), "alias %s", alias)
+	}
+	_, err := s.Shorten(ctx, LinkRequest{URL: "https://yandex.ru/", Alias: "stats"})
+	assert.NoError(t, err)
+}
+
+func TestService_ShortenBatch(t *testing.T) {
+	ctx := context.Background()
+	s := New("http://localhost:8080", store.NewInMemory())
+
+	shortURLs, err := s.ShortenBatch(ctx, []LinkRequest{
+		{URL: "https://yandex.ru/"},
+		{URL: "https://go.dev/", Alias: "golang"},
+		{URL: "https://ya.ru/"},
+	})
+	require.NoError(t, err)
+	require.Len(t, shortURLs, 3)
+	assert.Equal(t, "http://localhost:8080/golang", shortURLs[1])
+
+	_, err = s.ShortenBatch(ctx, nil)
+	assert.Equal(t, Validation, KindOf(err))
+
+	limited := New("http://localhost:8080", store.NewInMemory(), WithMaxBatchSize(2))
+	_, err = limited.ShortenBatch(ctx, make([]LinkRequest, 3))
+	assert.Equal(t, TooLarge, KindOf(err))
+
+	_, err = s.ShortenBatch(ctx, []LinkRequest{{URL: "https://go.dev/", Alias: "golang"}})
+	assert.Equal(t, Conflict, KindOf(err))
+	kind, msg, details := Describe(err)
+	assert.Equal(t, Conflict, kind)
+	assert.Equal(t, `alias "golang" is already taken`, msg)
+	require.Len(t, details, 1)
+	require.NotNil(t, details[0].Index)
+	assert.Equal(t, 0, *details[0].Index)
+	assert.Equal(t, "alias", details[0].Field)
+
+	// conflict of a later link leaves earlier ones unsaved
+	_, err = s.ShortenBatch(ctx, []LinkRequest{
+		{URL: "https://praktikum.yandex.ru/", Alias: "praktikum"},
+		{URL: "https://go.dev/doc/", TTL: 60},
+		{URL: "https://go.dev/", Alias: "golang"},
+	})
+	assert.Equal(t, Conflict, KindOf(err))
+	_, _, details = Describe(err)
+	require.Len(t, details, 1)
+	require.NotNil(t, details[0].Index)
+	assert.Equal(t, 2, *details[0].Index)
+	_, err = s.Expand(ctx, "praktikum")
+	assert.Equal(t, NotFound, KindOf(err))
+
+	// invalid item is pointed to by index
+	_, err = s.ShortenBatch(ctx, []LinkRequest{{URL: "https://yandex.ru/"}, {URL: "https://ya.ru/", TTL: -1}})
+	_, _, details = Describe(err)
+	require.Len(t, details, 1)
+	require.NotNil(t, details[0].Index)
+	assert.Equal(t, 1, *details[0].Index)
+	assert.Equal(t, "ttl", details[0].Field)
+	assert.Equal(t, "bad expiry: ttl must be positive", details[0].Message)
+}
+
+func Test_linkExpiry(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	at, err := linkExpiry(MaxTTL, nil, now)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(10*365*24*time.Hour), at)
+
+	for _, ttl := range []int64{MaxTTL + 1, math.MaxInt64} {
+		_, err = linkExpiry(ttl, nil, now)
+		assert.Equal(t, Validation, KindOf(err), "ttl %d", ttl)
+	}
+}
+
+// failingStore cannot save links to fail.example
+type failingStore struct {
+	store.AuthStore
+}
+
+func (f failingStore) SaveLinks(ctx context.Context, links []store.Link) ([]store.SaveResult, error) {
+	results, err := f.AuthStore.SaveLinks(ctx, links)
+	for n, link := range links {
+		if link.URL.Host == "fail.example" {
+			results[n] = store.SaveResult{Err: errors.New("disk is full")}
+		}
+	}
+	return results, err
+}
+
+func TestService_ShortenEach(t *testing.T) {
+	ctx := context.Background()
+	s := New("http://localhost:8080", failingStore{store.NewInMemory()})
+
+	_, err := s.Shorten(ctx, LinkRequest{URL: "https://go.dev/", Alias: "golang"})
+	require.NoError(t, err)
+
+	results, err := s.ShortenEach(ctx, []LinkRequest{
+		{URL: "https://yandex.ru/"},
+		{URL: "htt_p://o.com"},
+		{URL: "https://yandex.ru/"},
+		{URL: "https://ya.ru/", Alias: "golang"},
+		{URL: "https://fail.example/"},
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 5)
+
+	assert.Equal(t, StatusCreated, results[0].Status)
+	assert.NoError(t, results[0].Err)
+	assert.Equal(t, StatusInvalid, results[1].Status)
+	assert.Equal(t, Validation, KindOf(results[1].Err))
+	assert.Equal(t, StatusExisting, results[2].Status)
+	assert.Equal(t, results[0].ShortURL, results[2].ShortURL)
+	assert.Equal(t, StatusConflict, results[3].Status)
+	assert.Equal(t, Conflict, KindOf(results[3].Err))
+	assert.Empty(t, results[3].ShortURL)
+	assert.Equal(t, StatusError, results[4].Status)
+	assert.Equal(t, Internal, KindOf(results[4].Err))
+
+	_, err = s.ShortenEach(ctx, nil)
+	assert.Equal(t, Validation, KindOf(err))
+}
+
+func TestService_Expand(t *testing.T) {
+	ctx := context.Background()
+	storage := store.NewInMemory()
+	u, _ := url.Parse("https://praktikum.yandex.ru/")
+	id, err := storage.SaveLink(ctx, store.Link{URL: u})
+	require.NoError(t, err)
+	expiredID, err := storage.SaveLink(ctx, store.Link{URL: u, Alias: "expired", ExpiresAt: time.Now().Add(-time.Second)})
+	require.NoError(t, err)
+	s := New("http://localhost:8080", storage)
+
+	target, err := s.Expand(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, u.String(), target.String())
+
+	_, err = s.Expand(ctx, "")
+	assert.Equal(t, Validation, KindOf(err))
+	_, err = s.Expand(ctx, "missing")
+	assert.Equal(t, NotFound, KindOf(err))
+	_, err = s.Expand(ctx, expiredID)
+	assert.Equal(t, Gone, KindOf(err))
+}
+
+func TestService_user(t *testing.T) {
+	uid := uuid.Must(uuid.NewV4())
+	ctx := auth.Context(context.Background(), uid)
+	storage := store.NewInMemory()
+	queue := deletion.NewQueue(storage, 1, 1, 100, time.Hour, zap.NewNop())
+	s := New("http://localhost:8080", storage, WithDeletionQueue(queue))
+
+	// anonymous callers cannot touch user links
+	_, err := s.UserURLs(context.Background())
+	assert.Equal(t, Forbidden, KindOf(err))
+	assert.Equal(t, Forbidden, KindOf(s.DeleteUserURLs(context.Background(), []string{"a"})))
+	_, err = s.LinkStats(context.Background(), "a")
+	assert.Equal(t, Forbidden, KindOf(err))
+
+	urls, err := s.UserURLs(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, urls)
+
+	_, err = s.LinkStats(ctx, "missing")
+	assert.Equal(t, NotFound, KindOf(err))
+
+	assert.Equal(t, Validation, KindOf(s.DeleteUserURLs(ctx, nil)))
+	require.NoError(t, s.DeleteUserURLs(ctx, []string{"a"}))
+	require.NoError(t, queue.Close())
+	assert.Equal(t, Unavailable, KindOf(s.DeleteUserURLs(ctx, []string{"a"})))
+}
+
+func TestDescribe(t *testing.T) {
+	kind, msg, details := Describe(errors.New("connection refused"))
+	assert.Equal(t, Internal, kind)
+	assert.Equal(t, "internal error", msg)
+	assert.Empty(t, details)
+
+	err := &Error{Kind: NotFound, Message: "short URL not found", Err: store.ErrNotFound}
+	kind, msg, _ = Describe(err)
+	assert.Equal(t, NotFound, kind)
+	assert.Equal(t, "short URL not found", msg)
+	assert.Equal(t, "short URL not found: not found", err.Error())
+}
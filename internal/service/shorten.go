@@ -0,0 +1,284 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/auth"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/stats"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/store"
+)
+
+// LinkRequest is a link to shorten as given by client
+type LinkRequest struct {
+	URL   string
+	Alias string
+	// TTL is a link lifetime in seconds, it excludes ExpiresAt
+	TTL       int64
+	ExpiresAt *time.Time
+}
+
+// link validates request, reserved holds aliases of configured routes
+func (req LinkRequest) link(now time.Time, reserved map[string]bool) (store.Link, error) {
+	u, err := url.Parse(req.URL)
+	if err != nil {
+		return store.Link{}, invalidField("url", "cannot parse given string as URL: %s", req.URL)
+	}
+	if err := validateAlias(req.Alias, reserved); err != nil {
+		return store.Link{}, invalidField("alias", "%s", err)
+	}
+	expiresAt, err := linkExpiry(req.TTL, req.ExpiresAt, now)
+	if err != nil {
+		return store.Link{}, err
+	}
+	return store.Link{URL: u, Alias: req.Alias, ExpiresAt: expiresAt}, nil
+}
+
+// Shorten saves link and returns its short URL. If URL was shortened before,
+// the existing short URL is returned along with Conflict error; taken alias
+// is reported by Conflict error alone, so short URLs of other users do not leak.
+func (s *Service) Shorten(ctx context.Context, req LinkRequest) (shortURL string, err error) {
+	link, err := req.link(time.Now(), s.reserved)
+	if err != nil {
+		return "", err
+	}
+
+	id, err := s.save(ctx, link)
+	if errors.Is(err, store.ErrConflict) && link.Alias != "" {
+		return "", aliasTaken(link.Alias, err)
+	}
+	if errors.Is(err, store.ErrConflict) {
+		return s.shortURL(id), &Error{Kind: Conflict, Message: "URL is already shortened", Err: err}
+	}
+	if err != nil {
+		return "", err
+	}
+	return s.shortURL(id), nil
+}
+
+// ShortenBatch validates all links first and saves either all of them or none
+func (s *Service) ShortenBatch(ctx context.Context, reqs []LinkRequest) (shortURLs []string, err error) {
+	if err := s.CheckBatchSize(len(reqs)); err != nil {
+		return nil, err
+	}
+
+	b, err := s.BeginBatch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Rollback()
+
+	shortURLs, err = b.Shorten(ctx, reqs)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return shortURLs, nil
+}
+
+// Batch shortens links of a single batch part by part, either all of them are saved or none.
+// Short URLs of parts are valid only once batch is committed.
+type Batch struct {
+	s   *Service
+	b   store.LinkBatch
+	now time.Time
+}
+
+// BeginBatch starts batch on behalf of caller, it must be either committed or rolled back
+func (s *Service) BeginBatch(ctx context.Context) (*Batch, error) {
+	var b store.LinkBatch
+	var err error
+	if uid := auth.UIDFromContext(ctx); uid != nil {
+		b, err = s.store.BeginUserLinks(ctx, *uid)
+	} else {
+		b, err = s.store.BeginLinks(ctx)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot start batch: %w", err)
+	}
+	return &Batch{s: s, b: b, now: time.Now()}, nil
+}
+
+// Shorten validates and saves next part of batch, errors point to items of the part
+func (b *Batch) Shorten(ctx context.Context, reqs []LinkRequest) (shortURLs []string, err error) {
+	links := make([]store.Link, 0, len(reqs))
+	for n, req := range reqs {
+		link, err := req.link(b.now, b.s.reserved)
+		if err != nil {
+			return nil, atIndex(err, n)
+		}
+		links = append(links, link)
+	}
+
+	ids, err := b.b.Save(ctx, links)
+	var linkErr *store.LinkError
+	if errors.As(err, &linkErr) && errors.Is(err, store.ErrConflict) && linkErr.Index < len(links) {
+		return nil, atIndex(aliasTaken(links[linkErr.Index].Alias, err), linkErr.Index)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot save URLs to storage: %w", err)
+	}
+	if len(ids) != len(links) {
+		return nil, errors.New("invalid saved IDs length")
+	}
+
+	shortURLs = make([]string, 0, len(ids))
+	for _, id := range ids {
+		shortURLs = append(shortURLs, b.s.shortURL(id))
+	}
+	return shortURLs, nil
+}
+
+func (b *Batch) Commit(ctx context.Context) error {
+	if err := b.b.Commit(ctx); err != nil {
+		return fmt.Errorf("cannot commit batch: %w", err)
+	}
+	return nil
+}
+
+// Rollback discards batch unless it is committed
+func (b *Batch) Rollback() error {
+	return b.b.Rollback()
+}
+
+// aliasTaken reports alias chosen by another link
+func aliasTaken(alias string, err error) *Error {
+	msg := fmt.Sprintf("alias %q is already taken", alias)
+	return &Error{Kind: Conflict, Message: msg, Details: []Detail{{Field: "alias", Message: msg}}, Err: err}
+}
+
+// ItemStatus is an outcome of shortening single link of batch
+type ItemStatus string
+
+const (
+	// StatusCreated means link got new short URL
+	StatusCreated ItemStatus = "created"
+	// StatusExisting means URL is already shortened, its short URL is returned
+	StatusExisting ItemStatus = "existing"
+	// StatusConflict means alias is already taken, no short URL is returned
+	StatusConflict ItemStatus = "conflict"
+	// StatusInvalid means link request is malformed
+	StatusInvalid ItemStatus = "invalid"
+	// StatusError means link cannot be saved for reasons not caused by caller
+	StatusError ItemStatus = "error"
+)
+
+// ItemResult is an outcome of shortening single link of batch
+type ItemResult struct {
+	Status   ItemStatus
+	ShortURL string
+	// Err tells why link is not created, it is nil for created and existing links
+	Err error
+}
+
+// ShortenEach saves every link independently, so invalid or failed links do not affect others
+func (s *Service) ShortenEach(ctx context.Context, reqs []LinkRequest) ([]ItemResult, error) {
+	if err := s.CheckBatchSize(len(reqs)); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	results := make([]ItemResult, len(reqs))
+	links := make([]store.Link, 0, len(reqs))
+	var valid []int
+	for n, req := range reqs {
+		link, err := req.link(now, s.reserved)
+		if err != nil {
+			results[n] = ItemResult{Status: StatusInvalid, Err: err}
+			continue
+		}
+		links = append(links, link)
+		valid = append(valid, n)
+	}
+	if len(links) == 0 {
+		return results, nil
+	}
+
+	var saved []store.SaveResult
+	var err error
+	if uid := auth.UIDFromContext(ctx); uid != nil {
+		saved, err = s.store.SaveUserLinks(ctx, *uid, links)
+	} else {
+		saved, err = s.store.SaveLinks(ctx, links)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot save URLs to storage: %w", err)
+	}
+	if len(saved) != len(links) {
+		return nil, errors.New("invalid saved results length")
+	}
+
+	for k, res := range saved {
+		n, link := valid[k], links[k]
+		switch {
+		case res.Err == nil:
+			results[n] = ItemResult{Status: StatusCreated, ShortURL: s.shortURL(res.ID)}
+		case errors.Is(res.Err, store.ErrConflict) && link.Alias != "":
+			results[n] = ItemResult{Status: StatusConflict, Err: aliasTaken(link.Alias, res.Err)}
+		case errors.Is(res.Err, store.ErrConflict):
+			results[n] = ItemResult{Status: StatusExisting, ShortURL: s.shortURL(res.ID)}
+		default:
+			results[n] = ItemResult{Status: StatusError, Err: fmt.Errorf("cannot save URL to storage: %w", res.Err)}
+		}
+	}
+	return results, nil
+}
+
+// save stores link on behalf of caller, store.ErrConflict is returned along with existing ID
+func (s *Service) save(ctx context.Context, link store.Link) (id string, err error) {
+	if uid := auth.UIDFromContext(ctx); uid != nil {
+		id, err = s.store.SaveUserLink(ctx, *uid, link)
+	} else {
+		id, err = s.store.SaveLink(ctx, link)
+	}
+
+	if err != nil && !errors.Is(err, store.ErrConflict) {
+		return "", fmt.Errorf("cannot save URL to storage: %w", err)
+	}
+	return id, err
+}
+
+// Expand returns original URL of short ID and counts the redirect
+func (s *Service) Expand(ctx context.Context, id string) (*url.URL, error) {
+	if id == "" {
+		return nil, Errorf(Validation, "bad ID given")
+	}
+
+	target, err := s.store.Load(ctx, id)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		s.countRedirect("not_found")
+		return nil, &Error{Kind: NotFound, Message: "short URL not found", Err: err}
+	case errors.Is(err, store.ErrDeleted):
+		s.countRedirect("gone")
+		return nil, &Error{Kind: Gone, Message: "short URL is deleted", Err: err}
+	case errors.Is(err, store.ErrExpired):
+		s.countRedirect("gone")
+		return nil, &Error{Kind: Gone, Message: "short URL is expired", Err: err}
+	case err != nil:
+		return nil, fmt.Errorf("cannot load URL: %w", err)
+	}
+
+	s.countRedirect("redirected")
+	return target, nil
+}
+
+// RecordVisit hands visit of short ID over to recorder, it never blocks
+func (s *Service) RecordVisit(id, referrer, userAgent, ip string) {
+	if s.recorder == nil {
+		return
+	}
+
+	s.recorder.Record(store.Visit{
+		ID:        id,
+		At:        time.Now(),
+		Referrer:  referrer,
+		UserAgent: userAgent,
+		IPHash:    stats.HashIP(s.ipKey, ip),
+	})
+}
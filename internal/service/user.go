@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/deletion"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/store"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/models"
+)
+
+// UserURLs returns links shortened by caller, no links is not an error
+func (s *Service) UserURLs(ctx context.Context) ([]models.URLResponse, error) {
+	uid, err := user(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	urls, err := s.store.LoadUsers(ctx, uid)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot load user URLs: %w", err)
+	}
+
+	resp := make([]models.URLResponse, 0, len(urls))
+	for id, u := range urls {
+		resp = append(resp, models.URLResponse{
+			ShortURL:    s.shortURL(id),
+			OriginalURL: u.String(),
+		})
+	}
+	return resp, nil
+}
+
+// DeleteUserURLs deletes links of caller, asynchronously if deletion queue is set
+func (s *Service) DeleteUserURLs(ctx context.Context, ids []string) error {
+	uid, err := user(ctx)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return Errorf(Validation, "empty IDs list given")
+	}
+
+	if s.deletions != nil {
+		err := s.deletions.Enqueue(uid, ids)
+		if errors.Is(err, deletion.ErrQueueFull) || errors.Is(err, deletion.ErrClosed) {
+			return &Error{Kind: Unavailable, Message: err.Error(), Err: err}
+		}
+		return err
+	}
+	if err := s.store.DeleteUsers(ctx, uid, ids...); err != nil {
+		return fmt.Errorf("cannot delete URLs: %w", err)
+	}
+	return nil
+}
+
+// LinkStats returns visits of caller's link, deleted and expired links still have their stats
+func (s *Service) LinkStats(ctx context.Context, id string) (models.LinkStatsResponse, error) {
+	uid, err := user(ctx)
+	if err != nil {
+		return models.LinkStatsResponse{}, err
+	}
+	if id == "" {
+		return models.LinkStatsResponse{}, Errorf(Validation, "bad ID given")
+	}
+
+	_, err = s.store.LoadUser(ctx, uid, id)
+	if errors.Is(err, store.ErrNotFound) {
+		return models.LinkStatsResponse{}, &Error{Kind: NotFound, Message: "short URL not found", Err: err}
+	}
+	if err != nil && !errors.Is(err, store.ErrDeleted) && !errors.Is(err, store.ErrExpired) {
+		return models.LinkStatsResponse{}, fmt.Errorf("cannot load URL: %w", err)
+	}
+
+	linkStats, err := s.store.LoadStats(ctx, id)
+	if err != nil {
+		return models.LinkStatsResponse{}, fmt.Errorf("cannot load stats: %w", err)
+	}
+
+	resp := models.LinkStatsResponse{
+		ShortURL: s.shortURL(id),
+		Total:    linkStats.Total,
+		Daily:    make([]models.DailyVisits, 0, len(linkStats.Daily)),
+	}
+	for _, daily := range linkStats.Daily {
+		resp.Daily = append(resp.Daily, models.DailyVisits{
+			Date:   daily.Day.Format("2006-01-02"),
+			Visits: daily.Count,
+		})
+	}
+	return resp, nil
+}
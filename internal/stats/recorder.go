@@ -0,0 +1,128 @@
+// Package stats records short link visits off the redirect path
+package stats
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/store"
+)
+
+// flushTimeout limits a single write of buffered visits
+const flushTimeout = 5 * time.Second
+
+// Saver persists batches of visits
+type Saver interface {
+	SaveVisits(ctx context.Context, visits []store.Visit) error
+}
+
+// Recorder buffers visits and writes them in batches by background goroutine.
+// Visits are dropped rather than slowing redirects down when buffer is full.
+type Recorder struct {
+	saver         Saver
+	visits        chan store.Visit
+	batchSize     int
+	flushInterval time.Duration
+	dropped       uint64
+	logger        *zap.Logger
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewRecorder starts recorder which flushes every flushInterval or once batchSize visits are buffered,
+// failed flushes are reported to logger
+func NewRecorder(saver Saver, bufferSize, batchSize int, flushInterval time.Duration, logger *zap.Logger) *Recorder {
+	r := &Recorder{
+		saver:         saver,
+		visits:        make(chan store.Visit, bufferSize),
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		logger:        logger,
+		done:          make(chan struct{}),
+	}
+
+	r.wg.Add(1)
+	go r.run()
+
+	return r
+}
+
+// Record enqueues visit without blocking, it reports false if visit has been dropped
+func (r *Recorder) Record(v store.Visit) bool {
+	select {
+	case r.visits <- v:
+		return true
+	default:
+		atomic.AddUint64(&r.dropped, 1)
+		return false
+	}
+}
+
+// Dropped returns number of visits lost due to full buffer
+func (r *Recorder) Dropped() uint64 {
+	return atomic.LoadUint64(&r.dropped)
+}
+
+// Close flushes buffered visits and stops recorder
+func (r *Recorder) Close() error {
+	close(r.done)
+	r.wg.Wait()
+	return nil
+}
+
+func (r *Recorder) run() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]store.Visit, 0, r.batchSize)
+	for {
+		select {
+		case v := <-r.visits:
+			batch = append(batch, v)
+			if len(batch) < r.batchSize {
+				continue
+			}
+		case <-ticker.C:
+		case <-r.done:
+			// drain visits recorded before Close, nobody else reads the channel
+			for len(r.visits) > 0 {
+				batch = append(batch, <-r.visits)
+			}
+			r.flush(batch)
+			return
+		}
+		batch = r.flush(batch)
+	}
+}
+
+// flush returns emptied batch to be reused
+func (r *Recorder) flush(batch []store.Visit) []store.Visit {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+
+	if err := r.saver.SaveVisits(ctx, batch); err != nil {
+		r.logger.Error("cannot save visits", zap.Int("count", len(batch)), zap.Error(err))
+	}
+	return batch[:0]
+}
+
+// HashIP returns keyed hash of client IP, so visitors can be told apart without storing addresses
+func HashIP(key []byte, ip string) string {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(ip))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
@@ -0,0 +1,78 @@
+package stats
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/store"
+)
+
+type saverMock struct {
+	mu      sync.Mutex
+	batches [][]store.Visit
+}
+
+func (m *saverMock) SaveVisits(_ context.Context, visits []store.Visit) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.batches = append(m.batches, append([]store.Visit(nil), visits...))
+	return nil
+}
+
+func (m *saverMock) total() (n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, b := range m.batches {
+		n += len(b)
+	}
+	return n
+}
+
+func TestRecorder(t *testing.T) {
+	t.Run("batches", func(t *testing.T) {
+		saver := new(saverMock)
+		r := NewRecorder(saver, 100, 10, time.Hour, zap.NewNop())
+
+		for i := 0; i < 25; i++ {
+			require.True(t, r.Record(store.Visit{ID: "a"}))
+		}
+		require.Eventually(t, func() bool { return saver.total() == 20 }, time.Second, time.Millisecond)
+
+		// the rest is flushed on close
+		require.NoError(t, r.Close())
+		assert.Equal(t, 25, saver.total())
+		assert.Len(t, saver.batches, 3)
+	})
+
+	t.Run("interval", func(t *testing.T) {
+		saver := new(saverMock)
+		r := NewRecorder(saver, 100, 10, 10*time.Millisecond, zap.NewNop())
+		defer r.Close()
+
+		r.Record(store.Visit{ID: "a"})
+		assert.Eventually(t, func() bool { return saver.total() == 1 }, time.Second, time.Millisecond)
+	})
+
+	t.Run("full_buffer", func(t *testing.T) {
+		// recorder goroutine is not started to keep buffer full
+		r := &Recorder{visits: make(chan store.Visit, 1)}
+
+		assert.True(t, r.Record(store.Visit{ID: "a"}))
+		assert.False(t, r.Record(store.Visit{ID: "b"}))
+		assert.Equal(t, uint64(1), r.Dropped())
+	})
+}
+
+func TestHashIP(t *testing.T) {
+	a := HashIP([]byte("key"), "127.0.0.1")
+	assert.Len(t, a, 32)
+	assert.Equal(t, a, HashIP([]byte("key"), "127.0.0.1"))
+	assert.NotEqual(t, a, HashIP([]byte("other"), "127.0.0.1"))
+	assert.NotContains(t, a, "127")
+}
@@ -0,0 +1,150 @@
+package store
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// APIKey is a named bearer token of user, only hash of the token is stored
+type APIKey struct {
+	ID   string
+	UID  uuid.UUID
+	Name string
+	// Hash is a hex encoded SHA-256 of the token
+	Hash      string
+	CreatedAt time.Time
+	// RevokedAt is zero for keys still in use
+	RevokedAt time.Time
+}
+
+// apiKeyMap indexes API keys by token hash and by ID
+type apiKeyMap struct {
+	mu     sync.RWMutex
+	byHash map[string]*APIKey
+	byID   map[string]*APIKey
+}
+
+func newAPIKeyMap() *apiKeyMap {
+	return &apiKeyMap{
+		byHash: make(map[string]*APIKey),
+		byID:   make(map[string]*APIKey),
+	}
+}
+
+// taken reports whether ID or hash of key is already used
+func (m *apiKeyMap) taken(key APIKey) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, idTaken := m.byID[key.ID]
+	_, hashTaken := m.byHash[key.Hash]
+	return idTaken || hashTaken
+}
+
+func (m *apiKeyMap) put(key APIKey) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.byID[key.ID] = &key
+	m.byHash[key.Hash] = &key
+}
+
+// putIfAbsent stores key only if neither its ID nor hash are taken
+func (m *apiKeyMap) putIfAbsent(key APIKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, idTaken := m.byID[key.ID]
+	_, hashTaken := m.byHash[key.Hash]
+	if idTaken || hashTaken {
+		return ErrConflict
+	}
+	m.byID[key.ID] = &key
+	m.byHash[key.Hash] = &key
+	return nil
+}
+
+func (m *apiKeyMap) load(hash string) (APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	key, ok := m.byHash[hash]
+	if !ok {
+		return APIKey{}, ErrNotFound
+	}
+	if !key.RevokedAt.IsZero() {
+		return *key, ErrDeleted
+	}
+	return *key, nil
+}
+
+// owned reports whether user has key with given ID which is not revoked yet
+func (m *apiKeyMap) owned(uid uuid.UUID, id string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	key, ok := m.byID[id]
+	return ok && key.UID == uid && key.RevokedAt.IsZero()
+}
+
+// list returns keys of user in creation order without revoked ones
+func (m *apiKeyMap) list(uid uuid.UUID) []APIKey {
+	m.mu.RLock()
+	var keys []APIKey
+	for _, key := range m.byID {
+		if key.UID == uid && key.RevokedAt.IsZero() {
+			keys = append(keys, *key)
+		}
+	}
+	m.mu.RUnlock()
+
+	sortAPIKeys(keys)
+	return keys
+}
+
+func (m *apiKeyMap) revoke(id string, at time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if key, ok := m.byID[id]; ok && key.RevokedAt.IsZero() {
+		key.RevokedAt = at
+	}
+}
+
+// revokeOwned revokes key only if it is owned by user and not revoked yet
+func (m *apiKeyMap) revokeOwned(uid uuid.UUID, id string, at time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, ok := m.byID[id]
+	if !ok || key.UID != uid || !key.RevokedAt.IsZero() {
+		return false
+	}
+	key.RevokedAt = at
+	return true
+}
+
+// all returns every key including revoked ones
+func (m *apiKeyMap) all() []APIKey {
+	m.mu.RLock()
+	keys := make([]APIKey, 0, len(m.byID))
+	for _, key := range m.byID {
+		keys = append(keys, *key)
+	}
+	m.mu.RUnlock()
+
+	sortAPIKeys(keys)
+	return keys
+}
+
+func sortAPIKeys(keys []APIKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+		}
+		return keys[i].ID < keys[j].ID
+	})
+}
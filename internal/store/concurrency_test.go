@@ -0,0 +1,171 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConcurrentAccess(t *testing.T) {
+	testCases := []struct {
+		name     string
+		newStore func(t *testing.T) AuthStore
+	}{
+		{
+			name: "in_memory",
+			newStore: func(t *testing.T) AuthStore {
+				return NewInMemory()
+			},
+		},
+		{
+			name: "file",
+			newStore: func(t *testing.T) AuthStore {
+				fs, err := NewFileStore(filepath.Join(t.TempDir(), "store.log"), WithMaxLogSize(4<<10))
+				require.NoError(t, err)
+				return fs
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			storage := tc.newStore(t)
+			defer storage.Close()
+
+			stressStore(t, storage)
+		})
+	}
+}
+
+// stressStore hammers all mutating and reading methods in parallel
+// and checks that every allocated ID is unique and resolvable
+func stressStore(t *testing.T, storage AuthStore) {
+	const workers = 16
+	const iterations = 50
+
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	remember := func(ids ...string) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, id := range ids {
+			assert.False(t, seen[id], "duplicate id %s", id)
+			seen[id] = true
+		}
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			uid := uuid.Must(uuid.NewV4())
+
+			for i := 0; i < iterations; i++ {
+				newURL := func(n int) *url.URL {
+					u, _ := url.Parse(fmt.Sprintf("https://praktikum.yandex.ru/%d/%d/%d", w, i, n))
+					return u
+				}
+				u := newURL(0)
+
+				id, err := storage.Save(ctx, u)
+				if !assert.NoError(t, err) {
+					return
+				}
+				remember(id)
+
+				loaded, err := storage.Load(ctx, id)
+				if assert.NoError(t, err) {
+					assert.Equal(t, u.String(), loaded.String())
+				}
+
+				ids, err := storage.SaveUserBatch(ctx, uid, []*url.URL{newURL(1), newURL(2), newURL(3)})
+				if !assert.NoError(t, err) {
+					return
+				}
+				remember(ids...)
+
+				err = storage.DeleteUsers(ctx, uid, ids[0])
+				assert.NoError(t, err)
+
+				_, err = storage.Load(ctx, ids[0])
+				assert.ErrorIs(t, err, ErrDeleted, fmt.Sprintf("worker %d", w))
+
+				_, err = storage.Load(ctx, ids[1])
+				assert.NoError(t, err)
+
+				_, err = storage.LoadUsers(ctx, uid)
+				assert.NoError(t, err)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	assert.Len(t, seen, workers*iterations*4)
+}
+
+// TestInMemory_linkBatchConcurrentSave checks that failed batch never lends its IDs to concurrent saves
+func TestInMemory_linkBatchConcurrentSave(t *testing.T) {
+	// batch is large enough to be preempted midway even on single CPU
+	const rounds = 3
+	const size = 20000
+
+	ctx := context.Background()
+	storage := NewInMemory()
+	defer storage.Close()
+
+	taken, _ := url.Parse("https://praktikum.yandex.ru/taken")
+	_, err := storage.SaveLink(ctx, Link{URL: taken, Alias: "taken"})
+	require.NoError(t, err)
+
+	for i := 0; i < rounds; i++ {
+		links := make([]Link, 0, size+1)
+		for n := 0; n < size; n++ {
+			u, _ := url.Parse(fmt.Sprintf("https://praktikum.yandex.ru/%d/%d", i, n))
+			links = append(links, Link{URL: u})
+		}
+		links = append(links, Link{URL: taken, Alias: "taken"})
+
+		start := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			b, err := storage.BeginLinks(ctx)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer b.Rollback()
+			_, err = b.Save(ctx, links)
+			assert.ErrorIs(t, err, ErrConflict)
+		}()
+
+		// links are saved from the end, so saves meet the batch in the middle
+		ids := make([]string, size)
+		close(start)
+		for n := size - 1; n >= 0; n-- {
+			id, err := storage.SaveLink(ctx, links[n])
+			if err != nil {
+				require.ErrorIs(t, err, ErrConflict)
+			}
+			ids[n] = id
+		}
+		wg.Wait()
+
+		for n, id := range ids {
+			got, err := storage.Load(ctx, id)
+			require.NoError(t, err, "round %d, link %d", i, n)
+			assert.Equal(t, links[n].URL, got)
+		}
+	}
+}
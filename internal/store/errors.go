@@ -2,9 +2,27 @@ package store
 
 import (
 	"errors"
+	"fmt"
 )
 
 var (
 	ErrNotFound = errors.New("not found")
 	ErrConflict = errors.New("conflict")
+	ErrExpired  = errors.New("record expired")
+
+	ErrIDCollision = errors.New("cannot allocate unique id")
 )
+
+// LinkError points to link which failed the whole batch
+type LinkError struct {
+	Index int
+	Err   error
+}
+
+func (e *LinkError) Error() string {
+	return fmt.Sprintf("link %d: %s", e.Index, e.Err)
+}
+
+func (e *LinkError) Unwrap() error {
+	return e.Err
+}
@@ -2,169 +2,545 @@ package store
 
 import (
 	"context"
-	"encoding/gob"
 	"errors"
 	"fmt"
 	"net/url"
-	"os"
+	"sync"
+	"time"
 
 	"github.com/gofrs/uuid"
+	"go.uber.org/zap"
 )
 
-func init() {
-	gob.Register(gobStore{})
-}
-
 var _ Store = (*FileStore)(nil)
 var _ AuthStore = (*FileStore)(nil)
 
-type gobStore struct {
-	Hot     map[string]*url.URL
-	UserHot map[string]map[string]*url.URL
-}
-
+// FileStore keeps state in memory and persists every mutation
+// to an append-only log which is replayed on startup.
+// Log is periodically compacted into snapshot by background goroutine.
 type FileStore struct {
-	store   *gobStore
-	enc     *gob.Encoder
-	persist *os.File
+	state *memState
+
+	// mu serializes log writes together with state updates,
+	// so snapshot never misses records already appended to log
+	mu           sync.Mutex
+	log          *recordLog
+	snapshotPath string
+	maxLogSize   int64
+
+	visits *visitJournal
+
+	// compactMu serializes compactions, as each of them drops records written before it started
+	compactMu sync.Mutex
+
+	logger  *zap.Logger
+	compact chan struct{}
+	done    chan struct{}
+	wg      sync.WaitGroup
 }
 
-// NewFileStore create new NewFileStore instance
-func NewFileStore(filepath string) (*FileStore, error) {
-	fd, err := os.OpenFile(filepath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
-	if err != nil {
-		return nil, fmt.Errorf("cannot open file at path %s: %w", filepath, err)
+// NewFileStore create new NewFileStore instance restoring previously saved state
+func NewFileStore(filepath string, opts ...Option) (*FileStore, error) {
+	o := newOptions(opts)
+
+	state := newMemState(o.idGenerator)
+
+	snapshotPath := filepath + ".snapshot"
+	if err := loadSnapshot(snapshotPath, state.apply, o.logger); err != nil {
+		return nil, fmt.Errorf("cannot restore state from snapshot: %w", err)
 	}
 
-	gs := gobStore{
-		Hot:     make(map[string]*url.URL),
-		UserHot: make(map[string]map[string]*url.URL),
+	rl, err := openRecordLog(filepath)
+	if err != nil {
+		return nil, err
+	}
+	if err := rl.replay(state.apply, o.logger); err != nil {
+		_ = rl.close()
+		return nil, fmt.Errorf("cannot restore state from log: %w", err)
 	}
 
-	dec := gob.NewDecoder(fd)
-	if err := dec.Decode(&gs); err != nil {
-		// truncate bad file for reuse
-		if err := fd.Truncate(0); err != nil {
-			return nil, fmt.Errorf("cannot truncate broken storage file: %w", err)
+	visits, err := openVisitJournal(filepath + ".visits")
+	if err != nil {
+		_ = rl.close()
+		return nil, err
+	}
+	err = visits.replay(state.visitEpoch, func(e visitEntry) {
+		if e.Reset {
+			state.visits.reset(e.ID)
+			return
 		}
+		state.visits.add(e.Visit)
+	}, o.logger)
+	if err != nil {
+		_ = rl.close()
+		_ = visits.close()
+		return nil, fmt.Errorf("cannot restore visits: %w", err)
+	}
+
+	f := &FileStore{
+		state:        state,
+		log:          rl,
+		visits:       visits,
+		snapshotPath: snapshotPath,
+		maxLogSize:   o.maxLogSize,
+		logger:       o.logger,
+		compact:      make(chan struct{}, 1),
+		done:         make(chan struct{}),
 	}
 
-	return &FileStore{
-		store:   &gs,
-		enc:     gob.NewEncoder(fd),
-		persist: fd,
-	}, nil
+	f.wg.Add(1)
+	go f.runCompactor(o.snapshotInterval)
+
+	return f, nil
 }
 
 func (f *FileStore) Save(_ context.Context, u *url.URL) (id string, err error) {
-	id = fmt.Sprintf("%x", len(f.store.Hot))
-	f.store.Hot[id] = u
-	return id, f.flush()
+	return f.saveOne("", u)
 }
 
 func (f *FileStore) SaveBatch(_ context.Context, urls []*url.URL) (ids []string, err error) {
-	for _, u := range urls {
-		id := fmt.Sprintf("%x", len(f.store.Hot))
-		f.store.Hot[id] = u
-		ids = append(ids, id)
+	ids, _, err = f.save("", urls)
+	return ids, err
+}
+
+func (f *FileStore) Load(_ context.Context, id string) (u *url.URL, err error) {
+	return f.state.load(id)
+}
+
+func (f *FileStore) SaveUser(_ context.Context, uid uuid.UUID, u *url.URL) (id string, err error) {
+	return f.saveOne(uid.String(), u)
+}
+
+func (f *FileStore) SaveUserBatch(_ context.Context, uid uuid.UUID, urls []*url.URL) (ids []string, err error) {
+	ids, _, err = f.save(uid.String(), urls)
+	return ids, err
+}
+
+func (f *FileStore) LoadUser(_ context.Context, uid uuid.UUID, id string) (u *url.URL, err error) {
+	return f.state.loadUser(uid.String(), id)
+}
+
+func (f *FileStore) LoadUsers(_ context.Context, uid uuid.UUID) (urls map[string]*url.URL, err error) {
+	return f.state.list(uid.String()), nil
+}
+
+func (f *FileStore) SaveLink(_ context.Context, link Link) (id string, err error) {
+	return f.saveLink("", link)
+}
+
+func (f *FileStore) SaveUserLink(_ context.Context, uid uuid.UUID, link Link) (id string, err error) {
+	return f.saveLink(uid.String(), link)
+}
+
+func (f *FileStore) SaveLinks(_ context.Context, links []Link) (results []SaveResult, err error) {
+	return f.saveLinks("", links), nil
+}
+
+func (f *FileStore) SaveUserLinks(_ context.Context, uid uuid.UUID, links []Link) (results []SaveResult, err error) {
+	return f.saveLinks(uid.String(), links), nil
+}
+
+func (f *FileStore) BeginLinks(_ context.Context) (LinkBatch, error) {
+	return &fileBatch{memBatch: f.state.newBatch(""), f: f}, nil
+}
+
+func (f *FileStore) BeginUserLinks(_ context.Context, uid uuid.UUID) (LinkBatch, error) {
+	return &fileBatch{memBatch: f.state.newBatch(uid.String()), f: f}, nil
+}
+
+func (f *FileStore) DeleteUsers(ctx context.Context, uid uuid.UUID, ids ...string) error {
+	return f.DeleteBatch(ctx, []DeleteRequest{{UID: uid, IDs: ids}})
+}
+
+// DeleteBatch writes deletions of all users with a single log append
+func (f *FileStore) DeleteBatch(_ context.Context, reqs []DeleteRequest) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var recs []logRecord
+	seen := make(map[string]bool)
+	for _, req := range reqs {
+		userID := req.UID.String()
+		for _, id := range req.IDs {
+			if !seen[id] && f.state.owns(userID, id) {
+				seen[id] = true
+				recs = append(recs, logRecord{Op: opDelete, ID: id, UID: userID})
+			}
+		}
+	}
+	if len(recs) == 0 {
+		return nil
 	}
-	if len(ids) != len(urls) {
-		return nil, errors.New("not all URLs have been saved")
+	if err := f.appendLog(recs); err != nil {
+		return err
+	}
+
+	for _, rec := range recs {
+		f.state.remove(rec.ID)
 	}
-	return ids, f.flush()
+	return nil
 }
 
-func (f *FileStore) Load(_ context.Context, id string) (u *url.URL, err error) {
-	u, ok := f.store.Hot[id]
-	if !ok {
-		return nil, ErrNotFound
+func (f *FileStore) PurgeExpired(_ context.Context, before time.Time) (n int, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var recs []logRecord
+	for _, id := range f.state.expiredBefore(before) {
+		recs = append(recs, logRecord{Op: opPurge, ID: id})
+	}
+	if len(recs) == 0 {
+		return 0, nil
 	}
-	if u == nil {
-		return nil, ErrDeleted
+	if err := f.appendLog(recs); err != nil {
+		return 0, err
 	}
-	return u, nil
+
+	resets := make([]visitEntry, 0, len(recs))
+	for _, rec := range recs {
+		f.state.purge(rec.ID)
+		resets = append(resets, visitEntry{Visit: Visit{ID: rec.ID}, Reset: true})
+	}
+	if _, err := f.visits.append(nil, resets...); err != nil {
+		return len(recs), err
+	}
+	return len(recs), nil
 }
 
-func (f *FileStore) SaveUser(ctx context.Context, uid uuid.UUID, u *url.URL) (id string, err error) {
-	id, err = f.Save(ctx, u)
+func (f *FileStore) SaveVisits(_ context.Context, visits []Visit) error {
+	entries := make([]visitEntry, 0, len(visits))
+	for _, v := range visits {
+		entries = append(entries, visitEntry{Visit: v})
+	}
+	size, err := f.visits.append(func() {
+		f.state.visits.add(visits...)
+	}, entries...)
 	if err != nil {
-		return "", fmt.Errorf("cannot save URL to shared store: %w", err)
+		return err
 	}
-	if _, ok := f.store.UserHot[uid.String()]; !ok {
-		f.store.UserHot[uid.String()] = make(map[string]*url.URL)
+	if f.maxLogSize > 0 && size >= f.maxLogSize {
+		select {
+		case f.compact <- struct{}{}:
+		default:
+		}
 	}
-	f.store.UserHot[uid.String()][id] = u
-	return id, f.flush()
+	return nil
 }
 
-func (f *FileStore) SaveUserBatch(ctx context.Context, uid uuid.UUID, urls []*url.URL) (ids []string, err error) {
-	ids, err = f.SaveBatch(ctx, urls)
-	if err != nil {
-		return nil, fmt.Errorf("cannot save URL to shared store: %w", err)
+func (f *FileStore) LoadStats(_ context.Context, id string) (stats Stats, err error) {
+	return f.state.visits.stats(id), nil
+}
+
+func (f *FileStore) SaveAPIKey(_ context.Context, key APIKey) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.state.keys.taken(key) {
+		return ErrConflict
 	}
-	if _, ok := f.store.UserHot[uid.String()]; !ok {
-		f.store.UserHot[uid.String()] = make(map[string]*url.URL)
+	rec := logRecord{Op: opSaveKey, ID: key.ID, UID: key.UID.String(), Name: key.Name, Hash: key.Hash, At: key.CreatedAt}
+	if err := f.appendLog([]logRecord{rec}); err != nil {
+		return err
+	}
+	f.state.keys.put(key)
+	return nil
+}
+
+func (f *FileStore) LoadAPIKey(_ context.Context, hash string) (key APIKey, err error) {
+	return f.state.keys.load(hash)
+}
+
+func (f *FileStore) LoadAPIKeys(_ context.Context, uid uuid.UUID) (keys []APIKey, err error) {
+	return f.state.keys.list(uid), nil
+}
+
+func (f *FileStore) RevokeAPIKey(_ context.Context, uid uuid.UUID, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.state.keys.owned(uid, id) {
+		return ErrNotFound
 	}
-	for i, id := range ids {
-		f.store.UserHot[uid.String()][id] = urls[i]
+	rec := logRecord{Op: opRevokeKey, ID: id, UID: uid.String(), At: time.Now()}
+	if err := f.appendLog([]logRecord{rec}); err != nil {
+		return err
 	}
-	return ids, f.flush()
+	f.state.keys.revoke(rec.ID, rec.At)
+	return nil
+}
+
+// Close stops background compaction and snapshots latest state
+func (f *FileStore) Close() error {
+	close(f.done)
+	f.wg.Wait()
+
+	if err := f.Compact(); err != nil {
+		_ = f.visits.close()
+		_ = f.log.close()
+		return fmt.Errorf("cannot snapshot state: %w", err)
+	}
+	if err := f.visits.close(); err != nil {
+		_ = f.log.close()
+		return err
+	}
+	return f.log.close()
+}
+
+func (f *FileStore) Ping(_ context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.log.fd.Fd() == ^(uintptr(0)) {
+		return errors.New("underlying file has been closed")
+	}
+	return nil
 }
 
-func (f *FileStore) LoadUser(ctx context.Context, uid uuid.UUID, id string) (u *url.URL, err error) {
-	urls, err := f.LoadUsers(ctx, uid)
+func (f *FileStore) saveOne(userID string, u *url.URL) (id string, err error) {
+	ids, created, err := f.save(userID, []*url.URL{u})
 	if err != nil {
-		return nil, fmt.Errorf("cannot load user urls: %w", err)
+		return "", err
 	}
-	u, ok := urls[id]
-	if !ok {
-		return nil, ErrNotFound
+	if !created[0] {
+		return ids[0], ErrConflict
 	}
-	if u == nil {
-		return nil, ErrDeleted
+	return ids[0], nil
+}
+
+// saveLinks saves links without alias or expiry with a single log write
+func (f *FileStore) saveLinks(userID string, links []Link) []SaveResult {
+	results := make([]SaveResult, len(links))
+	var urls []*url.URL
+	var plain []int
+	for n, link := range links {
+		if link.Alias != "" || !link.ExpiresAt.IsZero() {
+			results[n].ID, results[n].Err = f.saveLink(userID, link)
+			continue
+		}
+		urls = append(urls, link.URL)
+		plain = append(plain, n)
 	}
-	return u, nil
+	if len(urls) == 0 {
+		return results
+	}
+
+	ids, created, err := f.save(userID, urls)
+	for k, n := range plain {
+		switch {
+		case err != nil:
+			results[n].Err = err
+		case !created[k]:
+			results[n] = SaveResult{ID: ids[k], Err: ErrConflict}
+		default:
+			results[n].ID = ids[k]
+		}
+	}
+	return results
 }
 
-func (f *FileStore) LoadUsers(_ context.Context, uid uuid.UUID) (urls map[string]*url.URL, err error) {
-	urls, ok := f.store.UserHot[uid.String()]
-	if !ok {
-		return nil, ErrNotFound
+func (f *FileStore) saveLink(userID string, link Link) (id string, err error) {
+	if link.Alias == "" && link.ExpiresAt.IsZero() {
+		return f.saveOne(userID, link.URL)
 	}
-	res := make(map[string]*url.URL)
-	for k, v := range urls {
-		if v != nil {
-			res[k] = v
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rec := logRecord{Op: opSaveAlias, ID: link.Alias, UID: userID, URL: link.URL.String(), ExpiresAt: link.ExpiresAt}
+	if link.Alias != "" {
+		if _, ok := f.state.urls.get(link.Alias); ok {
+			return link.Alias, ErrConflict
+		}
+	} else {
+		// expiring link gets its own ID even if URL is already stored
+		rec.Op = opSave
+		rec.ID, rec.Seq, err = f.state.nextID(func(id string) bool {
+			_, ok := f.state.urls.get(id)
+			return ok
+		})
+		if err != nil {
+			return "", err
 		}
+		rec.HasSeq = true
+	}
+
+	if err := f.appendLog([]logRecord{rec}); err != nil {
+		return "", err
+	}
+	if err := f.state.apply(rec); err != nil {
+		return "", err
 	}
-	return res, nil
+	return rec.ID, nil
 }
 
-func (f *FileStore) DeleteUsers(_ context.Context, uid uuid.UUID, ids ...string) error {
-	for _, id := range ids {
-		userID := uid.String()
-		if _, ok := f.store.UserHot[userID]; ok {
-			f.store.Hot[id] = nil
-			f.store.UserHot[userID][id] = nil
+// fileBatch reserves IDs in memory and writes records of all links with a single log append on commit
+type fileBatch struct {
+	*memBatch
+	f *FileStore
+}
+
+// Save reserves IDs under f.mu, as other writes check IDs are free before they apply records
+func (b *fileBatch) Save(ctx context.Context, links []Link) (ids []string, err error) {
+	b.f.mu.Lock()
+	defer b.f.mu.Unlock()
+	return b.memBatch.Save(ctx, links)
+}
+
+func (b *fileBatch) Commit(_ context.Context) error {
+	b.f.mu.Lock()
+	defer b.f.mu.Unlock()
+
+	if recs := b.logRecords(); len(recs) > 0 {
+		if err := b.f.appendLog(recs); err != nil {
+			return err
 		}
 	}
-	return f.flush()
+	b.commit()
+	return nil
 }
 
-func (f *FileStore) Close() error {
-	if err := f.flush(); err != nil {
-		return fmt.Errorf("cannot flush data to file: %w", err)
+// save writes records ahead of applying them to in-memory state.
+// Already stored URLs are not written again, their IDs are reused,
+// created tells which of URLs got new ID.
+func (f *FileStore) save(userID string, urls []*url.URL) (ids []string, created []bool, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	known := make(map[string]string, len(urls))
+	pending := make(map[string]bool)
+	taken := func(id string) bool {
+		_, ok := f.state.urls.get(id)
+		return ok || pending[id]
 	}
-	return f.persist.Close()
+
+	var recs []logRecord
+	for _, u := range urls {
+		rawURL := u.String()
+		id, ok := known[rawURL]
+		if !ok {
+			id, ok = f.state.index.get(rawURL)
+		}
+		if !ok {
+			var seq uint64
+			id, seq, err = f.state.nextID(taken)
+			if err != nil {
+				return nil, nil, err
+			}
+			pending[id] = true
+			recs = append(recs, logRecord{Op: opSave, ID: id, UID: userID, URL: rawURL, Seq: seq, HasSeq: true})
+		}
+		known[rawURL] = id
+		ids = append(ids, id)
+		created = append(created, !ok)
+	}
+
+	if len(recs) == 0 {
+		return ids, created, nil
+	}
+	if err := f.appendLog(recs); err != nil {
+		return nil, nil, err
+	}
+
+	for _, rec := range recs {
+		if err := f.state.apply(rec); err != nil {
+			return nil, nil, err
+		}
+	}
+	return ids, created, nil
 }
 
-func (f *FileStore) Ping(_ context.Context) error {
-	if f.persist.Fd() == ^(uintptr(0)) {
-		return errors.New("underlying file has been closed")
+// Compact writes current state to snapshot and drops snapshotted records of both the log and visits journal.
+// State is captured under locks, but snapshot is written without them, so writes are not stalled meanwhile.
+func (f *FileStore) Compact() error {
+	f.compactMu.Lock()
+	defer f.compactMu.Unlock()
+
+	snap, ok := f.capture()
+	if !ok {
+		return nil
+	}
+	return f.persist(snap)
+}
+
+// capturedState is a state along with sizes of the log and visits journal it includes
+type capturedState struct {
+	recs       []logRecord
+	logMark    int64
+	visitsMark int64
+	epoch      uint64
+}
+
+// capture returns records of current state, ok is false if there is nothing to compact
+func (f *FileStore) capture() (snap capturedState, ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.visits.mu.Lock()
+	defer f.visits.mu.Unlock()
+
+	if f.log.size == 0 && f.visits.size == f.visits.base {
+		return capturedState{}, false
+	}
+	f.state.visitEpoch++
+	return capturedState{
+		recs:       f.state.records(),
+		logMark:    f.log.size,
+		visitsMark: f.visits.size,
+		epoch:      f.state.visitEpoch,
+	}, true
+}
+
+// persist writes captured state to snapshot and keeps only records written after capture
+func (f *FileStore) persist(snap capturedState) error {
+	if err := writeSnapshot(f.snapshotPath, snap.recs); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.visits.mu.Lock()
+	defer f.visits.mu.Unlock()
+
+	// records left in log after crash right here are idempotent to replay,
+	// visits left in journal are dropped as it belongs to the previous epoch
+	if err := f.log.dropHead(snap.logMark); err != nil {
+		return err
+	}
+	return f.visits.dropHead(snap.visitsMark, snap.epoch)
+}
+
+// appendLog must be called with f.mu held
+func (f *FileStore) appendLog(recs []logRecord) error {
+	if err := f.log.append(recs...); err != nil {
+		return fmt.Errorf("cannot append records to log: %w", err)
+	}
+	if f.maxLogSize > 0 && f.log.size >= f.maxLogSize {
+		select {
+		case f.compact <- struct{}{}:
+		default:
+		}
 	}
 	return nil
 }
 
-func (f *FileStore) flush() error {
-	return f.enc.Encode(f.store)
+func (f *FileStore) runCompactor(interval time.Duration) {
+	defer f.wg.Done()
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-f.done:
+			return
+		case <-tick:
+		case <-f.compact:
+		}
+		if err := f.Compact(); err != nil {
+			f.logger.Error("cannot compact log", zap.Error(err))
+		}
+	}
 }
@@ -0,0 +1,301 @@
+package store
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStore_reload(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "store.log")
+	uid := uuid.Must(uuid.NewV4())
+
+	u1, _ := url.Parse("https://praktikum.yandex.ru/")
+	u2, _ := url.Parse("https://yandex.ru/")
+	u3, _ := url.Parse("https://practicum.com/")
+
+	fs, err := NewFileStore(path)
+	require.NoError(t, err)
+
+	id1, err := fs.Save(ctx, u1)
+	require.NoError(t, err)
+	ids, err := fs.SaveUserBatch(ctx, uid, []*url.URL{u2, u3})
+	require.NoError(t, err)
+	require.NoError(t, fs.DeleteUsers(ctx, uid, ids[0]))
+	_, err = fs.SaveUserLink(ctx, uid, Link{URL: u1, Alias: "spring-sale"})
+	require.NoError(t, err)
+	require.NoError(t, fs.SaveVisits(ctx, []Visit{{ID: id1, At: time.Now()}, {ID: id1, At: time.Now()}}))
+	require.NoError(t, fs.SaveAPIKey(ctx, APIKey{ID: "k1", UID: uid, Name: "ci", Hash: "h1", CreatedAt: time.Now()}))
+	require.NoError(t, fs.SaveAPIKey(ctx, APIKey{ID: "k2", UID: uid, Name: "bot", Hash: "h2", CreatedAt: time.Now()}))
+	require.NoError(t, fs.RevokeAPIKey(ctx, uid, "k2"))
+	require.NoError(t, fs.Close())
+
+	fs, err = NewFileStore(path)
+	require.NoError(t, err)
+	defer fs.Close()
+
+	u, err := fs.Load(ctx, id1)
+	require.NoError(t, err)
+	assert.Equal(t, u1.String(), u.String())
+
+	_, err = fs.Load(ctx, ids[0])
+	assert.ErrorIs(t, err, ErrDeleted)
+
+	urls, err := fs.LoadUsers(ctx, uid)
+	require.NoError(t, err)
+	assert.Len(t, urls, 2)
+	assert.Equal(t, u3.String(), urls[ids[1]].String())
+	assert.Equal(t, u1.String(), urls["spring-sale"].String())
+
+	_, err = fs.SaveLink(ctx, Link{URL: u2, Alias: "spring-sale"})
+	assert.ErrorIs(t, err, ErrConflict)
+
+	stats, err := fs.LoadStats(ctx, id1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), stats.Total)
+
+	key, err := fs.LoadAPIKey(ctx, "h1")
+	require.NoError(t, err)
+	assert.Equal(t, uid, key.UID)
+	_, err = fs.LoadAPIKey(ctx, "h2")
+	assert.ErrorIs(t, err, ErrDeleted)
+
+	// ID allocation continues after restart
+	id, err := fs.Save(ctx, &url.URL{Scheme: "https", Host: "go.dev"})
+	require.NoError(t, err)
+	assert.Equal(t, "3", id)
+}
+
+func TestFileStore_corruptedTail(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "store.log")
+	u, _ := url.Parse("https://praktikum.yandex.ru/")
+
+	fs, err := NewFileStore(path)
+	require.NoError(t, err)
+	id, err := fs.Save(ctx, u)
+	require.NoError(t, err)
+	require.NoError(t, fs.Close())
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	validSize := info.Size()
+
+	// emulate crash in the middle of a write
+	fd, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0666)
+	require.NoError(t, err)
+	_, err = fd.Write([]byte{0, 0, 0, 42, 1, 2, 3, 4, 1, 'x'})
+	require.NoError(t, err)
+	require.NoError(t, fd.Close())
+
+	fs, err = NewFileStore(path)
+	require.NoError(t, err)
+
+	info, err = os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, validSize, info.Size())
+
+	loaded, err := fs.Load(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, u.String(), loaded.String())
+
+	next, err := fs.Save(ctx, &url.URL{Scheme: "https", Host: "go.dev"})
+	require.NoError(t, err)
+	require.NoError(t, fs.Close())
+
+	fs, err = NewFileStore(path)
+	require.NoError(t, err)
+	defer fs.Close()
+
+	_, err = fs.Load(ctx, next)
+	assert.NoError(t, err)
+}
+
+func TestFileStore_compact(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "store.log")
+	uid := uuid.Must(uuid.NewV4())
+	u, _ := url.Parse("https://praktikum.yandex.ru/")
+
+	fs, err := NewFileStore(path, WithSnapshotInterval(0), WithMaxLogSize(1))
+	require.NoError(t, err)
+
+	id1, err := fs.SaveUser(ctx, uid, u)
+	require.NoError(t, err)
+	require.NoError(t, fs.DeleteUsers(ctx, uid, id1))
+
+	// threshold hit triggers background compaction
+	require.Eventually(t, func() bool {
+		info, err := os.Stat(path)
+		return err == nil && info.Size() == 0
+	}, time.Second, 10*time.Millisecond)
+
+	_, err = os.Stat(path + ".snapshot")
+	require.NoError(t, err)
+
+	id2, err := fs.Save(ctx, &url.URL{Scheme: "https", Host: "go.dev"})
+	require.NoError(t, err)
+	require.NoError(t, fs.Close())
+
+	fs, err = NewFileStore(path)
+	require.NoError(t, err)
+	defer fs.Close()
+
+	_, err = fs.Load(ctx, id1)
+	assert.ErrorIs(t, err, ErrDeleted)
+
+	loaded, err := fs.Load(ctx, id2)
+	require.NoError(t, err)
+	assert.Equal(t, "https://go.dev", loaded.String())
+
+	id3, err := fs.Save(ctx, u)
+	require.NoError(t, err)
+	assert.Equal(t, "2", id3)
+}
+
+func TestFileStore_expiry(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "store.log")
+	u, _ := url.Parse("https://praktikum.yandex.ru/")
+
+	fs, err := NewFileStore(path, WithSnapshotInterval(0))
+	require.NoError(t, err)
+
+	purged, err := fs.SaveLink(ctx, Link{URL: u, ExpiresAt: time.Now().Add(-2 * time.Hour)})
+	require.NoError(t, err)
+	expired, err := fs.SaveLink(ctx, Link{URL: u, Alias: "promo", ExpiresAt: time.Now().Add(-time.Minute)})
+	require.NoError(t, err)
+	n, err := fs.PurgeExpired(ctx, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	// both log replay and snapshot have to keep expiry
+	for _, compact := range []bool{false, true} {
+		if compact {
+			require.NoError(t, fs.Compact())
+		}
+		require.NoError(t, fs.log.close())
+		require.NoError(t, fs.visits.close())
+
+		fs, err = NewFileStore(path, WithSnapshotInterval(0))
+		require.NoError(t, err)
+
+		_, err = fs.Load(ctx, purged)
+		assert.ErrorIs(t, err, ErrNotFound)
+		_, err = fs.Load(ctx, expired)
+		assert.ErrorIs(t, err, ErrExpired)
+	}
+	require.NoError(t, fs.Close())
+}
+
+func TestFileStore_compactVisits(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "store.log")
+	u, _ := url.Parse("https://praktikum.yandex.ru/")
+	day := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	fs, err := NewFileStore(path, WithSnapshotInterval(0))
+	require.NoError(t, err)
+	id, err := fs.Save(ctx, u)
+	require.NoError(t, err)
+	require.NoError(t, fs.SaveVisits(ctx, []Visit{{ID: id, At: day}, {ID: id, At: day}, {ID: id, At: day.AddDate(0, 0, 1)}}))
+
+	journal, err := os.ReadFile(path + ".visits")
+	require.NoError(t, err)
+	require.NoError(t, fs.Compact())
+	compacted, err := os.ReadFile(path + ".visits")
+	require.NoError(t, err)
+	assert.Less(t, len(compacted), len(journal))
+
+	require.NoError(t, fs.SaveVisits(ctx, []Visit{{ID: id, At: day}}))
+	require.NoError(t, fs.log.close())
+	require.NoError(t, fs.visits.close())
+
+	// visits written since snapshot are replayed on top of it
+	fs, err = NewFileStore(path, WithSnapshotInterval(0))
+	require.NoError(t, err)
+	stats, err := fs.LoadStats(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), stats.Total)
+	require.Len(t, stats.Daily, 2)
+	assert.Equal(t, int64(3), stats.Daily[0].Count)
+	require.NoError(t, fs.Close())
+
+	// journal left by a crash right after snapshot is not counted twice
+	require.NoError(t, os.WriteFile(path+".visits", journal, 0666))
+	fs, err = NewFileStore(path, WithSnapshotInterval(0))
+	require.NoError(t, err)
+	stats, err = fs.LoadStats(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), stats.Total)
+	require.NoError(t, fs.SaveVisits(ctx, []Visit{{ID: id, At: day}}))
+	require.NoError(t, fs.Close())
+
+	fs, err = NewFileStore(path, WithSnapshotInterval(0))
+	require.NoError(t, err)
+	defer fs.Close()
+	stats, err = fs.LoadStats(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), stats.Total)
+}
+
+func TestFileStore_compactConcurrentWrites(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "store.log")
+	day := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	fs, err := NewFileStore(path, WithSnapshotInterval(0))
+	require.NoError(t, err)
+	uid := uuid.Must(uuid.NewV4())
+	before, err := fs.Save(ctx, &url.URL{Scheme: "https", Host: "before.dev"})
+	require.NoError(t, err)
+	gone, err := fs.SaveUser(ctx, uid, &url.URL{Scheme: "https", Host: "gone.dev"})
+	require.NoError(t, err)
+	require.NoError(t, fs.SaveVisits(ctx, []Visit{{ID: before, At: day}}))
+
+	snap, ok := fs.capture()
+	require.True(t, ok)
+
+	// writes between capture and snapshot replacement are neither blocked nor lost
+	during, err := fs.Save(ctx, &url.URL{Scheme: "https", Host: "during.dev"})
+	require.NoError(t, err)
+	require.NoError(t, fs.DeleteUsers(ctx, uid, gone))
+	require.NoError(t, fs.SaveVisits(ctx, []Visit{{ID: before, At: day}, {ID: during, At: day}}))
+	require.NoError(t, fs.persist(snap))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Positive(t, info.Size())
+
+	// crash leaves the log and journal tails to be replayed on top of snapshot
+	close(fs.done)
+	fs.wg.Wait()
+	require.NoError(t, fs.log.close())
+	require.NoError(t, fs.visits.close())
+
+	fs, err = NewFileStore(path, WithSnapshotInterval(0))
+	require.NoError(t, err)
+	defer fs.Close()
+
+	for host, id := range map[string]string{"before.dev": before, "during.dev": during} {
+		loaded, err := fs.Load(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, host, loaded.Host)
+	}
+	_, err = fs.Load(ctx, gone)
+	assert.ErrorIs(t, err, ErrDeleted)
+	stats, err := fs.LoadStats(ctx, before)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), stats.Total)
+	stats, err = fs.LoadStats(ctx, during)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), stats.Total)
+}
@@ -0,0 +1,354 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// frame layout: | payload length (uint32) | payload crc32 (uint32) | payload |
+const frameHeaderSize = 8
+
+// maxRecordSize guards replay from allocating huge buffers on garbage length
+const maxRecordSize = 1 << 20
+
+var errBadRecord = errors.New("bad log record")
+
+type logOp uint8
+
+const (
+	opSave logOp = iota + 1
+	opDelete
+	// opSequence restores ID sequence position
+	opSequence
+	// opSaveAlias stores URL under user chosen ID
+	opSaveAlias
+	// opPurge forgets expired record so its ID may be taken again
+	opPurge
+	// opSaveKey and opRevokeKey manage API keys, they carry no URL
+	opSaveKey
+	opRevokeKey
+	// opVisits holds visit count of ID on a day, it is written only to snapshot
+	opVisits
+	// opVisitEpoch tells which generation of visits journal follows snapshot
+	opVisitEpoch
+)
+
+// logRecord is a single mutation of the file store state
+type logRecord struct {
+	Op  logOp
+	ID  string
+	UID string
+	URL string
+	// Seq is a sequence number ID was generated from,
+	// records written before ID generators were introduced lack it
+	Seq    uint64
+	HasSeq bool
+	// ExpiresAt follows Seq if set, zero value means record never expires
+	ExpiresAt time.Time
+
+	// Name, Hash and At are used by API key records instead of URL fields
+	Name string
+	Hash string
+	At   time.Time
+
+	// Day and Count are used by visits records, Day is a number of UTC day since Unix epoch
+	Day   int64
+	Count int64
+}
+
+func (r logRecord) marshal(buf []byte) []byte {
+	if r.Op == opSaveKey || r.Op == opRevokeKey {
+		return r.marshalKey(buf)
+	}
+	if r.Op == opVisits {
+		return r.marshalVisits(buf)
+	}
+
+	buf = append(buf, byte(r.Op))
+	for _, s := range []string{r.ID, r.UID, r.URL} {
+		var lenBuf [binary.MaxVarintLen64]byte
+		n := binary.PutUvarint(lenBuf[:], uint64(len(s)))
+		buf = append(buf, lenBuf[:n]...)
+		buf = append(buf, s...)
+	}
+	if r.HasSeq || !r.ExpiresAt.IsZero() {
+		var seqBuf [binary.MaxVarintLen64]byte
+		n := binary.PutUvarint(seqBuf[:], r.Seq)
+		buf = append(buf, seqBuf[:n]...)
+	}
+	if !r.ExpiresAt.IsZero() {
+		var tsBuf [binary.MaxVarintLen64]byte
+		n := binary.PutVarint(tsBuf[:], r.ExpiresAt.UnixNano())
+		buf = append(buf, tsBuf[:n]...)
+	}
+	return buf
+}
+
+func (r *logRecord) unmarshal(payload []byte) error {
+	if len(payload) == 0 {
+		return errBadRecord
+	}
+	r.Op = logOp(payload[0])
+	if r.Op < opSave || r.Op > opVisitEpoch {
+		return errBadRecord
+	}
+	if r.Op == opSaveKey || r.Op == opRevokeKey {
+		return r.unmarshalKey(payload)
+	}
+	if r.Op == opVisits {
+		return r.unmarshalVisits(payload)
+	}
+
+	rd := bytes.NewReader(payload[1:])
+	for _, s := range []*string{&r.ID, &r.UID, &r.URL} {
+		l, err := binary.ReadUvarint(rd)
+		if err != nil || l > uint64(rd.Len()) {
+			return errBadRecord
+		}
+		b := make([]byte, l)
+		_, _ = rd.Read(b)
+		*s = string(b)
+	}
+	if rd.Len() == 0 {
+		return nil
+	}
+
+	seq, err := binary.ReadUvarint(rd)
+	if err != nil {
+		return errBadRecord
+	}
+	r.Seq, r.HasSeq = seq, true
+	if rd.Len() == 0 {
+		return nil
+	}
+
+	ts, err := binary.ReadVarint(rd)
+	if err != nil || rd.Len() != 0 {
+		return errBadRecord
+	}
+	r.ExpiresAt = time.Unix(0, ts)
+	return nil
+}
+
+// marshalKey encodes API key record as | op | ID | UID | Name | Hash | varint At |
+func (r logRecord) marshalKey(buf []byte) []byte {
+	buf = append(buf, byte(r.Op))
+	for _, s := range []string{r.ID, r.UID, r.Name, r.Hash} {
+		var lenBuf [binary.MaxVarintLen64]byte
+		n := binary.PutUvarint(lenBuf[:], uint64(len(s)))
+		buf = append(buf, lenBuf[:n]...)
+		buf = append(buf, s...)
+	}
+	var tsBuf [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(tsBuf[:], r.At.UnixNano())
+	return append(buf, tsBuf[:n]...)
+}
+
+func (r *logRecord) unmarshalKey(payload []byte) error {
+	rd := bytes.NewReader(payload[1:])
+	for _, s := range []*string{&r.ID, &r.UID, &r.Name, &r.Hash} {
+		l, err := binary.ReadUvarint(rd)
+		if err != nil || l > uint64(rd.Len()) {
+			return errBadRecord
+		}
+		b := make([]byte, l)
+		_, _ = rd.Read(b)
+		*s = string(b)
+	}
+
+	ts, err := binary.ReadVarint(rd)
+	if err != nil || rd.Len() != 0 {
+		return errBadRecord
+	}
+	r.At = time.Unix(0, ts)
+	return nil
+}
+
+// marshalVisits encodes visits record as | op | ID | varint Day | varint Count |
+func (r logRecord) marshalVisits(buf []byte) []byte {
+	buf = append(buf, byte(r.Op))
+	var lenBuf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lenBuf[:], uint64(len(r.ID)))
+	buf = append(buf, lenBuf[:n]...)
+	buf = append(buf, r.ID...)
+	for _, v := range []int64{r.Day, r.Count} {
+		var vBuf [binary.MaxVarintLen64]byte
+		n := binary.PutVarint(vBuf[:], v)
+		buf = append(buf, vBuf[:n]...)
+	}
+	return buf
+}
+
+func (r *logRecord) unmarshalVisits(payload []byte) error {
+	rd := bytes.NewReader(payload[1:])
+	l, err := binary.ReadUvarint(rd)
+	if err != nil || l > uint64(rd.Len()) {
+		return errBadRecord
+	}
+	b := make([]byte, l)
+	_, _ = rd.Read(b)
+	r.ID = string(b)
+
+	for _, v := range []*int64{&r.Day, &r.Count} {
+		if *v, err = binary.ReadVarint(rd); err != nil {
+			return errBadRecord
+		}
+	}
+	if rd.Len() != 0 {
+		return errBadRecord
+	}
+	return nil
+}
+
+// recordLog is an append-only file of checksummed records
+type recordLog struct {
+	fd   *os.File
+	size int64
+}
+
+func openRecordLog(filepath string) (*recordLog, error) {
+	fd, err := os.OpenFile(filepath, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open file at path %s: %w", filepath, err)
+	}
+	return &recordLog{fd: fd}, nil
+}
+
+// replay feeds every intact record to apply in write order.
+// Log is truncated right after the last intact record,
+// so partially written tail left by a crash is dropped.
+func (l *recordLog) replay(apply func(rec logRecord) error, logger *zap.Logger) error {
+	if _, err := l.fd.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("cannot rewind log: %w", err)
+	}
+
+	rd := bufio.NewReader(l.fd)
+	var offset int64
+	var header [frameHeaderSize]byte
+	for {
+		if _, err := io.ReadFull(rd, header[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				logger.Warn("dropping torn record header", zap.String("file", l.fd.Name()), zap.Int64("offset", offset))
+				break
+			}
+			return fmt.Errorf("cannot read record header: %w", err)
+		}
+
+		size := binary.BigEndian.Uint32(header[0:4])
+		sum := binary.BigEndian.Uint32(header[4:8])
+		if size > maxRecordSize {
+			logger.Warn("dropping corrupted tail: bad record size",
+				zap.String("file", l.fd.Name()), zap.Int64("offset", offset), zap.Uint32("size", size))
+			break
+		}
+
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(rd, payload); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				logger.Warn("dropping torn record", zap.String("file", l.fd.Name()), zap.Int64("offset", offset))
+				break
+			}
+			return fmt.Errorf("cannot read record payload: %w", err)
+		}
+
+		var rec logRecord
+		if crc32.ChecksumIEEE(payload) != sum || rec.unmarshal(payload) != nil {
+			logger.Warn("dropping corrupted tail", zap.String("file", l.fd.Name()), zap.Int64("offset", offset))
+			break
+		}
+		if err := apply(rec); err != nil {
+			return fmt.Errorf("cannot apply record at offset %d: %w", offset, err)
+		}
+		offset += frameHeaderSize + int64(size)
+	}
+
+	if err := l.fd.Truncate(offset); err != nil {
+		return fmt.Errorf("cannot truncate log at offset %d: %w", offset, err)
+	}
+	if _, err := l.fd.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("cannot seek to log end: %w", err)
+	}
+	l.size = offset
+	return nil
+}
+
+// append durably writes all records with a single write call
+func (l *recordLog) append(recs ...logRecord) error {
+	buf := encodeFrames(nil, recs...)
+	if _, err := l.fd.Write(buf); err != nil {
+		// drop partially written frames so next appends stay readable
+		if terr := l.fd.Truncate(l.size); terr == nil {
+			_, _ = l.fd.Seek(l.size, io.SeekStart)
+		}
+		return fmt.Errorf("cannot write records: %w", err)
+	}
+	l.size += int64(len(buf))
+	if err := l.fd.Sync(); err != nil {
+		return fmt.Errorf("cannot sync log: %w", err)
+	}
+	return nil
+}
+
+// reset drops all records, e.g. after they have been snapshotted
+func (l *recordLog) reset() error {
+	if err := l.fd.Truncate(0); err != nil {
+		return fmt.Errorf("cannot truncate log: %w", err)
+	}
+	if _, err := l.fd.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("cannot rewind log: %w", err)
+	}
+	l.size = 0
+	return l.fd.Sync()
+}
+
+// dropHead atomically replaces log with its records written after offset,
+// e.g. once records before offset have been snapshotted
+func (l *recordLog) dropHead(offset int64) error {
+	if offset == l.size {
+		return l.reset()
+	}
+
+	tail := make([]byte, l.size-offset)
+	if _, err := l.fd.ReadAt(tail, offset); err != nil {
+		return fmt.Errorf("cannot read log tail: %w", err)
+	}
+	fd, err := replaceFile(l.fd.Name(), tail)
+	if err != nil {
+		return fmt.Errorf("cannot replace log: %w", err)
+	}
+	_ = l.fd.Close()
+	l.fd, l.size = fd, int64(len(tail))
+	return nil
+}
+
+func (l *recordLog) close() error {
+	if err := l.fd.Sync(); err != nil {
+		return fmt.Errorf("cannot sync log: %w", err)
+	}
+	return l.fd.Close()
+}
+
+func encodeFrames(buf []byte, recs ...logRecord) []byte {
+	for _, rec := range recs {
+		start := len(buf)
+		buf = append(buf, make([]byte, frameHeaderSize)...)
+		buf = rec.marshal(buf)
+
+		payload := buf[start+frameHeaderSize:]
+		binary.BigEndian.PutUint32(buf[start:], uint32(len(payload)))
+		binary.BigEndian.PutUint32(buf[start+4:], crc32.ChecksumIEEE(payload))
+	}
+	return buf
+}
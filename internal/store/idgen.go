@@ -0,0 +1,159 @@
+package store
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+const base62Alphabet = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// maxIDAttempts limits retries when generated ID is already taken
+const maxIDAttempts = 16
+
+// IDGenerator produces short IDs for new records.
+// seq is unique for every call, generators may ignore it.
+// Stores check generated IDs for collisions and retry with next seq,
+// so generator may be switched at any time without breaking existing IDs.
+type IDGenerator interface {
+	Generate(seq uint64) (string, error)
+}
+
+type hexGenerator struct{}
+
+// NewHexGenerator returns generator of hex encoded sequential IDs
+func NewHexGenerator() IDGenerator {
+	return hexGenerator{}
+}
+
+func (hexGenerator) Generate(seq uint64) (string, error) {
+	return strconv.FormatUint(seq, 16), nil
+}
+
+// decimalGenerator reproduces IDs of serial primary key
+type decimalGenerator struct{}
+
+func (decimalGenerator) Generate(seq uint64) (string, error) {
+	return strconv.FormatUint(seq, 10), nil
+}
+
+type base62Generator struct{}
+
+// NewBase62Generator returns generator of base62 encoded sequential IDs
+func NewBase62Generator() IDGenerator {
+	return base62Generator{}
+}
+
+func (base62Generator) Generate(seq uint64) (string, error) {
+	return encodeBase(seq, base62Alphabet, 0), nil
+}
+
+type randomGenerator struct {
+	length int
+}
+
+// NewRandomGenerator returns generator of random base62 IDs of fixed length
+func NewRandomGenerator(length int) (IDGenerator, error) {
+	if length < 4 {
+		return nil, fmt.Errorf("random id length must be at least 4, got %d", length)
+	}
+	return randomGenerator{length: length}, nil
+}
+
+func (g randomGenerator) Generate(_ uint64) (string, error) {
+	id := make([]byte, 0, g.length)
+	buf := make([]byte, g.length)
+	for len(id) < g.length {
+		if _, err := rand.Read(buf); err != nil {
+			return "", fmt.Errorf("cannot read random bytes: %w", err)
+		}
+		for _, b := range buf {
+			// reject bytes which would skew distribution
+			if b >= 248 || len(id) == g.length {
+				continue
+			}
+			id = append(id, base62Alphabet[b%62])
+		}
+	}
+	return string(id), nil
+}
+
+// hashidsBits is a size of sequence space permuted by hashids generator
+const hashidsBits = 48
+
+type hashidsGenerator struct {
+	alphabet  string
+	mult      uint64
+	offset    uint64
+	minLength int
+}
+
+// NewHashidsGenerator returns generator of obfuscated sequential IDs.
+// Sequence is permuted by salt derived bijection and encoded
+// with salt shuffled alphabet, so IDs are unique but not enumerable.
+func NewHashidsGenerator(salt string, minLength int) IDGenerator {
+	alphabet := []byte(base62Alphabet)
+	consistentShuffle(alphabet, salt)
+
+	h := fnv64a(salt)
+	return hashidsGenerator{
+		alphabet:  string(alphabet),
+		mult:      (h & (1<<hashidsBits - 1)) | 1,
+		offset:    (h >> 16) & (1<<hashidsBits - 1),
+		minLength: minLength,
+	}
+}
+
+func (g hashidsGenerator) Generate(seq uint64) (string, error) {
+	const mask = 1<<hashidsBits - 1
+	if seq > mask {
+		return "", errors.New("sequence exceeds hashids space")
+	}
+	// multiplication by odd number modulo power of two is a bijection
+	n := (seq*g.mult + g.offset) & mask
+	return encodeBase(n, g.alphabet, g.minLength), nil
+}
+
+// encodeBase encodes n with given digits left padded with zero digit up to minLength
+func encodeBase(n uint64, alphabet string, minLength int) string {
+	base := uint64(len(alphabet))
+	var buf [64]byte
+	i := len(buf)
+	for {
+		i--
+		buf[i] = alphabet[n%base]
+		n /= base
+		if n == 0 {
+			break
+		}
+	}
+	for len(buf)-i < minLength && i > 0 {
+		i--
+		buf[i] = alphabet[0]
+	}
+	return string(buf[i:])
+}
+
+// consistentShuffle is a deterministic salt based shuffle taken from hashids
+func consistentShuffle(alphabet []byte, salt string) {
+	if salt == "" {
+		return
+	}
+	for i, v, p := len(alphabet)-1, 0, 0; i > 0; i-- {
+		v %= len(salt)
+		p += int(salt[v])
+		j := (int(salt[v]) + v + p) % i
+		alphabet[i], alphabet[j] = alphabet[j], alphabet[i]
+		v++
+	}
+}
+
+func fnv64a(s string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= 1099511628211
+	}
+	return h
+}
@@ -0,0 +1,134 @@
+package store
+
+import (
+	"context"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIDGenerators(t *testing.T) {
+	random, err := NewRandomGenerator(6)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name    string
+		gen     IDGenerator
+		pattern *regexp.Regexp
+	}{
+		{
+			name:    "hex",
+			gen:     NewHexGenerator(),
+			pattern: regexp.MustCompile(`^[0-9a-f]+$`),
+		},
+		{
+			name:    "base62",
+			gen:     NewBase62Generator(),
+			pattern: regexp.MustCompile(`^[0-9a-zA-Z]+$`),
+		},
+		{
+			name:    "random",
+			gen:     random,
+			pattern: regexp.MustCompile(`^[0-9a-zA-Z]{6}$`),
+		},
+		{
+			name:    "hashids",
+			gen:     NewHashidsGenerator("ololo", 5),
+			pattern: regexp.MustCompile(`^[0-9a-zA-Z]{5,}$`),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			seen := make(map[string]bool)
+			for seq := uint64(0); seq < 10000; seq++ {
+				id, err := tc.gen.Generate(seq)
+				require.NoError(t, err)
+				require.Regexp(t, tc.pattern, id)
+				require.False(t, seen[id], "duplicate id %s", id)
+				seen[id] = true
+			}
+		})
+	}
+
+	t.Run("base62_values", func(t *testing.T) {
+		for seq, expected := range map[uint64]string{0: "0", 61: "Z", 62: "10", 3843: "ZZ"} {
+			id, _ := NewBase62Generator().Generate(seq)
+			assert.Equal(t, expected, id)
+		}
+	})
+
+	t.Run("hashids_salt", func(t *testing.T) {
+		a, _ := NewHashidsGenerator("a", 0).Generate(1)
+		b, _ := NewHashidsGenerator("b", 0).Generate(1)
+		again, _ := NewHashidsGenerator("a", 0).Generate(1)
+		assert.NotEqual(t, a, b)
+		assert.Equal(t, a, again)
+	})
+}
+
+type stubGenerator []string
+
+func (g stubGenerator) Generate(seq uint64) (string, error) {
+	return g[int(seq)%len(g)], nil
+}
+
+func TestIDCollisionRetry(t *testing.T) {
+	ctx := context.Background()
+	storage := NewInMemory(WithIDGenerator(stubGenerator{"a", "a", "a", "b"}))
+
+	id, err := storage.Save(ctx, &url.URL{Scheme: "https", Host: "a.com"})
+	require.NoError(t, err)
+	assert.Equal(t, "a", id)
+
+	id, err = storage.Save(ctx, &url.URL{Scheme: "https", Host: "b.com"})
+	require.NoError(t, err)
+	assert.Equal(t, "b", id)
+
+	_, err = storage.Save(ctx, &url.URL{Scheme: "https", Host: "c.com"})
+	assert.ErrorIs(t, err, ErrIDCollision)
+}
+
+func TestFileStore_switchGenerator(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "store.log")
+
+	fs, err := NewFileStore(path)
+	require.NoError(t, err)
+
+	var legacy []string
+	for _, host := range []string{"a.com", "b.com", "c.com"} {
+		id, err := fs.Save(ctx, &url.URL{Scheme: "https", Host: host})
+		require.NoError(t, err)
+		legacy = append(legacy, id)
+	}
+	require.NoError(t, fs.Close())
+
+	// base62 generator yields the same IDs for first sequence numbers,
+	// so they have to be skipped rather than overwritten
+	fs, err = NewFileStore(path, WithIDGenerator(NewBase62Generator()))
+	require.NoError(t, err)
+
+	id, err := fs.Save(ctx, &url.URL{Scheme: "https", Host: "d.com"})
+	require.NoError(t, err)
+	assert.NotContains(t, legacy, id)
+	require.NoError(t, fs.Close())
+
+	fs, err = NewFileStore(path, WithIDGenerator(NewHashidsGenerator("salt", 6)))
+	require.NoError(t, err)
+	defer fs.Close()
+
+	for i, id := range legacy {
+		u, err := fs.Load(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a.com", "b.com", "c.com"}[i], u.Host)
+	}
+
+	hashed, err := fs.Save(ctx, &url.URL{Scheme: "https", Host: "e.com"})
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, len(hashed), 6)
+}
@@ -3,8 +3,8 @@ package store
 import (
 	"context"
 	"errors"
-	"fmt"
 	"net/url"
+	"time"
 
 	"github.com/gofrs/uuid"
 )
@@ -12,111 +12,121 @@ import (
 var _ Store = (*InMemory)(nil)
 var _ AuthStore = (*InMemory)(nil)
 
+// InMemory is safe for concurrent use, its maps are striped
+// so reads do not contend with writes to other shards
 type InMemory struct {
-	store     map[string]*url.URL
-	userStore map[string]map[string]*url.URL
+	state *memState
 }
 
 // NewInMemory create new InMemory instance
-func NewInMemory() *InMemory {
+func NewInMemory(opts ...Option) *InMemory {
+	o := newOptions(opts)
 	return &InMemory{
-		store:     make(map[string]*url.URL),
-		userStore: make(map[string]map[string]*url.URL),
+		state: newMemState(o.idGenerator),
 	}
 }
 
 func (m *InMemory) Save(_ context.Context, u *url.URL) (id string, err error) {
-	id = fmt.Sprintf("%x", len(m.store))
-	m.store[id] = u
-	return id, nil
+	return m.state.save("", u)
 }
 
 func (m *InMemory) SaveBatch(_ context.Context, urls []*url.URL) (ids []string, err error) {
-	for _, u := range urls {
-		id := fmt.Sprintf("%x", len(m.store))
-		m.store[id] = u
-		ids = append(ids, id)
-	}
-	if len(ids) != len(urls) {
-		return nil, errors.New("not all URLs have been saved")
-	}
-	return
+	return m.saveBatch("", urls)
 }
 
 func (m *InMemory) Load(_ context.Context, id string) (u *url.URL, err error) {
-	u, ok := m.store[id]
-	if !ok {
-		return nil, ErrNotFound
-	}
-	if u == nil {
-		return nil, ErrDeleted
-	}
-	return u, nil
+	return m.state.load(id)
 }
 
-func (m *InMemory) SaveUser(ctx context.Context, uid uuid.UUID, u *url.URL) (id string, err error) {
-	id, err = m.Save(ctx, u)
-	if err != nil {
-		return "", fmt.Errorf("cannot save URL to shared store: %w", err)
-	}
-	if _, ok := m.userStore[uid.String()]; !ok {
-		m.userStore[uid.String()] = make(map[string]*url.URL)
-	}
-	m.userStore[uid.String()][id] = u
-	return id, nil
+func (m *InMemory) SaveUser(_ context.Context, uid uuid.UUID, u *url.URL) (id string, err error) {
+	return m.state.save(uid.String(), u)
 }
 
-func (m *InMemory) SaveUserBatch(ctx context.Context, uid uuid.UUID, urls []*url.URL) (ids []string, err error) {
-	ids, err = m.SaveBatch(ctx, urls)
-	if err != nil {
-		return nil, fmt.Errorf("cannot save URLs to shared store: %w", err)
-	}
-	if _, ok := m.userStore[uid.String()]; !ok {
-		m.userStore[uid.String()] = make(map[string]*url.URL)
-	}
-	for i, id := range ids {
-		m.userStore[uid.String()][id] = urls[i]
-	}
-	return ids, nil
+func (m *InMemory) SaveUserBatch(_ context.Context, uid uuid.UUID, urls []*url.URL) (ids []string, err error) {
+	return m.saveBatch(uid.String(), urls)
 }
 
-func (m *InMemory) LoadUser(ctx context.Context, uid uuid.UUID, id string) (u *url.URL, err error) {
-	urls, err := m.LoadUsers(ctx, uid)
-	if err != nil {
-		return nil, fmt.Errorf("cannot load user urls: %w", err)
-	}
-	u, ok := urls[id]
-	if !ok {
-		return nil, ErrNotFound
-	}
-	if u == nil {
-		return nil, ErrDeleted
-	}
-	return u, nil
+func (m *InMemory) LoadUser(_ context.Context, uid uuid.UUID, id string) (u *url.URL, err error) {
+	return m.state.loadUser(uid.String(), id)
 }
 
 func (m *InMemory) LoadUsers(_ context.Context, uid uuid.UUID) (urls map[string]*url.URL, err error) {
-	urls, ok := m.userStore[uid.String()]
-	if !ok {
-		return nil, ErrNotFound
-	}
-	// filter out deleted URLs
-	res := make(map[string]*url.URL)
-	for k, v := range urls {
-		if v != nil {
-			res[k] = v
+	// deleted URLs are filtered out
+	return m.state.list(uid.String()), nil
+}
+
+func (m *InMemory) SaveLink(_ context.Context, link Link) (id string, err error) {
+	return m.saveLink("", link)
+}
+
+func (m *InMemory) SaveUserLink(_ context.Context, uid uuid.UUID, link Link) (id string, err error) {
+	return m.saveLink(uid.String(), link)
+}
+
+func (m *InMemory) SaveLinks(_ context.Context, links []Link) (results []SaveResult, err error) {
+	return m.saveLinks("", links), nil
+}
+
+func (m *InMemory) SaveUserLinks(_ context.Context, uid uuid.UUID, links []Link) (results []SaveResult, err error) {
+	return m.saveLinks(uid.String(), links), nil
+}
+
+func (m *InMemory) BeginLinks(_ context.Context) (LinkBatch, error) {
+	return m.state.newBatch(""), nil
+}
+
+func (m *InMemory) BeginUserLinks(_ context.Context, uid uuid.UUID) (LinkBatch, error) {
+	return m.state.newBatch(uid.String()), nil
+}
+
+func (m *InMemory) DeleteUsers(ctx context.Context, uid uuid.UUID, ids ...string) error {
+	return m.DeleteBatch(ctx, []DeleteRequest{{UID: uid, IDs: ids}})
+}
+
+func (m *InMemory) DeleteBatch(_ context.Context, reqs []DeleteRequest) error {
+	for _, req := range reqs {
+		userID := req.UID.String()
+		for _, id := range req.IDs {
+			if m.state.owns(userID, id) {
+				m.state.remove(id)
+			}
 		}
 	}
-	return res, nil
+	return nil
 }
 
-func (m *InMemory) DeleteUsers(_ context.Context, uid uuid.UUID, ids ...string) error {
+func (m *InMemory) PurgeExpired(_ context.Context, before time.Time) (n int, err error) {
+	ids := m.state.expiredBefore(before)
 	for _, id := range ids {
-		userID := uid.String()
-		if _, ok := m.userStore[userID]; ok {
-			m.store[id] = nil
-			m.userStore[userID][id] = nil
-		}
+		m.state.purge(id)
+	}
+	return len(ids), nil
+}
+
+func (m *InMemory) SaveVisits(_ context.Context, visits []Visit) error {
+	m.state.visits.add(visits...)
+	return nil
+}
+
+func (m *InMemory) LoadStats(_ context.Context, id string) (stats Stats, err error) {
+	return m.state.visits.stats(id), nil
+}
+
+func (m *InMemory) SaveAPIKey(_ context.Context, key APIKey) error {
+	return m.state.keys.putIfAbsent(key)
+}
+
+func (m *InMemory) LoadAPIKey(_ context.Context, hash string) (key APIKey, err error) {
+	return m.state.keys.load(hash)
+}
+
+func (m *InMemory) LoadAPIKeys(_ context.Context, uid uuid.UUID) (keys []APIKey, err error) {
+	return m.state.keys.list(uid), nil
+}
+
+func (m *InMemory) RevokeAPIKey(_ context.Context, uid uuid.UUID, id string) error {
+	if !m.state.keys.revokeOwned(uid, id, time.Now()) {
+		return ErrNotFound
 	}
 	return nil
 }
@@ -128,3 +138,39 @@ func (m *InMemory) Close() error {
 func (m *InMemory) Ping(_ context.Context) error {
 	return nil
 }
+
+// saveBatch reuses IDs of already stored URLs instead of reporting conflict
+func (m *InMemory) saveBatch(userID string, urls []*url.URL) (ids []string, err error) {
+	for _, u := range urls {
+		id, err := m.state.save(userID, u)
+		if err != nil && !errors.Is(err, ErrConflict) {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (m *InMemory) saveLinks(userID string, links []Link) []SaveResult {
+	results := make([]SaveResult, 0, len(links))
+	for _, link := range links {
+		id, err := m.saveLink(userID, link)
+		results = append(results, SaveResult{ID: id, Err: err})
+	}
+	return results
+}
+
+func (m *InMemory) saveLink(userID string, link Link) (id string, err error) {
+	rec := record{url: link.URL, owner: userID, expiresAt: link.ExpiresAt}
+	switch {
+	case link.Alias != "":
+		if err := m.state.saveAlias(link.Alias, rec); err != nil {
+			return link.Alias, err
+		}
+		return link.Alias, nil
+	case !link.ExpiresAt.IsZero():
+		return m.state.saveNew(rec)
+	default:
+		return m.state.save(userID, link.URL)
+	}
+}
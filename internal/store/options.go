@@ -0,0 +1,61 @@
+package store
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type options struct {
+	snapshotInterval time.Duration
+	maxLogSize       int64
+	idGenerator      IDGenerator
+	insertChunkSize  int
+	logger           *zap.Logger
+}
+
+// Option tunes store instance, backends ignore options they do not support
+type Option func(o *options)
+
+// WithSnapshotInterval sets how often FileStore compacts its log into snapshot
+func WithSnapshotInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.snapshotInterval = d
+	}
+}
+
+// WithMaxLogSize sets FileStore log size in bytes which triggers early compaction
+func WithMaxLogSize(size int64) Option {
+	return func(o *options) {
+		o.maxLogSize = size
+	}
+}
+
+// WithIDGenerator sets strategy of short ID generation
+func WithIDGenerator(gen IDGenerator) Option {
+	return func(o *options) {
+		o.idGenerator = gen
+	}
+}
+
+// WithInsertChunkSize sets number of URLs RDB inserts by a single statement of batch
+func WithInsertChunkSize(n int) Option {
+	return func(o *options) {
+		o.insertChunkSize = n
+	}
+}
+
+// WithLogger sets logger of store background work and recovery, nothing is logged by default
+func WithLogger(l *zap.Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{logger: zap.NewNop()}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
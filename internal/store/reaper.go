@@ -0,0 +1,31 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// RunReaper purges links expired longer than retention ago every interval until ctx is done
+func RunReaper(ctx context.Context, s AuthStore, interval, retention time.Duration, logger *zap.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		n, err := s.PurgeExpired(ctx, time.Now().Add(-retention))
+		if err != nil {
+			logger.Error("cannot purge expired links", zap.Error(err))
+			continue
+		}
+		if n > 0 {
+			logger.Info("purged expired links", zap.Int("count", n))
+		}
+	}
+}
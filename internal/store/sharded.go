@@ -0,0 +1,604 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// shardCount is a number of independently locked stripes of every map
+const shardCount = 32
+
+// shardIndex returns stripe of given key using FNV-1a hash
+func shardIndex(key string) int {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return int(h % shardCount)
+}
+
+// record is a state of short ID, nil URL marks deleted record
+type record struct {
+	url   *url.URL
+	owner string
+	// alias is set for user chosen IDs
+	alias bool
+	// expiresAt is zero for records which never expire
+	expiresAt time.Time
+	// pending marks ID reserved by uncommitted batch, such record is not visible yet
+	pending bool
+}
+
+// deduplicated reports whether record takes part in original URL uniqueness,
+// aliases and expiring records are always stored separately
+func (r record) deduplicated() bool {
+	return !r.alias && r.expiresAt.IsZero()
+}
+
+func (r record) expired(now time.Time) bool {
+	return !r.expiresAt.IsZero() && !now.Before(r.expiresAt)
+}
+
+type urlShard struct {
+	mu      sync.RWMutex
+	records map[string]record
+}
+
+// urlMap maps short ID to its record
+type urlMap struct {
+	shards [shardCount]urlShard
+}
+
+func newURLMap() *urlMap {
+	m := new(urlMap)
+	for i := range m.shards {
+		m.shards[i].records = make(map[string]record)
+	}
+	return m
+}
+
+func (m *urlMap) get(id string) (rec record, ok bool) {
+	s := &m.shards[shardIndex(id)]
+	s.mu.RLock()
+	rec, ok = s.records[id]
+	s.mu.RUnlock()
+	return
+}
+
+// setIfAbsent stores record only if ID is not taken yet
+func (m *urlMap) setIfAbsent(id string, rec record) bool {
+	s := &m.shards[shardIndex(id)]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.records[id]; ok {
+		return false
+	}
+	s.records[id] = rec
+	return true
+}
+
+func (m *urlMap) set(id string, rec record) {
+	s := &m.shards[shardIndex(id)]
+	s.mu.Lock()
+	s.records[id] = rec
+	s.mu.Unlock()
+}
+
+func (m *urlMap) delete(id string) {
+	s := &m.shards[shardIndex(id)]
+	s.mu.Lock()
+	delete(s.records, id)
+	s.mu.Unlock()
+}
+
+func (m *urlMap) each(fn func(id string, rec record)) {
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.mu.RLock()
+		for id, rec := range s.records {
+			fn(id, rec)
+		}
+		s.mu.RUnlock()
+	}
+}
+
+type userShard struct {
+	mu    sync.RWMutex
+	users map[string]map[string]struct{}
+}
+
+// userMap maps user ID to set of short IDs owned by the user
+type userMap struct {
+	shards [shardCount]userShard
+}
+
+func newUserMap() *userMap {
+	m := new(userMap)
+	for i := range m.shards {
+		m.shards[i].users = make(map[string]map[string]struct{})
+	}
+	return m
+}
+
+func (m *userMap) put(userID, id string) {
+	s := &m.shards[shardIndex(userID)]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[userID]; !ok {
+		s.users[userID] = make(map[string]struct{})
+	}
+	s.users[userID][id] = struct{}{}
+}
+
+func (m *userMap) has(userID, id string) bool {
+	s := &m.shards[shardIndex(userID)]
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.users[userID][id]
+	return ok
+}
+
+func (m *userMap) remove(userID, id string) {
+	s := &m.shards[shardIndex(userID)]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.users[userID], id)
+	if len(s.users[userID]) == 0 {
+		delete(s.users, userID)
+	}
+}
+
+func (m *userMap) ids(userID string) []string {
+	s := &m.shards[shardIndex(userID)]
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := make([]string, 0, len(s.users[userID]))
+	for id := range s.users[userID] {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+type indexShard struct {
+	mu  sync.Mutex
+	ids map[string]string
+}
+
+// urlIndex maps original URL to ID of its live generated record
+type urlIndex struct {
+	shards [shardCount]indexShard
+}
+
+func newURLIndex() *urlIndex {
+	idx := new(urlIndex)
+	for i := range idx.shards {
+		idx.shards[i].ids = make(map[string]string)
+	}
+	return idx
+}
+
+func (idx *urlIndex) shard(rawURL string) *indexShard {
+	return &idx.shards[shardIndex(rawURL)]
+}
+
+func (idx *urlIndex) get(rawURL string) (id string, ok bool) {
+	s := idx.shard(rawURL)
+	s.mu.Lock()
+	id, ok = s.ids[rawURL]
+	s.mu.Unlock()
+	return
+}
+
+func (idx *urlIndex) set(rawURL, id string) {
+	s := idx.shard(rawURL)
+	s.mu.Lock()
+	s.ids[rawURL] = id
+	s.mu.Unlock()
+}
+
+// drop removes URL from index only if it still points to given ID
+func (idx *urlIndex) drop(rawURL, id string) {
+	s := idx.shard(rawURL)
+	s.mu.Lock()
+	if s.ids[rawURL] == id {
+		delete(s.ids, rawURL)
+	}
+	s.mu.Unlock()
+}
+
+// memState is an in-memory state shared by InMemory and FileStore
+type memState struct {
+	seq uint64
+	// visitEpoch is a generation of FileStore visits journal which follows restored snapshot
+	visitEpoch uint64
+
+	gen    IDGenerator
+	urls   *urlMap
+	users  *userMap
+	index  *urlIndex
+	visits *visitMap
+	keys   *apiKeyMap
+}
+
+func newMemState(gen IDGenerator) *memState {
+	if gen == nil {
+		gen = NewHexGenerator()
+	}
+	return &memState{
+		gen:    gen,
+		urls:   newURLMap(),
+		users:  newUserMap(),
+		index:  newURLIndex(),
+		visits: newVisitMap(),
+		keys:   newAPIKeyMap(),
+	}
+}
+
+// nextID generates ID until taken reports it is free
+func (s *memState) nextID(taken func(id string) bool) (id string, seq uint64, err error) {
+	for i := 0; i < maxIDAttempts; i++ {
+		seq = atomic.AddUint64(&s.seq, 1) - 1
+		id, err = s.gen.Generate(seq)
+		if err != nil {
+			return "", 0, fmt.Errorf("cannot generate id: %w", err)
+		}
+		if !taken(id) {
+			return id, seq, nil
+		}
+	}
+	return "", 0, ErrIDCollision
+}
+
+// observe moves ID sequence past restored record
+func (s *memState) observe(rec logRecord) {
+	seq := rec.Seq
+	if !rec.HasSeq {
+		// legacy records carry hex encoded sequence as ID
+		n, err := strconv.ParseUint(rec.ID, 16, 64)
+		if err != nil {
+			return
+		}
+		seq = n
+	}
+	if seq >= s.seq {
+		s.seq = seq + 1
+	}
+}
+
+// save stores URL under newly allocated ID unless it is already stored
+func (s *memState) save(userID string, u *url.URL) (id string, err error) {
+	rawURL := u.String()
+	idx := s.index.shard(rawURL)
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if id, ok := idx.ids[rawURL]; ok {
+		return id, ErrConflict
+	}
+
+	// URL is stored by the same call which checks ID is free
+	id, _, err = s.nextID(func(id string) bool {
+		return !s.urls.setIfAbsent(id, record{url: u, owner: userID})
+	})
+	if err != nil {
+		return "", err
+	}
+	if userID != "" {
+		s.users.put(userID, id)
+	}
+	idx.ids[rawURL] = id
+	return id, nil
+}
+
+// saveAlias stores record under user chosen ID
+func (s *memState) saveAlias(alias string, rec record) error {
+	rec.alias = true
+	if !s.urls.setIfAbsent(alias, rec) {
+		return ErrConflict
+	}
+	if rec.owner != "" {
+		s.users.put(rec.owner, alias)
+	}
+	return nil
+}
+
+// saveNew stores record under newly allocated ID without URL deduplication
+func (s *memState) saveNew(rec record) (id string, err error) {
+	id, _, err = s.nextID(func(id string) bool {
+		return !s.urls.setIfAbsent(id, rec)
+	})
+	if err != nil {
+		return "", err
+	}
+	if rec.owner != "" {
+		s.users.put(rec.owner, id)
+	}
+	return id, nil
+}
+
+func (s *memState) put(id string, rec record) {
+	s.urls.set(id, rec)
+	if rec.owner != "" {
+		s.users.put(rec.owner, id)
+	}
+	if rec.deduplicated() && rec.url != nil {
+		s.index.set(rec.url.String(), id)
+	}
+}
+
+func (s *memState) load(id string) (*url.URL, error) {
+	rec, ok := s.urls.get(id)
+	if !ok || rec.pending {
+		return nil, ErrNotFound
+	}
+	if rec.url == nil {
+		return nil, ErrDeleted
+	}
+	if rec.expired(time.Now()) {
+		return nil, ErrExpired
+	}
+	return rec.url, nil
+}
+
+func (s *memState) loadUser(userID, id string) (*url.URL, error) {
+	if !s.users.has(userID, id) {
+		return nil, ErrNotFound
+	}
+	return s.load(id)
+}
+
+// list returns user URLs without deleted and expired ones
+func (s *memState) list(userID string) map[string]*url.URL {
+	now := time.Now()
+	urls := make(map[string]*url.URL)
+	for _, id := range s.users.ids(userID) {
+		if rec, ok := s.urls.get(id); ok && rec.url != nil && !rec.expired(now) {
+			urls[id] = rec.url
+		}
+	}
+	return urls
+}
+
+// owns reports whether user has live record with given ID
+func (s *memState) owns(userID, id string) bool {
+	if !s.users.has(userID, id) {
+		return false
+	}
+	rec, ok := s.urls.get(id)
+	return ok && rec.url != nil
+}
+
+func (s *memState) remove(id string) {
+	rec, _ := s.urls.get(id)
+	s.urls.set(id, record{owner: rec.owner, alias: rec.alias, expiresAt: rec.expiresAt})
+	if rec.deduplicated() && rec.url != nil {
+		s.index.drop(rec.url.String(), id)
+	}
+}
+
+// purge forgets record completely
+func (s *memState) purge(id string) {
+	rec, ok := s.urls.get(id)
+	if !ok {
+		return
+	}
+	s.urls.delete(id)
+	s.visits.reset(id)
+	if rec.owner != "" {
+		s.users.remove(rec.owner, id)
+	}
+	if rec.deduplicated() && rec.url != nil {
+		s.index.drop(rec.url.String(), id)
+	}
+}
+
+// expiredBefore returns IDs of records expired before given time
+func (s *memState) expiredBefore(before time.Time) (ids []string) {
+	s.urls.each(func(id string, rec record) {
+		if !rec.pending && rec.expired(before) {
+			ids = append(ids, id)
+		}
+	})
+	return ids
+}
+
+func (s *memState) apply(rec logRecord) error {
+	switch rec.Op {
+	case opSave, opSaveAlias:
+		u, err := url.Parse(rec.URL)
+		if err != nil {
+			return fmt.Errorf("cannot parse URL: %w", err)
+		}
+		s.put(rec.ID, record{url: u, owner: rec.UID, alias: rec.Op == opSaveAlias, expiresAt: rec.ExpiresAt})
+		if rec.Op == opSave {
+			s.observe(rec)
+		}
+	case opDelete:
+		s.remove(rec.ID)
+	case opPurge:
+		s.purge(rec.ID)
+	case opSequence:
+		if rec.Seq > s.seq {
+			s.seq = rec.Seq
+		}
+	case opSaveKey:
+		uid, err := uuid.FromString(rec.UID)
+		if err != nil {
+			return fmt.Errorf("cannot parse API key owner: %w", err)
+		}
+		s.keys.put(APIKey{ID: rec.ID, UID: uid, Name: rec.Name, Hash: rec.Hash, CreatedAt: rec.At})
+	case opRevokeKey:
+		s.keys.revoke(rec.ID, rec.At)
+	case opVisits:
+		s.visits.addDaily(rec.ID, rec.Day, rec.Count)
+	case opVisitEpoch:
+		s.visitEpoch = rec.Seq
+	}
+	return nil
+}
+
+// records returns minimal set of records which restores current state
+func (s *memState) records() []logRecord {
+	all := make(map[string]record)
+	s.urls.each(func(id string, rec record) {
+		if !rec.pending {
+			all[id] = rec
+		}
+	})
+
+	ids := make([]string, 0, len(all))
+	for id := range all {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	recs := make([]logRecord, 0, len(ids)+1)
+	recs = append(recs, logRecord{Op: opSequence, Seq: atomic.LoadUint64(&s.seq), HasSeq: true})
+
+	// sequence is restored by the record above,
+	// zero seq of saves keeps their IDs from being parsed as legacy ones
+	for _, id := range ids {
+		rec := all[id]
+		save := logRecord{Op: opSave, ID: id, UID: rec.owner, HasSeq: true, ExpiresAt: rec.expiresAt}
+		if rec.alias {
+			save.Op = opSaveAlias
+		}
+		if rec.url == nil {
+			recs = append(recs, save, logRecord{Op: opDelete, ID: id, UID: rec.owner})
+			continue
+		}
+		save.URL = rec.url.String()
+		recs = append(recs, save)
+	}
+
+	for _, key := range s.keys.all() {
+		recs = append(recs, logRecord{Op: opSaveKey, ID: key.ID, UID: key.UID.String(), Name: key.Name, Hash: key.Hash, At: key.CreatedAt})
+		if !key.RevokedAt.IsZero() {
+			recs = append(recs, logRecord{Op: opRevokeKey, ID: key.ID, UID: key.UID.String(), At: key.RevokedAt})
+		}
+	}
+
+	// visits are folded into daily counts, so journal of raw ones may be dropped
+	recs = append(recs, logRecord{Op: opVisitEpoch, Seq: s.visitEpoch, HasSeq: true})
+	return s.visits.records(recs)
+}
+
+// reservation is a record of memBatch along with sequence of its generated ID
+type reservation struct {
+	id  string
+	seq uint64
+	rec record
+}
+
+var _ LinkBatch = (*memBatch)(nil)
+
+// memBatch reserves IDs of batch links as pending records, so links stay invisible
+// until all of them are reserved and committed together
+type memBatch struct {
+	state *memState
+	owner string
+	// known maps URLs of batch to their IDs, so repeated URL is saved once
+	known    map[string]string
+	reserved []reservation
+}
+
+func (s *memState) newBatch(userID string) *memBatch {
+	return &memBatch{state: s, owner: userID, known: make(map[string]string)}
+}
+
+// Save reserves IDs of links
+func (b *memBatch) Save(_ context.Context, links []Link) (ids []string, err error) {
+	ids = make([]string, 0, len(links))
+	for n, link := range links {
+		rec := record{url: link.URL, owner: b.owner, expiresAt: link.ExpiresAt, pending: true}
+		if link.Alias != "" {
+			rec.alias = true
+			if !b.state.urls.setIfAbsent(link.Alias, rec) {
+				return nil, &LinkError{Index: n, Err: ErrConflict}
+			}
+			b.reserved = append(b.reserved, reservation{id: link.Alias, rec: rec})
+			ids = append(ids, link.Alias)
+			continue
+		}
+
+		rawURL := link.URL.String()
+		if rec.deduplicated() {
+			id, ok := b.known[rawURL]
+			if !ok {
+				id, ok = b.state.index.get(rawURL)
+			}
+			if ok {
+				b.known[rawURL] = id
+				ids = append(ids, id)
+				continue
+			}
+		}
+
+		// expiring link gets its own ID even if URL is already stored
+		id, seq, err := b.state.nextID(func(id string) bool {
+			return !b.state.urls.setIfAbsent(id, rec)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if rec.deduplicated() {
+			b.known[rawURL] = id
+		}
+		b.reserved = append(b.reserved, reservation{id: id, seq: seq, rec: rec})
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Commit makes reserved records visible. URL saved by someone else meanwhile keeps its ID valid,
+// though new links of the URL are deduplicated to the batch one.
+func (b *memBatch) Commit(_ context.Context) error {
+	b.commit()
+	return nil
+}
+
+func (b *memBatch) commit() {
+	for _, r := range b.reserved {
+		r.rec.pending = false
+		b.state.put(r.id, r.rec)
+	}
+	b.reserved = nil
+}
+
+// Rollback frees reserved IDs
+func (b *memBatch) Rollback() error {
+	for _, r := range b.reserved {
+		b.state.urls.delete(r.id)
+	}
+	b.reserved = nil
+	return nil
+}
+
+// logRecords returns records which save reserved links
+func (b *memBatch) logRecords() []logRecord {
+	recs := make([]logRecord, 0, len(b.reserved))
+	for _, r := range b.reserved {
+		rec := logRecord{Op: opSave, ID: r.id, UID: r.rec.owner, URL: r.rec.url.String(), ExpiresAt: r.rec.expiresAt}
+		if r.rec.alias {
+			rec.Op = opSaveAlias
+		} else {
+			rec.Seq, rec.HasSeq = r.seq, true
+		}
+		recs = append(recs, rec)
+	}
+	return recs
+}
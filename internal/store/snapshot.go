@@ -0,0 +1,79 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"go.uber.org/zap"
+)
+
+// writeSnapshot atomically replaces snapshot at path with given records
+func writeSnapshot(path string, recs []logRecord) error {
+	fd, err := replaceFile(path, encodeFrames(nil, recs...))
+	if err != nil {
+		return fmt.Errorf("cannot write snapshot: %w", err)
+	}
+	if err := fd.Close(); err != nil {
+		return fmt.Errorf("cannot close snapshot: %w", err)
+	}
+	return nil
+}
+
+// replaceFile atomically replaces file at path with data written to temporary file,
+// the file is returned open for writing at its end
+func replaceFile(path string, data []byte) (*os.File, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return nil, fmt.Errorf("cannot create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return nil, fmt.Errorf("cannot write temporary file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return nil, fmt.Errorf("cannot sync temporary file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		_ = tmp.Close()
+		return nil, fmt.Errorf("cannot replace file: %w", err)
+	}
+	if err := syncDir(filepath.Dir(path)); err != nil {
+		_ = tmp.Close()
+		return nil, err
+	}
+	return tmp, nil
+}
+
+// loadSnapshot feeds every snapshot record to apply, missing snapshot is not an error
+func loadSnapshot(path string, apply func(rec logRecord) error, logger *zap.Logger) error {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	rl, err := openRecordLog(path)
+	if err != nil {
+		return err
+	}
+	if err := rl.replay(apply, logger); err != nil {
+		_ = rl.close()
+		return err
+	}
+	return rl.close()
+}
+
+func syncDir(dir string) error {
+	fd, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("cannot open directory: %w", err)
+	}
+	defer fd.Close()
+	if err := fd.Sync(); err != nil {
+		return fmt.Errorf("cannot sync directory: %w", err)
+	}
+	return nil
+}
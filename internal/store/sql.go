@@ -9,35 +9,101 @@ import (
 	"time"
 
 	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx"
 	"github.com/jackc/pgx/pgtype"
 )
 
 var _ Store = (*RDB)(nil)
 var _ AuthStore = (*RDB)(nil)
 
+// maxInsertChunkSize keeps batch insert of owner and 3 parameters per URL within bind parameters limit
+const maxInsertChunkSize = (65535 - 1) / 3
+
 type RDB struct {
-	db *sql.DB
+	db  *sql.DB
+	gen IDGenerator
+	// chunkSize is a number of URLs inserted by a single statement of batch,
+	// the largest possible one is used by default
+	chunkSize int
 }
 
-func NewRDB(db *sql.DB) *RDB {
+func NewRDB(db *sql.DB, opts ...Option) *RDB {
+	o := newOptions(opts)
+	gen := o.idGenerator
+	if gen == nil {
+		gen = decimalGenerator{}
+	}
+	chunkSize := o.insertChunkSize
+	if chunkSize <= 0 || chunkSize > maxInsertChunkSize {
+		chunkSize = maxInsertChunkSize
+	}
 	return &RDB{
-		db: db,
+		db:        db,
+		gen:       gen,
+		chunkSize: chunkSize,
 	}
 }
 
+// queryer is either database or transaction
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
 // Bootstrap creates all necessary tables and their structures
 func (r *RDB) Bootstrap(ctx context.Context) error {
 	query := `
 		CREATE TABLE IF NOT EXISTS urls (
 			id serial PRIMARY KEY,
+			short_id text,
+			is_alias boolean NOT NULL DEFAULT false,
 			original_url text,
 			user_id uuid,
 			updated_at timestamp without time zone,
-		    deleted_at timestamp without time zone
+		    deleted_at timestamp without time zone,
+			expires_at timestamp with time zone
 		);
 
+		-- rows created before ID generators keep serial ID as short one
+		ALTER TABLE urls ADD COLUMN IF NOT EXISTS short_id text;
+		UPDATE urls SET short_id = id::text WHERE short_id IS NULL;
+
+		-- aliases do not take part in original URL deduplication
+		ALTER TABLE urls ADD COLUMN IF NOT EXISTS is_alias boolean NOT NULL DEFAULT false;
+		DROP INDEX IF EXISTS original_url_idx;
+
+		-- expiring links are not deduplicated either
+		ALTER TABLE urls ADD COLUMN IF NOT EXISTS expires_at timestamp with time zone;
+		DROP INDEX IF EXISTS original_url_generated_idx;
+
 		CREATE INDEX IF NOT EXISTS user_id_idx ON urls (user_id);
-		CREATE UNIQUE INDEX IF NOT EXISTS original_url_idx ON urls (original_url) WHERE deleted_at IS NULL;
+		CREATE UNIQUE INDEX IF NOT EXISTS short_id_idx ON urls (short_id);
+		CREATE UNIQUE INDEX IF NOT EXISTS original_url_permanent_idx ON urls (original_url) WHERE deleted_at IS NULL AND NOT is_alias AND expires_at IS NULL;
+		CREATE INDEX IF NOT EXISTS expires_at_idx ON urls (expires_at) WHERE expires_at IS NOT NULL;
+
+		CREATE TABLE IF NOT EXISTS visits (
+			id bigserial PRIMARY KEY,
+			short_id text NOT NULL,
+			visited_at timestamp with time zone NOT NULL,
+			referrer text,
+			user_agent text,
+			ip_hash text
+		);
+
+		CREATE INDEX IF NOT EXISTS visits_short_id_idx ON visits (short_id, visited_at);
+
+		CREATE TABLE IF NOT EXISTS api_keys (
+			id text PRIMARY KEY,
+			user_id uuid NOT NULL,
+			name text NOT NULL,
+			key_hash text NOT NULL,
+			created_at timestamp with time zone NOT NULL,
+			revoked_at timestamp with time zone
+		);
+
+		CREATE UNIQUE INDEX IF NOT EXISTS api_keys_hash_idx ON api_keys (key_hash);
+		CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON api_keys (user_id);
 	`
 
 	tx, err := r.db.BeginTx(ctx, nil)
@@ -58,231 +124,750 @@ func (r *RDB) Bootstrap(ctx context.Context) error {
 }
 
 func (r *RDB) Save(ctx context.Context, url *url.URL) (id string, err error) {
+	return r.save(ctx, nil, url)
+}
+
+func (r *RDB) SaveBatch(ctx context.Context, urls []*url.URL) (ids []string, err error) {
+	return r.saveBatch(ctx, nil, urls)
+}
+
+func (r *RDB) Load(ctx context.Context, id string) (url *url.URL, err error) {
+	var rawURL string
+	var deletedAt, expiresAt *time.Time
+	query := `SELECT original_url, deleted_at, expires_at FROM urls WHERE short_id = $1;`
+
+	err = r.db.QueryRowContext(ctx, query, id).Scan(&rawURL, &deletedAt, &expiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("cannot scan row: %w", err)
+	}
+	if deletedAt != nil {
+		return nil, ErrDeleted
+	}
+	if expiresAt != nil && !time.Now().Before(*expiresAt) {
+		return nil, ErrExpired
+	}
+
+	return url.Parse(rawURL)
+}
+
+func (r *RDB) SaveUser(ctx context.Context, uid uuid.UUID, url *url.URL) (id string, err error) {
+	return r.save(ctx, uid, url)
+}
+
+func (r *RDB) SaveUserBatch(ctx context.Context, uid uuid.UUID, urls []*url.URL) (ids []string, err error) {
+	return r.saveBatch(ctx, &uid, urls)
+}
+
+func (r *RDB) LoadUser(ctx context.Context, uid uuid.UUID, id string) (url *url.URL, err error) {
+	var rawURL string
+	var deletedAt, expiresAt *time.Time
+	query := `SELECT original_url, deleted_at, expires_at FROM urls WHERE short_id = $1 AND user_id = $2;`
+
+	err = r.db.QueryRowContext(ctx, query, id, uid).Scan(&rawURL, &deletedAt, &expiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("cannot scan row: %w", err)
+	}
+	if deletedAt != nil {
+		return nil, ErrDeleted
+	}
+	if expiresAt != nil && !time.Now().Before(*expiresAt) {
+		return nil, ErrExpired
+	}
+
+	return url.Parse(rawURL)
+}
+
+func (r *RDB) LoadUsers(ctx context.Context, uid uuid.UUID) (urls map[string]*url.URL, err error) {
+	query := `SELECT short_id, original_url FROM urls WHERE user_id = $1 AND deleted_at IS NULL AND (expires_at IS NULL OR expires_at > NOW());`
+
+	rows, err := r.db.QueryContext(ctx, query, uid)
+	if err != nil {
+		return nil, fmt.Errorf("cannot query rows: %w", err)
+	}
+	defer rows.Close()
+
+	res := make(map[string]*url.URL)
+	for rows.Next() {
+		var id, rawURL string
+
+		if err := rows.Scan(&id, &rawURL); err != nil {
+			return nil, fmt.Errorf("cannot scan row: %w", err)
+		}
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse URL: %w", err)
+		}
+
+		res[id] = u
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return res, nil
+}
+
+func (r *RDB) DeleteUsers(ctx context.Context, uid uuid.UUID, ids ...string) error {
+	return r.DeleteBatch(ctx, []DeleteRequest{{UID: uid, IDs: ids}})
+}
+
+// DeleteBatch marks IDs of all users deleted with a single statement
+func (r *RDB) DeleteBatch(ctx context.Context, reqs []DeleteRequest) error {
+	var uids, ids []string
+	for _, req := range reqs {
+		for _, id := range req.IDs {
+			uids = append(uids, req.UID.String())
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	uidArr := new(pgtype.TextArray)
+	if err := uidArr.Set(uids); err != nil {
+		return fmt.Errorf("cannot set uids to pg variable: %w", err)
+	}
+	idArr := new(pgtype.TextArray)
+	if err := idArr.Set(ids); err != nil {
+		return fmt.Errorf("cannot set ids to pg variable: %w", err)
+	}
+
 	query := `
-		INSERT INTO urls
-		    (original_url)
-		VALUES
-		    ($1)
-		ON CONFLICT (original_url) WHERE deleted_at IS NULL
-		DO UPDATE SET updated_at = NOW()
-		RETURNING
-		    id,
-		    updated_at
+		UPDATE urls SET deleted_at = NOW()
+		FROM (SELECT unnest($1::text[])::uuid AS user_id, unnest($2::text[]) AS short_id) AS del
+		WHERE urls.user_id = del.user_id AND urls.short_id = del.short_id AND urls.deleted_at IS NULL
 	`
+	if _, err := r.db.ExecContext(ctx, query, uidArr, idArr); err != nil {
+		return fmt.Errorf("cannot delete urls: %w", err)
+	}
+	return nil
+}
 
-	var lid int64
-	var updatedAt *time.Time
-	err = r.db.QueryRowContext(ctx, query, url.String()).Scan(&lid, &updatedAt)
+func (r *RDB) SaveLink(ctx context.Context, link Link) (id string, err error) {
+	return r.saveLink(ctx, nil, link)
+}
+
+func (r *RDB) SaveUserLink(ctx context.Context, uid uuid.UUID, link Link) (id string, err error) {
+	return r.saveLink(ctx, uid, link)
+}
+
+func (r *RDB) SaveLinks(ctx context.Context, links []Link) (results []SaveResult, err error) {
+	return r.saveLinks(ctx, nil, links), nil
+}
+
+func (r *RDB) SaveUserLinks(ctx context.Context, uid uuid.UUID, links []Link) (results []SaveResult, err error) {
+	return r.saveLinks(ctx, uid, links), nil
+}
+
+func (r *RDB) BeginLinks(ctx context.Context) (LinkBatch, error) {
+	return r.begin(ctx, nil)
+}
+
+func (r *RDB) BeginUserLinks(ctx context.Context, uid uuid.UUID) (LinkBatch, error) {
+	return r.begin(ctx, uid)
+}
+
+func (r *RDB) PurgeExpired(ctx context.Context, before time.Time) (n int, err error) {
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		return "", fmt.Errorf("cannot fetch conflict url: %w", err)
+		return 0, fmt.Errorf("cannot start transaction: %w", err)
 	}
+	defer tx.Rollback()
 
-	id = fmt.Sprint(lid)
-	if updatedAt != nil && !updatedAt.IsZero() {
-		err = ErrConflict
+	// visits of purged ID must not be inherited by its next owner
+	query := `DELETE FROM visits WHERE short_id IN (SELECT short_id FROM urls WHERE expires_at < $1);`
+	if _, err := tx.ExecContext(ctx, query, before); err != nil {
+		return 0, fmt.Errorf("cannot purge visits of expired urls: %w", err)
 	}
-	return
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM urls WHERE expires_at < $1;`, before)
+	if err != nil {
+		return 0, fmt.Errorf("cannot purge expired urls: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("cannot get purged rows count: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("cannot commit transaction: %w", err)
+	}
+	return int(affected), nil
 }
 
-func (r *RDB) SaveBatch(ctx context.Context, urls []*url.URL) (ids []string, err error) {
-	var args []interface{}
+// visitsChunkSize keeps single insert below bind parameters limit
+const visitsChunkSize = 1000
 
-	var insertValues string
-	for i, u := range urls {
-		if i > 0 {
-			insertValues += ","
+func (r *RDB) SaveVisits(ctx context.Context, visits []Visit) error {
+	for len(visits) > 0 {
+		n := len(visits)
+		if n > visitsChunkSize {
+			n = visitsChunkSize
 		}
-		insertValues += fmt.Sprintf("($%d)", i+1)
-		args = append(args, u.String())
+
+		var args []interface{}
+		var insertValues string
+		for i, v := range visits[:n] {
+			if i > 0 {
+				insertValues += ","
+			}
+			insertValues += fmt.Sprintf("($%d, $%d, $%d, $%d, $%d)", len(args)+1, len(args)+2, len(args)+3, len(args)+4, len(args)+5)
+			args = append(args, v.ID, v.At, v.Referrer, v.UserAgent, v.IPHash)
+		}
+
+		query := `INSERT INTO visits (short_id, visited_at, referrer, user_agent, ip_hash) VALUES ` + insertValues
+		if _, err := r.db.ExecContext(ctx, query, args...); err != nil {
+			return fmt.Errorf("cannot insert visits: %w", err)
+		}
+		visits = visits[n:]
 	}
+	return nil
+}
 
+func (r *RDB) LoadStats(ctx context.Context, id string) (stats Stats, err error) {
 	query := `
-		INSERT INTO urls
-			(original_url)
-		VALUES ` + insertValues + `
-		ON CONFLICT (original_url) WHERE deleted_at IS NULL
-		DO UPDATE SET updated_at = NOW()
-		RETURNING id
+		SELECT
+			date_trunc('day', visited_at AT TIME ZONE 'UTC') AS day,
+			count(*)
+		FROM visits
+		WHERE short_id = $1
+		GROUP BY day
+		ORDER BY day
 	`
 
-	rows, err := r.db.QueryContext(ctx, query, args...)
+	rows, err := r.db.QueryContext(ctx, query, id)
 	if err != nil {
-		return nil, fmt.Errorf("query error: %w", err)
+		return Stats{}, fmt.Errorf("cannot query visits: %w", err)
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var id int64
-		if err := rows.Scan(&id); err != nil {
-			return nil, fmt.Errorf("error scanning row: %w", err)
+		var daily DailyVisits
+		if err := rows.Scan(&daily.Day, &daily.Count); err != nil {
+			return Stats{}, fmt.Errorf("cannot scan row: %w", err)
 		}
-		ids = append(ids, fmt.Sprint(id))
+		daily.Day = time.Date(daily.Day.Year(), daily.Day.Month(), daily.Day.Day(), 0, 0, 0, 0, time.UTC)
+		stats.Total += daily.Count
+		stats.Daily = append(stats.Daily, daily)
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("cursor error: %w", err)
+		return Stats{}, fmt.Errorf("rows error: %w", err)
 	}
+	return stats, nil
+}
 
-	if len(ids) != len(urls) {
-		return nil, errors.New("not all URLs have been saved")
-	}
+func (r *RDB) SaveAPIKey(ctx context.Context, key APIKey) error {
+	query := `
+		INSERT INTO api_keys
+		    (id, user_id, name, key_hash, created_at)
+		VALUES
+		    ($1, $2, $3, $4, $5)
+		ON CONFLICT DO NOTHING
+	`
 
-	return ids, nil
+	res, err := r.db.ExecContext(ctx, query, key.ID, key.UID, key.Name, key.Hash, key.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("cannot insert API key: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("cannot get inserted rows count: %w", err)
+	}
+	if affected == 0 {
+		return ErrConflict
+	}
+	return nil
 }
 
-func (r *RDB) Load(ctx context.Context, id string) (url *url.URL, err error) {
-	var rawURL string
-	var deletedAt *time.Time
-	query := `SELECT original_url, deleted_at FROM urls WHERE id = $1;`
+func (r *RDB) LoadAPIKey(ctx context.Context, hash string) (key APIKey, err error) {
+	var revokedAt *time.Time
+	query := `SELECT id, user_id, name, key_hash, created_at, revoked_at FROM api_keys WHERE key_hash = $1;`
 
-	err = r.db.QueryRowContext(ctx, query, id).Scan(&rawURL, &deletedAt)
+	err = r.db.QueryRowContext(ctx, query, hash).Scan(&key.ID, &key.UID, &key.Name, &key.Hash, &key.CreatedAt, &revokedAt)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, nil
+			return APIKey{}, ErrNotFound
 		}
-		return nil, fmt.Errorf("cannot scan row: %w", err)
+		return APIKey{}, fmt.Errorf("cannot scan row: %w", err)
 	}
-	if deletedAt != nil {
-		return nil, ErrDeleted
+	if revokedAt != nil {
+		key.RevokedAt = *revokedAt
+		return key, ErrDeleted
 	}
+	return key, nil
+}
 
-	return url.Parse(rawURL)
+func (r *RDB) LoadAPIKeys(ctx context.Context, uid uuid.UUID) (keys []APIKey, err error) {
+	query := `
+		SELECT id, user_id, name, key_hash, created_at
+		FROM api_keys
+		WHERE user_id = $1 AND revoked_at IS NULL
+		ORDER BY created_at, id
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, uid)
+	if err != nil {
+		return nil, fmt.Errorf("cannot query rows: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key APIKey
+		if err := rows.Scan(&key.ID, &key.UID, &key.Name, &key.Hash, &key.CreatedAt); err != nil {
+			return nil, fmt.Errorf("cannot scan row: %w", err)
+		}
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+	return keys, nil
 }
 
-func (r *RDB) SaveUser(ctx context.Context, uid uuid.UUID, url *url.URL) (id string, err error) {
+func (r *RDB) RevokeAPIKey(ctx context.Context, uid uuid.UUID, id string) error {
+	query := `UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL;`
+
+	res, err := r.db.ExecContext(ctx, query, id, uid)
+	if err != nil {
+		return fmt.Errorf("cannot revoke API key: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("cannot get revoked rows count: %w", err)
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *RDB) Ping(ctx context.Context) error {
+	return r.db.PingContext(ctx)
+}
+
+func (r *RDB) Close() error {
+	return r.db.Close()
+}
+
+// save inserts URL owned by optional owner, already stored URL keeps its ID
+func (r *RDB) save(ctx context.Context, owner interface{}, u *url.URL) (id string, err error) {
 	query := `
 		INSERT INTO urls
-		    (original_url, user_id)
+		    (id, short_id, original_url, user_id)
 		VALUES
-		    ($1, $2)
-		ON CONFLICT (original_url) WHERE deleted_at IS NULL
+		    ($1, $2, $3, $4)
+		ON CONFLICT (original_url) WHERE deleted_at IS NULL AND NOT is_alias AND expires_at IS NULL
 		DO UPDATE SET updated_at = NOW()
 		RETURNING
-		    id,
+		    short_id,
 		    updated_at
 	`
 
-	var lid int64
-	var updatedAt *time.Time
-	err = r.db.QueryRowContext(ctx, query, url.String(), uid).Scan(&lid, &updatedAt)
-	if err != nil {
-		return "", fmt.Errorf("cannot fetch conflict url: %w", err)
+	for i := 0; i < maxIDAttempts; i++ {
+		seqs, shortIDs, err := r.nextIDs(ctx, r.db, 1)
+		if err != nil {
+			return "", err
+		}
+
+		var updatedAt *time.Time
+		err = r.db.QueryRowContext(ctx, query, seqs[0], shortIDs[0], u.String(), owner).Scan(&id, &updatedAt)
+		if isShortIDCollision(err) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("cannot fetch conflict url: %w", err)
+		}
+
+		if updatedAt != nil && !updatedAt.IsZero() {
+			err = ErrConflict
+		}
+		return id, err
 	}
+	return "", ErrIDCollision
+}
 
-	id = fmt.Sprint(lid)
-	if updatedAt != nil && !updatedAt.IsZero() {
-		err = ErrConflict
+func (r *RDB) saveLink(ctx context.Context, owner interface{}, link Link) (id string, err error) {
+	switch {
+	case link.Alias != "":
+		return r.saveAlias(ctx, r.db, owner, link)
+	case !link.ExpiresAt.IsZero():
+		return r.saveExpiring(ctx, owner, link)
+	default:
+		return r.save(ctx, owner, link.URL)
 	}
-	return
 }
 
-func (r *RDB) SaveUserBatch(ctx context.Context, uid uuid.UUID, urls []*url.URL) (ids []string, err error) {
-	var args []interface{}
-	uidPos := len(urls) + 1
+// saveAlias inserts URL under user chosen short ID
+func (r *RDB) saveAlias(ctx context.Context, q queryer, owner interface{}, link Link) (id string, err error) {
+	query := `
+		INSERT INTO urls
+		    (short_id, original_url, user_id, is_alias, expires_at)
+		VALUES
+		    ($1, $2, $3, true, $4)
+		ON CONFLICT (short_id) DO NOTHING
+		RETURNING short_id
+	`
 
-	var insertValues string
-	for i, u := range urls {
-		if i > 0 {
-			insertValues += ","
+	err = q.QueryRowContext(ctx, query, link.Alias, link.URL.String(), owner, nullTime(link.ExpiresAt)).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return link.Alias, ErrConflict
+	}
+	if err != nil {
+		return "", fmt.Errorf("cannot insert alias: %w", err)
+	}
+	return id, nil
+}
+
+// saveExpiring inserts URL under generated short ID even if URL is already stored
+func (r *RDB) saveExpiring(ctx context.Context, owner interface{}, link Link) (id string, err error) {
+	for i := 0; i < maxIDAttempts; i++ {
+		id, err = r.insertExpiring(ctx, r.db, owner, link)
+		if isShortIDCollision(err) {
+			continue
 		}
-		insertValues += fmt.Sprintf("($%d, $%d)", i+1, uidPos)
-		args = append(args, u.String())
+		return id, err
 	}
-	args = append(args, uid)
+	return "", ErrIDCollision
+}
 
-	query := `INSERT INTO urls (original_url, user_id) VALUES ` + insertValues + ` RETURNING id;`
+// insertExpiring is a single attempt of saveExpiring, short ID collision is returned as is
+func (r *RDB) insertExpiring(ctx context.Context, q queryer, owner interface{}, link Link) (id string, err error) {
+	query := `
+		INSERT INTO urls
+		    (id, short_id, original_url, user_id, expires_at)
+		VALUES
+		    ($1, $2, $3, $4, $5)
+	`
 
-	rows, err := r.db.QueryContext(ctx, query, args...)
+	seqs, shortIDs, err := r.nextIDs(ctx, q, 1)
 	if err != nil {
-		return nil, fmt.Errorf("query error: %w", err)
+		return "", err
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var id int64
-		if err := rows.Scan(&id); err != nil {
-			return nil, fmt.Errorf("error scanning row: %w", err)
+	_, err = q.ExecContext(ctx, query, seqs[0], shortIDs[0], link.URL.String(), owner, link.ExpiresAt)
+	if isShortIDCollision(err) {
+		return "", err
+	}
+	if err != nil {
+		return "", fmt.Errorf("cannot insert expiring url: %w", err)
+	}
+	return shortIDs[0], nil
+}
+
+// saveBatch inserts unique URLs within a single transaction, already stored URLs keep their IDs
+func (r *RDB) saveBatch(ctx context.Context, uid *uuid.UUID, urls []*url.URL) (ids []string, err error) {
+	var owner interface{}
+	if uid != nil {
+		owner = *uid
+	}
+
+	ids, _, err = r.saveURLs(ctx, owner, urls)
+	return ids, err
+}
+
+// saveURLs is saveBatch which also tells which of URLs got new ID,
+// repeated URL is created only at its first occurrence
+func (r *RDB) saveURLs(ctx context.Context, owner interface{}, urls []*url.URL) (ids []string, created []bool, err error) {
+	if len(urls) == 0 {
+		return nil, nil, nil
+	}
+
+	// the same row cannot be upserted twice by one statement
+	seen := make(map[string]bool, len(urls))
+	var unique []string
+	for _, u := range urls {
+		rawURL := u.String()
+		if !seen[rawURL] {
+			seen[rawURL] = true
+			unique = append(unique, rawURL)
 		}
-		ids = append(ids, fmt.Sprint(id))
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("cursor error: %w", err)
+	for i := 0; i < maxIDAttempts; i++ {
+		saved, fresh, err := r.insertChunks(ctx, owner, unique)
+		if isShortIDCollision(err) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, u := range urls {
+			rawURL := u.String()
+			id, ok := saved[rawURL]
+			if !ok {
+				return nil, nil, errors.New("not all URLs have been saved")
+			}
+			ids = append(ids, id)
+			created = append(created, fresh[rawURL])
+			fresh[rawURL] = false
+		}
+		return ids, created, nil
 	}
+	return nil, nil, ErrIDCollision
+}
 
-	if len(ids) != len(urls) {
-		return nil, errors.New("not all URLs have been saved")
+// saveLinks inserts links without alias or expiry as a batch, the rest one by one
+func (r *RDB) saveLinks(ctx context.Context, owner interface{}, links []Link) []SaveResult {
+	results := make([]SaveResult, len(links))
+	var urls []*url.URL
+	var plain []int
+	for n, link := range links {
+		if link.Alias != "" || !link.ExpiresAt.IsZero() {
+			results[n].ID, results[n].Err = r.saveLink(ctx, owner, link)
+			continue
+		}
+		urls = append(urls, link.URL)
+		plain = append(plain, n)
+	}
+	if len(urls) == 0 {
+		return results
 	}
 
-	return ids, nil
+	ids, created, err := r.saveURLs(ctx, owner, urls)
+	for k, n := range plain {
+		switch {
+		case err != nil:
+			results[n].Err = err
+		case !created[k]:
+			results[n] = SaveResult{ID: ids[k], Err: ErrConflict}
+		default:
+			results[n].ID = ids[k]
+		}
+	}
+	return results
 }
 
-func (r *RDB) LoadUser(ctx context.Context, uid uuid.UUID, id string) (url *url.URL, err error) {
-	var rawURL string
-	var deletedAt *time.Time
-	query := `SELECT original_url, deleted_at FROM urls WHERE id = $1 AND user_id = $2;`
+// rdbBatch saves parts of batch within a single transaction
+type rdbBatch struct {
+	r     *RDB
+	tx    *sql.Tx
+	owner interface{}
+}
 
-	err = r.db.QueryRowContext(ctx, query, id, uid).Scan(&rawURL, &deletedAt)
+func (r *RDB) begin(ctx context.Context, owner interface{}) (LinkBatch, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, nil
+		return nil, fmt.Errorf("cannot start transaction: %w", err)
+	}
+	return &rdbBatch{r: r, tx: tx, owner: owner}, nil
+}
+
+// Save saves part under savepoint, so short ID collision retries the part alone
+func (b *rdbBatch) Save(ctx context.Context, links []Link) (ids []string, err error) {
+	for i := 0; i < maxIDAttempts; i++ {
+		if _, err := b.tx.ExecContext(ctx, "SAVEPOINT batch_part"); err != nil {
+			return nil, fmt.Errorf("cannot create savepoint: %w", err)
 		}
-		return nil, fmt.Errorf("cannot scan row: %w", err)
+		ids, err = b.save(ctx, links)
+		if isShortIDCollision(err) {
+			if _, err := b.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT batch_part"); err != nil {
+				return nil, fmt.Errorf("cannot roll back to savepoint: %w", err)
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if _, err := b.tx.ExecContext(ctx, "RELEASE SAVEPOINT batch_part"); err != nil {
+			return nil, fmt.Errorf("cannot release savepoint: %w", err)
+		}
+		return ids, nil
 	}
-	if deletedAt != nil {
-		return nil, ErrDeleted
+	return nil, ErrIDCollision
+}
+
+// save is a single attempt of Save, short ID collision is returned as is
+func (b *rdbBatch) save(ctx context.Context, links []Link) (ids []string, err error) {
+	ids = make([]string, len(links))
+	seen := make(map[string]bool)
+	var unique []string
+	for n, link := range links {
+		switch {
+		case link.Alias != "":
+			ids[n], err = b.r.saveAlias(ctx, b.tx, b.owner, link)
+			if errors.Is(err, ErrConflict) {
+				return nil, &LinkError{Index: n, Err: err}
+			}
+		case !link.ExpiresAt.IsZero():
+			ids[n], err = b.r.insertExpiring(ctx, b.tx, b.owner, link)
+		default:
+			// the same row cannot be upserted twice by one statement
+			if rawURL := link.URL.String(); !seen[rawURL] {
+				seen[rawURL] = true
+				unique = append(unique, rawURL)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	return url.Parse(rawURL)
+	saved := make(map[string]string, len(unique))
+	if err := b.r.insertURLs(ctx, b.tx, b.owner, unique, saved, make(map[string]bool, len(unique))); err != nil {
+		return nil, err
+	}
+	for n, link := range links {
+		if link.Alias != "" || !link.ExpiresAt.IsZero() {
+			continue
+		}
+		id, ok := saved[link.URL.String()]
+		if !ok {
+			return nil, errors.New("not all URLs have been saved")
+		}
+		ids[n] = id
+	}
+	return ids, nil
 }
 
-func (r *RDB) LoadUsers(ctx context.Context, uid uuid.UUID) (urls map[string]*url.URL, err error) {
-	query := `SELECT id, original_url FROM urls WHERE user_id = $1 AND deleted_at IS NULL;`
+func (b *rdbBatch) Commit(_ context.Context) error {
+	if err := b.tx.Commit(); err != nil {
+		return fmt.Errorf("cannot commit transaction: %w", err)
+	}
+	return nil
+}
 
-	rows, err := r.db.QueryContext(ctx, query, uid)
+func (b *rdbBatch) Rollback() error {
+	if err := b.tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+		return fmt.Errorf("cannot roll back transaction: %w", err)
+	}
+	return nil
+}
+
+// insertChunks inserts unique URLs by chunks within a single transaction,
+// so batch exceeding bind parameters limit is still saved entirely or not at all.
+// fresh tells which of URLs are inserted rather than already stored.
+func (r *RDB) insertChunks(ctx context.Context, owner interface{}, rawURLs []string) (saved map[string]string, fresh map[string]bool, err error) {
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, fmt.Errorf("cannot query rows: %w", err)
+		return nil, nil, fmt.Errorf("cannot start transaction: %w", err)
 	}
-	defer rows.Close()
+	defer tx.Rollback()
 
-	res := make(map[string]*url.URL)
-	for rows.Next() {
-		var id int64
-		var rawURL string
+	saved = make(map[string]string, len(rawURLs))
+	fresh = make(map[string]bool, len(rawURLs))
+	if err := r.insertURLs(ctx, tx, owner, rawURLs, saved, fresh); err != nil {
+		return nil, nil, err
+	}
 
-		if err := rows.Scan(&id, &rawURL); err != nil {
-			return nil, fmt.Errorf("cannot scan row: %w", err)
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("cannot commit transaction: %w", err)
+	}
+	return saved, fresh, nil
+}
+
+// insertURLs inserts unique URLs by chunks of configured size within given transaction
+func (r *RDB) insertURLs(ctx context.Context, tx *sql.Tx, owner interface{}, rawURLs []string, saved map[string]string, fresh map[string]bool) error {
+	for len(rawURLs) > 0 {
+		n := len(rawURLs)
+		if n > r.chunkSize {
+			n = r.chunkSize
 		}
-		u, err := url.Parse(rawURL)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse URL: %w", err)
+		if err := r.insertBatch(ctx, tx, owner, rawURLs[:n], saved, fresh); err != nil {
+			return err
 		}
+		rawURLs = rawURLs[n:]
+	}
+	return nil
+}
 
-		res[fmt.Sprint(id)] = u
+// insertBatch puts short IDs of given unique URLs to saved with a single statement
+func (r *RDB) insertBatch(ctx context.Context, tx *sql.Tx, owner interface{}, rawURLs []string, saved map[string]string, fresh map[string]bool) error {
+	seqs, shortIDs, err := r.nextIDs(ctx, tx, len(rawURLs))
+	if err != nil {
+		return err
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("rows error: %w", err)
+	args := []interface{}{owner}
+	var insertValues string
+	for i, rawURL := range rawURLs {
+		if i > 0 {
+			insertValues += ","
+		}
+		insertValues += fmt.Sprintf("($%d, $%d, $%d, $1)", len(args)+1, len(args)+2, len(args)+3)
+		args = append(args, seqs[i], shortIDs[i], rawURL)
 	}
 
-	return res, nil
+	query := `
+		INSERT INTO urls
+			(id, short_id, original_url, user_id)
+		VALUES ` + insertValues + `
+		ON CONFLICT (original_url) WHERE deleted_at IS NULL AND NOT is_alias AND expires_at IS NULL
+		DO UPDATE SET updated_at = NOW()
+		RETURNING short_id, original_url, updated_at
+	`
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("query error: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, rawURL string
+		var updatedAt *time.Time
+		if err := rows.Scan(&id, &rawURL, &updatedAt); err != nil {
+			return fmt.Errorf("error scanning row: %w", err)
+		}
+		saved[rawURL] = id
+		fresh[rawURL] = updatedAt == nil
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("cursor error: %w", err)
+	}
+	return nil
 }
 
-func (r *RDB) DeleteUsers(ctx context.Context, uid uuid.UUID, ids ...string) error {
-	arr := new(pgtype.VarcharArray)
-	if err := arr.Set(ids); err != nil {
-		return fmt.Errorf("cannot set ids to pg variable: %w", err)
+// nextIDs reserves n primary keys and generates short IDs from them
+func (r *RDB) nextIDs(ctx context.Context, q queryer, n int) (seqs []int64, shortIDs []string, err error) {
+	query := `SELECT nextval(pg_get_serial_sequence('urls', 'id')) FROM generate_series(1, $1);`
+
+	rows, err := q.QueryContext(ctx, query, n)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot reserve ids: %w", err)
 	}
+	defer rows.Close()
 
-	query := `UPDATE urls SET deleted_at = NOW() WHERE user_id = $1 AND id = ANY($2);`
-	_, err := r.db.ExecContext(ctx, query, uid, arr)
-	return err
+	for rows.Next() {
+		var seq int64
+		if err := rows.Scan(&seq); err != nil {
+			return nil, nil, fmt.Errorf("cannot scan id: %w", err)
+		}
+		shortID, err := r.gen.Generate(uint64(seq))
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot generate id: %w", err)
+		}
+		seqs = append(seqs, seq)
+		shortIDs = append(shortIDs, shortID)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("cursor error: %w", err)
+	}
+	return seqs, shortIDs, nil
 }
 
-func (r *RDB) Ping(ctx context.Context) error {
-	return r.db.PingContext(ctx)
+// isShortIDCollision reports whether generated short ID is already taken
+func isShortIDCollision(err error) bool {
+	var pgErr pgx.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "short_id_idx"
 }
 
-func (r *RDB) Close() error {
-	return r.db.Close()
+// nullTime maps zero time to SQL NULL
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
 }
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"io"
 	"net/url"
+	"time"
 
 	"github.com/gofrs/uuid"
 )
@@ -13,6 +14,62 @@ var (
 	ErrDeleted = errors.New("record deleted")
 )
 
+// Link is a URL to be shortened along with its optional settings
+type Link struct {
+	URL *url.URL
+	// Alias is a user chosen short ID, it is generated if empty
+	Alias string
+	// ExpiresAt is a moment link stops working, zero value means never.
+	// Expiring links are never deduplicated by URL.
+	ExpiresAt time.Time
+}
+
+// SaveResult is an outcome of saving single link of batch
+type SaveResult struct {
+	ID string
+	// Err is ErrConflict along with existing ID if URL is already stored or alias is taken
+	Err error
+}
+
+// Visit is a single redirect through short link
+type Visit struct {
+	ID        string
+	At        time.Time
+	Referrer  string
+	UserAgent string
+	// IPHash is a keyed hash of client IP, raw addresses are never stored
+	IPHash string
+}
+
+// Stats is a summary of short link visits
+type Stats struct {
+	Total int64
+	// Daily holds visit counts per UTC day ordered by day
+	Daily []DailyVisits
+}
+
+type DailyVisits struct {
+	Day   time.Time
+	Count int64
+}
+
+// DeleteRequest asks to delete IDs owned by user, IDs of other users are ignored
+type DeleteRequest struct {
+	UID uuid.UUID
+	IDs []string
+}
+
+// LinkBatch saves links of a single batch part by part, so large batch is never held in memory.
+// Links stay invisible to others until Commit, batch which is not committed leaves nothing behind.
+type LinkBatch interface {
+	// Save saves next part of batch, already stored URLs keep their IDs.
+	// Taken alias is reported with *LinkError pointing to link of the part.
+	Save(ctx context.Context, links []Link) (ids []string, err error)
+	Commit(ctx context.Context) error
+	// Rollback discards batch, it does nothing once batch is committed
+	Rollback() error
+}
+
 type Store interface {
 	io.Closer
 
@@ -35,4 +92,34 @@ type AuthStore interface {
 	LoadUser(ctx context.Context, uid uuid.UUID, id string) (url *url.URL, err error)
 	LoadUsers(ctx context.Context, uid uuid.UUID) (urls map[string]*url.URL, err error)
 	DeleteUsers(ctx context.Context, uid uuid.UUID, ids ...string) error
+	// DeleteBatch applies requests of many users at once
+	DeleteBatch(ctx context.Context, reqs []DeleteRequest) error
+
+	// SaveLink and SaveUserLink save link under its alias if any.
+	// Taken alias is reported with ErrConflict.
+	SaveLink(ctx context.Context, link Link) (id string, err error)
+	SaveUserLink(ctx context.Context, uid uuid.UUID, link Link) (id string, err error)
+	// SaveLinks and SaveUserLinks save every link independently as SaveLink does,
+	// failure of single link is reported in its result and does not affect others
+	SaveLinks(ctx context.Context, links []Link) (results []SaveResult, err error)
+	SaveUserLinks(ctx context.Context, uid uuid.UUID, links []Link) (results []SaveResult, err error)
+	// BeginLinks and BeginUserLinks start batch which saves either all its links or none of them
+	BeginLinks(ctx context.Context) (LinkBatch, error)
+	BeginUserLinks(ctx context.Context, uid uuid.UUID) (LinkBatch, error)
+
+	// PurgeExpired forgets links expired before given moment, so their IDs may be reused
+	PurgeExpired(ctx context.Context, before time.Time) (n int, err error)
+
+	SaveVisits(ctx context.Context, visits []Visit) error
+	// LoadStats returns empty stats for IDs never visited
+	LoadStats(ctx context.Context, id string) (stats Stats, err error)
+
+	// SaveAPIKey reports taken key ID or hash with ErrConflict
+	SaveAPIKey(ctx context.Context, key APIKey) error
+	// LoadAPIKey finds key by token hash, revoked key is reported with ErrDeleted
+	LoadAPIKey(ctx context.Context, hash string) (key APIKey, err error)
+	// LoadAPIKeys returns keys of user in creation order without revoked ones
+	LoadAPIKeys(ctx context.Context, uid uuid.UUID) (keys []APIKey, err error)
+	// RevokeAPIKey reports missing, foreign or already revoked key with ErrNotFound
+	RevokeAPIKey(ctx context.Context, uid uuid.UUID, id string) error
 }
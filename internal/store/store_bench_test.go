@@ -0,0 +1,54 @@
+package store_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/store"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/store/storetest"
+)
+
+func BenchmarkInMemory(b *testing.B) {
+	storetest.Bench(b, func(b *testing.B) store.AuthStore {
+		return store.NewInMemory()
+	})
+}
+
+func BenchmarkFileStore(b *testing.B) {
+	storetest.Bench(b, func(b *testing.B) store.AuthStore {
+		fs, err := store.NewFileStore(filepath.Join(b.TempDir(), "store.log"))
+		if err != nil {
+			b.Fatal(err)
+		}
+		return fs
+	})
+}
+
+// BenchmarkRDB runs against throwaway database given by TEST_DATABASE_DSN
+func BenchmarkRDB(b *testing.B) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		b.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	storetest.Bench(b, func(b *testing.B) store.AuthStore {
+		ctx := context.Background()
+
+		db, err := sql.Open("pgx", dsn)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := db.ExecContext(ctx, `DROP TABLE IF EXISTS urls, visits, api_keys;`); err != nil {
+			b.Fatal(err)
+		}
+
+		rdb := store.NewRDB(db)
+		if err := rdb.Bootstrap(ctx); err != nil {
+			b.Fatal(err)
+		}
+		return rdb
+	})
+}
@@ -0,0 +1,70 @@
+package store_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/jackc/pgx/stdlib"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/store"
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/store/storetest"
+)
+
+func TestInMemory(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.AuthStore {
+		return store.NewInMemory()
+	})
+}
+
+func TestFileStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.AuthStore {
+		fs, err := store.NewFileStore(filepath.Join(t.TempDir(), "store.log"))
+		require.NoError(t, err)
+		return fs
+	})
+}
+
+// TestRDB runs against throwaway database given by TEST_DATABASE_DSN,
+// its tables are dropped before every test case
+func TestRDB(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	storetest.Run(t, func(t *testing.T) store.AuthStore {
+		ctx := context.Background()
+
+		db, err := sql.Open("pgx", dsn)
+		require.NoError(t, err)
+
+		_, err = db.ExecContext(ctx, `DROP TABLE IF EXISTS urls, visits, api_keys;`)
+		require.NoError(t, err)
+
+		// tiny chunks make every batch span several statements
+		rdb := store.NewRDB(db, store.WithInsertChunkSize(2))
+		require.NoError(t, rdb.Bootstrap(ctx))
+		return rdb
+	})
+}
+
+func TestInMemory_randomIDs(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.AuthStore {
+		gen, err := store.NewRandomGenerator(4)
+		require.NoError(t, err)
+		return store.NewInMemory(store.WithIDGenerator(gen))
+	})
+}
+
+func TestFileStore_hashids(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.AuthStore {
+		gen := store.NewHashidsGenerator("ololo", 6)
+		fs, err := store.NewFileStore(filepath.Join(t.TempDir(), "store.log"), store.WithIDGenerator(gen))
+		require.NoError(t, err)
+		return fs
+	})
+}
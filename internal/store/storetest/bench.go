@@ -0,0 +1,236 @@
+package storetest
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/store"
+)
+
+// BenchFactory returns empty store instance, it is closed by the suite
+type BenchFactory func(b *testing.B) store.AuthStore
+
+// BenchSizes are numbers of links stores are filled with before measurement
+var BenchSizes = []int{100, 10000}
+
+const (
+	// benchUsers share seeded links evenly
+	benchUsers     = 10
+	benchBatchSize = 100
+)
+
+// dataset describes links seeded into store
+type dataset struct {
+	uids []uuid.UUID
+	// ids[n] are IDs of links owned by uids[n]
+	ids     [][]string
+	keyHash string
+}
+
+func (ds dataset) user(i int) (uuid.UUID, []string) {
+	n := i % len(ds.uids)
+	return ds.uids[n], ds.ids[n]
+}
+
+type benchCase struct {
+	name string
+	// op performs i-th operation, i is unique within benchmark run
+	op func(ctx context.Context, s store.AuthStore, ds dataset, i int) error
+}
+
+var benchCases = []benchCase{
+	{name: "Save", op: func(ctx context.Context, s store.AuthStore, _ dataset, i int) error {
+		_, err := s.Save(ctx, benchURL("save", i))
+		return err
+	}},
+	{name: "SaveBatch", op: func(ctx context.Context, s store.AuthStore, _ dataset, i int) error {
+		_, err := s.SaveBatch(ctx, benchURLs("batch", i))
+		return err
+	}},
+	{name: "SaveUser", op: func(ctx context.Context, s store.AuthStore, ds dataset, i int) error {
+		uid, _ := ds.user(i)
+		_, err := s.SaveUser(ctx, uid, benchURL("user", i))
+		return err
+	}},
+	{name: "SaveUserBatch", op: func(ctx context.Context, s store.AuthStore, ds dataset, i int) error {
+		uid, _ := ds.user(i)
+		_, err := s.SaveUserBatch(ctx, uid, benchURLs("userbatch", i))
+		return err
+	}},
+	{name: "Load", op: func(ctx context.Context, s store.AuthStore, ds dataset, i int) error {
+		_, ids := ds.user(i)
+		_, err := s.Load(ctx, ids[i%len(ids)])
+		return err
+	}},
+	{name: "LoadUser", op: func(ctx context.Context, s store.AuthStore, ds dataset, i int) error {
+		uid, ids := ds.user(i)
+		_, err := s.LoadUser(ctx, uid, ids[i%len(ids)])
+		return err
+	}},
+	{name: "LoadUsers", op: func(ctx context.Context, s store.AuthStore, ds dataset, i int) error {
+		uid, _ := ds.user(i)
+		_, err := s.LoadUsers(ctx, uid)
+		return err
+	}},
+	{name: "DeleteUsers", op: func(ctx context.Context, s store.AuthStore, ds dataset, i int) error {
+		uid, ids := ds.user(i)
+		return s.DeleteUsers(ctx, uid, ids[i%len(ids)])
+	}},
+	{name: "SaveVisits", op: func(ctx context.Context, s store.AuthStore, ds dataset, i int) error {
+		_, ids := ds.user(i)
+		return s.SaveVisits(ctx, benchVisits(ids[i%len(ids)]))
+	}},
+	{name: "LoadStats", op: func(ctx context.Context, s store.AuthStore, ds dataset, i int) error {
+		_, ids := ds.user(i)
+		_, err := s.LoadStats(ctx, ids[0])
+		return err
+	}},
+	{name: "LoadAPIKey", op: func(ctx context.Context, s store.AuthStore, ds dataset, _ int) error {
+		_, err := s.LoadAPIKey(ctx, ds.keyHash)
+		return err
+	}},
+}
+
+// Bench measures every store method on datasets of BenchSizes,
+// each one is run both serially and in parallel, parallelism is set by -cpu flag
+func Bench(b *testing.B, newStore BenchFactory) {
+	for _, size := range BenchSizes {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			for _, bc := range benchCases {
+				b.Run(bc.name, func(b *testing.B) {
+					benchSerial(b, newStore, size, bc)
+				})
+				b.Run(bc.name+"/parallel", func(b *testing.B) {
+					benchParallel(b, newStore, size, bc)
+				})
+			}
+		})
+	}
+}
+
+func benchSerial(b *testing.B, newStore BenchFactory, size int, bc benchCase) {
+	ctx := context.Background()
+	s := newStore(b)
+	defer closeStore(b, s)
+	ds := seed(b, s, size)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := bc.op(ctx, s, ds, i); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchParallel(b *testing.B, newStore BenchFactory, size int, bc benchCase) {
+	ctx := context.Background()
+	s := newStore(b)
+	defer closeStore(b, s)
+	ds := seed(b, s, size)
+
+	var next int64
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := int(atomic.AddInt64(&next, 1))
+			if err := bc.op(ctx, s, ds, i); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+// closeStore keeps closing, e.g. final compaction, out of measurement
+func closeStore(b *testing.B, s store.AuthStore) {
+	b.StopTimer()
+	if err := s.Close(); err != nil {
+		b.Error(err)
+	}
+}
+
+// seed fills store with size links of benchUsers users, visits of their first links and an API key
+func seed(b *testing.B, s store.AuthStore, size int) dataset {
+	ctx := context.Background()
+	ds := dataset{
+		uids: make([]uuid.UUID, benchUsers),
+		ids:  make([][]string, benchUsers),
+	}
+
+	perUser := size / benchUsers
+	for n := range ds.uids {
+		ds.uids[n] = uuid.Must(uuid.NewV4())
+
+		urls := make([]*url.URL, 0, perUser)
+		for i := 0; i < perUser; i++ {
+			urls = append(urls, benchURL("seed-"+ds.uids[n].String(), i))
+		}
+		// batches keep seeding of durable stores fast
+		for len(urls) > 0 {
+			chunk := urls
+			if len(chunk) > 1000 {
+				chunk = chunk[:1000]
+			}
+			ids, err := s.SaveUserBatch(ctx, ds.uids[n], chunk)
+			if err != nil {
+				b.Fatalf("cannot seed store: %s", err)
+			}
+			ds.ids[n] = append(ds.ids[n], ids...)
+			urls = urls[len(chunk):]
+		}
+
+		if err := s.SaveVisits(ctx, benchVisits(ds.ids[n][0])); err != nil {
+			b.Fatalf("cannot seed visits: %s", err)
+		}
+	}
+
+	ds.keyHash = fmt.Sprintf("%064x", 42)
+	err := s.SaveAPIKey(ctx, store.APIKey{
+		ID:        uuid.Must(uuid.NewV4()).String(),
+		UID:       ds.uids[0],
+		Name:      "bench",
+		Hash:      ds.keyHash,
+		CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+	})
+	if err != nil {
+		b.Fatalf("cannot seed API key: %s", err)
+	}
+	return ds
+}
+
+func benchURL(kind string, i int) *url.URL {
+	return &url.URL{Scheme: "https", Host: kind + ".example.com", Path: "/" + strconv.Itoa(i)}
+}
+
+// benchURLs returns i-th batch of distinct URLs
+func benchURLs(kind string, i int) []*url.URL {
+	urls := make([]*url.URL, benchBatchSize)
+	for n := range urls {
+		urls[n] = benchURL(kind, i*benchBatchSize+n)
+	}
+	return urls
+}
+
+func benchVisits(id string) []store.Visit {
+	visits := make([]store.Visit, benchBatchSize)
+	at := time.Now()
+	for n := range visits {
+		visits[n] = store.Visit{
+			ID:        id,
+			At:        at.Add(-time.Duration(n) * time.Hour),
+			Referrer:  "https://example.com/",
+			UserAgent: "bench",
+			IPHash:    strconv.Itoa(n),
+		}
+	}
+	return visits
+}
@@ -0,0 +1,598 @@
+// Package storetest provides conformance tests which every store.AuthStore implementation must pass
+package storetest
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Yandex-Praktikum/go-profilable-shortener/internal/store"
+)
+
+// Factory returns empty store instance, it is closed by the suite
+type Factory func(t *testing.T) store.AuthStore
+
+// Run checks that store built by newStore follows store.AuthStore contracts
+func Run(t *testing.T, newStore Factory) {
+	testCases := []struct {
+		name string
+		test func(t *testing.T, s store.AuthStore)
+	}{
+		{name: "load_missing", test: testLoadMissing},
+		{name: "save_and_load", test: testSaveAndLoad},
+		{name: "save_conflict", test: testSaveConflict},
+		{name: "batch_order", test: testBatchOrder},
+		{name: "user_batch_order", test: testUserBatchOrder},
+		{name: "large_batch", test: testLargeBatch},
+		{name: "unknown_user", test: testUnknownUser},
+		{name: "ownership", test: testOwnership},
+		{name: "deleted", test: testDeleted},
+		{name: "delete_batch", test: testDeleteBatch},
+		{name: "alias", test: testAlias},
+		{name: "save_links", test: testSaveLinks},
+		{name: "link_batch", test: testLinkBatch},
+		{name: "expiry", test: testExpiry},
+		{name: "stats", test: testStats},
+		{name: "api_keys", test: testAPIKeys},
+		{name: "ping", test: testPing},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newStore(t)
+			defer func() {
+				assert.NoError(t, s.Close())
+			}()
+			tc.test(t, s)
+		})
+	}
+}
+
+func newURL(t *testing.T, path string) *url.URL {
+	u, err := url.Parse(fmt.Sprintf("https://%s.example.com/%s", uuid.Must(uuid.NewV4()), path))
+	require.NoError(t, err)
+	return u
+}
+
+func testLoadMissing(t *testing.T, s store.AuthStore) {
+	ctx := context.Background()
+	uid := uuid.Must(uuid.NewV4())
+
+	_, err := s.Load(ctx, "missing")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	_, err = s.Load(ctx, "999999")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	_, err = s.LoadUser(ctx, uid, "missing")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+}
+
+func testSaveAndLoad(t *testing.T, s store.AuthStore) {
+	ctx := context.Background()
+	uid := uuid.Must(uuid.NewV4())
+	anonURL := newURL(t, "anon")
+	userURL := newURL(t, "user")
+
+	anonID, err := s.Save(ctx, anonURL)
+	require.NoError(t, err)
+	userID, err := s.SaveUser(ctx, uid, userURL)
+	require.NoError(t, err)
+	assert.NotEqual(t, anonID, userID)
+
+	u, err := s.Load(ctx, anonID)
+	require.NoError(t, err)
+	assert.Equal(t, anonURL.String(), u.String())
+
+	u, err = s.Load(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, userURL.String(), u.String())
+
+	u, err = s.LoadUser(ctx, uid, userID)
+	require.NoError(t, err)
+	assert.Equal(t, userURL.String(), u.String())
+
+	// anonymous URLs are not owned by anyone
+	_, err = s.LoadUser(ctx, uid, anonID)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	urls, err := s.LoadUsers(ctx, uid)
+	require.NoError(t, err)
+	require.Len(t, urls, 1)
+	assert.Equal(t, userURL.String(), urls[userID].String())
+}
+
+func testSaveConflict(t *testing.T, s store.AuthStore) {
+	ctx := context.Background()
+	u := newURL(t, "conflict")
+
+	id, err := s.Save(ctx, u)
+	require.NoError(t, err)
+
+	dupID, err := s.Save(ctx, u)
+	assert.ErrorIs(t, err, store.ErrConflict)
+	assert.Equal(t, id, dupID)
+
+	dupID, err = s.SaveUser(ctx, uuid.Must(uuid.NewV4()), u)
+	assert.ErrorIs(t, err, store.ErrConflict)
+	assert.Equal(t, id, dupID)
+}
+
+func testBatchOrder(t *testing.T, s store.AuthStore) {
+	ctx := context.Background()
+	existing := newURL(t, "existing")
+	existingID, err := s.Save(ctx, existing)
+	require.NoError(t, err)
+
+	urls := []*url.URL{newURL(t, "a"), existing, newURL(t, "b"), newURL(t, "c")}
+	ids, err := s.SaveBatch(ctx, urls)
+	require.NoError(t, err)
+	require.Len(t, ids, len(urls))
+
+	// already stored URLs keep their IDs without failing the batch
+	assert.Equal(t, existingID, ids[1])
+
+	for i, id := range ids {
+		u, err := s.Load(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, urls[i].String(), u.String())
+	}
+}
+
+func testUserBatchOrder(t *testing.T, s store.AuthStore) {
+	ctx := context.Background()
+	uid := uuid.Must(uuid.NewV4())
+
+	urls := []*url.URL{newURL(t, "a"), newURL(t, "b"), newURL(t, "c")}
+	ids, err := s.SaveUserBatch(ctx, uid, urls)
+	require.NoError(t, err)
+	require.Len(t, ids, len(urls))
+
+	saved, err := s.LoadUsers(ctx, uid)
+	require.NoError(t, err)
+	assert.Len(t, saved, len(urls))
+
+	for i, id := range ids {
+		u, err := s.LoadUser(ctx, uid, id)
+		require.NoError(t, err)
+		assert.Equal(t, urls[i].String(), u.String())
+		assert.Equal(t, urls[i].String(), saved[id].String())
+	}
+}
+
+// testLargeBatch saves batch needing more bind parameters than single statement may have
+func testLargeBatch(t *testing.T, s store.AuthStore) {
+	ctx := context.Background()
+	uid := uuid.Must(uuid.NewV4())
+
+	urls := make([]*url.URL, 25000)
+	for i := range urls {
+		urls[i] = &url.URL{Scheme: "https", Host: "large.example", Path: fmt.Sprintf("/%d", i)}
+	}
+	ids, err := s.SaveUserBatch(ctx, uid, urls)
+	require.NoError(t, err)
+	require.Len(t, ids, len(urls))
+
+	for _, i := range []int{0, len(urls) / 2, len(urls) - 1} {
+		u, err := s.LoadUser(ctx, uid, ids[i])
+		require.NoError(t, err)
+		assert.Equal(t, urls[i].String(), u.String())
+	}
+	saved, err := s.LoadUsers(ctx, uid)
+	require.NoError(t, err)
+	assert.Len(t, saved, len(urls))
+}
+
+func testUnknownUser(t *testing.T, s store.AuthStore) {
+	ctx := context.Background()
+	uid := uuid.Must(uuid.NewV4())
+
+	urls, err := s.LoadUsers(ctx, uid)
+	require.NoError(t, err)
+	assert.Empty(t, urls)
+
+	err = s.DeleteUsers(ctx, uid, "missing")
+	assert.NoError(t, err)
+}
+
+func testOwnership(t *testing.T, s store.AuthStore) {
+	ctx := context.Background()
+	owner := uuid.Must(uuid.NewV4())
+	stranger := uuid.Must(uuid.NewV4())
+	u := newURL(t, "owned")
+
+	id, err := s.SaveUser(ctx, owner, u)
+	require.NoError(t, err)
+	_, err = s.SaveUser(ctx, stranger, newURL(t, "stranger"))
+	require.NoError(t, err)
+
+	_, err = s.LoadUser(ctx, stranger, id)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	// deletion of foreign URLs is silently ignored
+	err = s.DeleteUsers(ctx, stranger, id)
+	require.NoError(t, err)
+
+	loaded, err := s.Load(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, u.String(), loaded.String())
+
+	urls, err := s.LoadUsers(ctx, stranger)
+	require.NoError(t, err)
+	assert.Len(t, urls, 1)
+	assert.NotContains(t, urls, id)
+}
+
+func testDeleted(t *testing.T, s store.AuthStore) {
+	ctx := context.Background()
+	uid := uuid.Must(uuid.NewV4())
+	u := newURL(t, "deleted")
+	kept := newURL(t, "kept")
+
+	ids, err := s.SaveUserBatch(ctx, uid, []*url.URL{u, kept})
+	require.NoError(t, err)
+
+	err = s.DeleteUsers(ctx, uid, ids[0], "missing")
+	require.NoError(t, err)
+
+	_, err = s.Load(ctx, ids[0])
+	assert.ErrorIs(t, err, store.ErrDeleted)
+
+	_, err = s.LoadUser(ctx, uid, ids[0])
+	assert.ErrorIs(t, err, store.ErrDeleted)
+
+	urls, err := s.LoadUsers(ctx, uid)
+	require.NoError(t, err)
+	assert.Len(t, urls, 1)
+	assert.Contains(t, urls, ids[1])
+
+	// deleted URL may be shortened again
+	id, err := s.SaveUser(ctx, uid, u)
+	require.NoError(t, err)
+	assert.NotEqual(t, ids[0], id)
+}
+
+func testDeleteBatch(t *testing.T, s store.AuthStore) {
+	ctx := context.Background()
+	alice := uuid.Must(uuid.NewV4())
+	bob := uuid.Must(uuid.NewV4())
+
+	aliceIDs, err := s.SaveUserBatch(ctx, alice, []*url.URL{newURL(t, "a1"), newURL(t, "a2")})
+	require.NoError(t, err)
+	bobIDs, err := s.SaveUserBatch(ctx, bob, []*url.URL{newURL(t, "b1"), newURL(t, "b2")})
+	require.NoError(t, err)
+
+	err = s.DeleteBatch(ctx, []store.DeleteRequest{
+		{UID: alice, IDs: []string{aliceIDs[0], bobIDs[1]}},
+		{UID: bob, IDs: []string{bobIDs[0], bobIDs[0], "missing"}},
+	})
+	require.NoError(t, err)
+
+	for _, id := range []string{aliceIDs[0], bobIDs[0]} {
+		_, err = s.Load(ctx, id)
+		assert.ErrorIs(t, err, store.ErrDeleted)
+	}
+	// IDs of other users are left intact
+	for _, id := range []string{aliceIDs[1], bobIDs[1]} {
+		_, err = s.Load(ctx, id)
+		assert.NoError(t, err)
+	}
+}
+
+func testPing(t *testing.T, s store.AuthStore) {
+	assert.NoError(t, s.Ping(context.Background()))
+}
+
+func testAlias(t *testing.T, s store.AuthStore) {
+	ctx := context.Background()
+	uid := uuid.Must(uuid.NewV4())
+	u := newURL(t, "alias")
+	alias := "alias-" + uuid.Must(uuid.NewV4()).String()[:8]
+
+	generated, err := s.SaveUser(ctx, uid, u)
+	require.NoError(t, err)
+
+	// alias may point to already shortened URL
+	id, err := s.SaveUserLink(ctx, uid, store.Link{URL: u, Alias: alias})
+	require.NoError(t, err)
+	assert.Equal(t, alias, id)
+
+	loaded, err := s.Load(ctx, alias)
+	require.NoError(t, err)
+	assert.Equal(t, u.String(), loaded.String())
+
+	urls, err := s.LoadUsers(ctx, uid)
+	require.NoError(t, err)
+	assert.Len(t, urls, 2)
+
+	// taken alias is not overwritten
+	id, err = s.SaveLink(ctx, store.Link{URL: newURL(t, "other"), Alias: alias})
+	assert.ErrorIs(t, err, store.ErrConflict)
+	assert.Equal(t, alias, id)
+
+	loaded, err = s.Load(ctx, alias)
+	require.NoError(t, err)
+	assert.Equal(t, u.String(), loaded.String())
+
+	// generated ID of URL is not affected by its alias
+	id, err = s.SaveLink(ctx, store.Link{URL: u})
+	assert.ErrorIs(t, err, store.ErrConflict)
+	assert.Equal(t, generated, id)
+
+	require.NoError(t, s.DeleteUsers(ctx, uid, alias))
+	_, err = s.Load(ctx, alias)
+	assert.ErrorIs(t, err, store.ErrDeleted)
+	_, err = s.Load(ctx, generated)
+	assert.NoError(t, err)
+}
+
+func testSaveLinks(t *testing.T, s store.AuthStore) {
+	ctx := context.Background()
+	uid := uuid.Must(uuid.NewV4())
+	existing := newURL(t, "existing")
+	existingID, err := s.Save(ctx, existing)
+	require.NoError(t, err)
+	taken := "taken-" + uuid.Must(uuid.NewV4()).String()[:8]
+	_, err = s.SaveLink(ctx, store.Link{URL: existing, Alias: taken})
+	require.NoError(t, err)
+	alias := "free-" + uuid.Must(uuid.NewV4()).String()[:8]
+
+	fresh := newURL(t, "fresh")
+	links := []store.Link{
+		{URL: fresh},
+		{URL: existing},
+		{URL: newURL(t, "other"), Alias: taken},
+		{URL: newURL(t, "aliased"), Alias: alias},
+		{URL: existing, ExpiresAt: time.Now().Add(time.Hour)},
+		{URL: fresh},
+	}
+	results, err := s.SaveUserLinks(ctx, uid, links)
+	require.NoError(t, err)
+	require.Len(t, results, len(links))
+
+	// every link is saved on its own, conflicts do not fail the rest
+	assert.NoError(t, results[0].Err)
+	assert.ErrorIs(t, results[1].Err, store.ErrConflict)
+	assert.Equal(t, existingID, results[1].ID)
+	assert.ErrorIs(t, results[2].Err, store.ErrConflict)
+	assert.Equal(t, taken, results[2].ID)
+	assert.NoError(t, results[3].Err)
+	assert.Equal(t, alias, results[3].ID)
+	assert.NoError(t, results[4].Err)
+	assert.NotEqual(t, existingID, results[4].ID)
+	// repeated URL is created once
+	assert.ErrorIs(t, results[5].Err, store.ErrConflict)
+	assert.Equal(t, results[0].ID, results[5].ID)
+
+	for _, n := range []int{0, 3, 4} {
+		u, err := s.LoadUser(ctx, uid, results[n].ID)
+		require.NoError(t, err)
+		assert.Equal(t, links[n].URL.String(), u.String())
+	}
+	loaded, err := s.Load(ctx, taken)
+	require.NoError(t, err)
+	assert.Equal(t, existing.String(), loaded.String())
+
+	results, err = s.SaveLinks(ctx, []store.Link{{URL: fresh}, {URL: newURL(t, "anonymous")}})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.ErrorIs(t, results[0].Err, store.ErrConflict)
+	assert.NoError(t, results[1].Err)
+}
+
+// saveAll saves parts with a single batch of user links and commits it unless any part fails
+func saveAll(ctx context.Context, s store.AuthStore, uid uuid.UUID, parts ...[]store.Link) (ids []string, err error) {
+	b, err := s.BeginUserLinks(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Rollback()
+
+	for _, links := range parts {
+		partIDs, err := b.Save(ctx, links)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, partIDs...)
+	}
+	return ids, b.Commit(ctx)
+}
+
+func testLinkBatch(t *testing.T, s store.AuthStore) {
+	ctx := context.Background()
+	uid := uuid.Must(uuid.NewV4())
+	existing := newURL(t, "existing")
+	existingID, err := s.Save(ctx, existing)
+	require.NoError(t, err)
+	taken := "taken-" + uuid.Must(uuid.NewV4()).String()[:8]
+	_, err = s.SaveLink(ctx, store.Link{URL: existing, Alias: taken})
+	require.NoError(t, err)
+	alias := "free-" + uuid.Must(uuid.NewV4()).String()[:8]
+
+	// taken alias fails the whole batch, links of earlier parts are not saved either
+	fresh := newURL(t, "fresh")
+	_, err = saveAll(ctx, s, uid, []store.Link{
+		{URL: fresh},
+		{URL: newURL(t, "aliased"), Alias: alias},
+	}, []store.Link{
+		{URL: existing, ExpiresAt: time.Now().Add(time.Hour)},
+		{URL: newURL(t, "other"), Alias: taken},
+	})
+	assert.ErrorIs(t, err, store.ErrConflict)
+	var linkErr *store.LinkError
+	require.ErrorAs(t, err, &linkErr)
+	assert.Equal(t, 1, linkErr.Index)
+	_, err = s.Load(ctx, alias)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	urls, err := s.LoadUsers(ctx, uid)
+	require.NoError(t, err)
+	assert.Empty(t, urls)
+
+	// links are not visible until batch is committed
+	b, err := s.BeginUserLinks(ctx, uid)
+	require.NoError(t, err)
+	pending, err := b.Save(ctx, []store.Link{{URL: newURL(t, "pending")}})
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	require.NoError(t, b.Rollback())
+	_, err = s.Load(ctx, pending[0])
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	links := []store.Link{
+		{URL: fresh},
+		{URL: existing},
+		{URL: newURL(t, "aliased"), Alias: alias},
+		{URL: existing, ExpiresAt: time.Now().Add(time.Hour)},
+		{URL: fresh},
+	}
+	ids, err := saveAll(ctx, s, uid, links[:3], links[3:])
+	require.NoError(t, err)
+	require.Len(t, ids, len(links))
+
+	// already stored and repeated URLs keep their IDs, even across parts
+	assert.Equal(t, existingID, ids[1])
+	assert.Equal(t, alias, ids[2])
+	assert.NotEqual(t, existingID, ids[3])
+	assert.Equal(t, ids[0], ids[4])
+	for _, n := range []int{0, 2, 3} {
+		u, err := s.LoadUser(ctx, uid, ids[n])
+		require.NoError(t, err)
+		assert.Equal(t, links[n].URL.String(), u.String())
+	}
+
+	// repeated alias conflicts with itself
+	again := "again-" + uuid.Must(uuid.NewV4()).String()[:8]
+	_, err = saveAll(ctx, s, uid, []store.Link{{URL: newURL(t, "a"), Alias: again}}, []store.Link{{URL: newURL(t, "b"), Alias: again}})
+	require.ErrorAs(t, err, &linkErr)
+	assert.Equal(t, 0, linkErr.Index)
+	_, err = s.Load(ctx, again)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+}
+
+func testExpiry(t *testing.T, s store.AuthStore) {
+	ctx := context.Background()
+	uid := uuid.Must(uuid.NewV4())
+	u := newURL(t, "expiry")
+	alias := "expired-" + uuid.Must(uuid.NewV4()).String()[:8]
+
+	expired, err := s.SaveUserLink(ctx, uid, store.Link{URL: u, ExpiresAt: time.Now().Add(-time.Hour)})
+	require.NoError(t, err)
+	_, err = s.SaveUserLink(ctx, uid, store.Link{URL: u, Alias: alias, ExpiresAt: time.Now().Add(-time.Minute)})
+	require.NoError(t, err)
+	live, err := s.SaveUserLink(ctx, uid, store.Link{URL: u, ExpiresAt: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+	assert.NotEqual(t, expired, live)
+
+	// expiring links do not hold URL from being shortened permanently
+	permanent, err := s.SaveUser(ctx, uid, u)
+	require.NoError(t, err)
+	assert.NotEqual(t, live, permanent)
+
+	_, err = s.Load(ctx, expired)
+	assert.ErrorIs(t, err, store.ErrExpired)
+	_, err = s.LoadUser(ctx, uid, alias)
+	assert.ErrorIs(t, err, store.ErrExpired)
+	_, err = s.Load(ctx, live)
+	assert.NoError(t, err)
+
+	urls, err := s.LoadUsers(ctx, uid)
+	require.NoError(t, err)
+	assert.Len(t, urls, 2)
+	assert.Contains(t, urls, live)
+	assert.Contains(t, urls, permanent)
+
+	n, err := s.PurgeExpired(ctx, time.Now().Add(-30*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	_, err = s.Load(ctx, expired)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	_, err = s.Load(ctx, alias)
+	assert.ErrorIs(t, err, store.ErrExpired)
+
+	// purged alias may be taken again
+	n, err = s.PurgeExpired(ctx, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	id, err := s.SaveLink(ctx, store.Link{URL: newURL(t, "reused"), Alias: alias})
+	require.NoError(t, err)
+	assert.Equal(t, alias, id)
+}
+
+func testStats(t *testing.T, s store.AuthStore) {
+	ctx := context.Background()
+	id, err := s.Save(ctx, newURL(t, "stats"))
+	require.NoError(t, err)
+
+	stats, err := s.LoadStats(ctx, id)
+	require.NoError(t, err)
+	assert.Zero(t, stats.Total)
+	assert.Empty(t, stats.Daily)
+
+	day := time.Date(2021, time.March, 8, 0, 0, 0, 0, time.UTC)
+	visits := []store.Visit{
+		{ID: id, At: day.Add(26 * time.Hour), Referrer: "https://ya.ru/", UserAgent: "curl", IPHash: "a"},
+		{ID: id, At: day.Add(time.Hour), UserAgent: "curl", IPHash: "b"},
+		{ID: id, At: day.Add(23 * time.Hour), IPHash: "a"},
+		{ID: "other", At: day},
+	}
+	require.NoError(t, s.SaveVisits(ctx, visits))
+
+	stats, err = s.LoadStats(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), stats.Total)
+	require.Len(t, stats.Daily, 2)
+	assert.True(t, day.Equal(stats.Daily[0].Day), stats.Daily[0].Day)
+	assert.Equal(t, int64(2), stats.Daily[0].Count)
+	assert.True(t, day.AddDate(0, 0, 1).Equal(stats.Daily[1].Day), stats.Daily[1].Day)
+	assert.Equal(t, int64(1), stats.Daily[1].Count)
+}
+
+func testAPIKeys(t *testing.T, s store.AuthStore) {
+	ctx := context.Background()
+	owner := uuid.Must(uuid.NewV4())
+	stranger := uuid.Must(uuid.NewV4())
+	createdAt := time.Date(2021, time.March, 8, 12, 0, 0, 0, time.UTC)
+
+	ci := store.APIKey{ID: uuid.Must(uuid.NewV4()).String(), UID: owner, Name: "ci", Hash: uuid.Must(uuid.NewV4()).String(), CreatedAt: createdAt}
+	bot := store.APIKey{ID: uuid.Must(uuid.NewV4()).String(), UID: owner, Name: "bot", Hash: uuid.Must(uuid.NewV4()).String(), CreatedAt: createdAt.Add(time.Minute)}
+	require.NoError(t, s.SaveAPIKey(ctx, bot))
+	require.NoError(t, s.SaveAPIKey(ctx, ci))
+
+	dup := ci
+	dup.ID = uuid.Must(uuid.NewV4()).String()
+	assert.ErrorIs(t, s.SaveAPIKey(ctx, dup), store.ErrConflict)
+
+	_, err := s.LoadAPIKey(ctx, "missing")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	key, err := s.LoadAPIKey(ctx, ci.Hash)
+	require.NoError(t, err)
+	assert.Equal(t, owner, key.UID)
+	assert.Equal(t, "ci", key.Name)
+	assert.True(t, createdAt.Equal(key.CreatedAt), key.CreatedAt)
+
+	keys, err := s.LoadAPIKeys(ctx, owner)
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.Equal(t, ci.ID, keys[0].ID)
+	assert.Equal(t, bot.ID, keys[1].ID)
+
+	assert.ErrorIs(t, s.RevokeAPIKey(ctx, stranger, ci.ID), store.ErrNotFound)
+	require.NoError(t, s.RevokeAPIKey(ctx, owner, ci.ID))
+	assert.ErrorIs(t, s.RevokeAPIKey(ctx, owner, ci.ID), store.ErrNotFound)
+
+	_, err = s.LoadAPIKey(ctx, ci.Hash)
+	assert.ErrorIs(t, err, store.ErrDeleted)
+
+	keys, err = s.LoadAPIKeys(ctx, owner)
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.Equal(t, bot.ID, keys[0].ID)
+}
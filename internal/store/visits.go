@@ -0,0 +1,294 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// linkVisits aggregates visits of a single short ID by UTC day
+type linkVisits struct {
+	total int64
+	daily map[int64]int64
+}
+
+type visitShard struct {
+	mu    sync.RWMutex
+	links map[string]*linkVisits
+}
+
+// visitMap maps short ID to its aggregated visits, raw visits are not kept in memory
+type visitMap struct {
+	shards [shardCount]visitShard
+}
+
+func newVisitMap() *visitMap {
+	m := new(visitMap)
+	for i := range m.shards {
+		m.shards[i].links = make(map[string]*linkVisits)
+	}
+	return m
+}
+
+func (m *visitMap) add(visits ...Visit) {
+	for _, v := range visits {
+		s := &m.shards[shardIndex(v.ID)]
+		s.mu.Lock()
+		lv, ok := s.links[v.ID]
+		if !ok {
+			lv = &linkVisits{daily: make(map[int64]int64)}
+			s.links[v.ID] = lv
+		}
+		lv.total++
+		lv.daily[dayOf(v.At)]++
+		s.mu.Unlock()
+	}
+}
+
+// addDaily adds count visits of ID on given UTC day
+func (m *visitMap) addDaily(id string, day, count int64) {
+	s := &m.shards[shardIndex(id)]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lv, ok := s.links[id]
+	if !ok {
+		lv = &linkVisits{daily: make(map[int64]int64)}
+		s.links[id] = lv
+	}
+	lv.total += count
+	lv.daily[day] += count
+}
+
+// records appends daily visit counts of every ID to recs
+func (m *visitMap) records(recs []logRecord) []logRecord {
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.mu.RLock()
+		for id, lv := range s.links {
+			for day, count := range lv.daily {
+				recs = append(recs, logRecord{Op: opVisits, ID: id, Day: day, Count: count})
+			}
+		}
+		s.mu.RUnlock()
+	}
+	return recs
+}
+
+func (m *visitMap) stats(id string) Stats {
+	s := &m.shards[shardIndex(id)]
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	lv, ok := s.links[id]
+	if !ok {
+		return Stats{}
+	}
+	stats := Stats{Total: lv.total, Daily: make([]DailyVisits, 0, len(lv.daily))}
+	for day, count := range lv.daily {
+		stats.Daily = append(stats.Daily, DailyVisits{Day: time.Unix(day*secondsPerDay, 0).UTC(), Count: count})
+	}
+	sort.Slice(stats.Daily, func(i, j int) bool {
+		return stats.Daily[i].Day.Before(stats.Daily[j].Day)
+	})
+	return stats
+}
+
+func (m *visitMap) reset(id string) {
+	s := &m.shards[shardIndex(id)]
+	s.mu.Lock()
+	delete(s.links, id)
+	s.mu.Unlock()
+}
+
+const secondsPerDay = 24 * 60 * 60
+
+// dayOf returns number of UTC day since Unix epoch
+func dayOf(t time.Time) int64 {
+	sec := t.Unix()
+	day := sec / secondsPerDay
+	if sec < 0 && sec%secondsPerDay != 0 {
+		day--
+	}
+	return day
+}
+
+// visitEntry is a line of FileStore visits journal
+type visitEntry struct {
+	Visit
+	// Reset drops visits of purged ID recorded so far
+	Reset bool `json:",omitempty"`
+	// Epoch is set by the first line only, it is a generation of journal
+	Epoch uint64 `json:",omitempty"`
+}
+
+// visitJournal is an append-only JSON lines file of raw visits written since the last snapshot.
+// Snapshot folds visits into daily counts and starts new journal generation,
+// so entries of older generation left by a crash are known to be in snapshot already.
+type visitJournal struct {
+	mu   sync.Mutex
+	fd   *os.File
+	size int64
+	// base is a size of journal without entries, i.e. of its epoch line
+	base int64
+}
+
+func openVisitJournal(filepath string) (*visitJournal, error) {
+	fd, err := os.OpenFile(filepath, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open file at path %s: %w", filepath, err)
+	}
+	return &visitJournal{fd: fd}, nil
+}
+
+// replay feeds entries of given generation to apply, torn tail left by a crash is dropped.
+// Journal of another generation is reset as its entries are already in snapshot.
+func (j *visitJournal) replay(epoch uint64, apply func(e visitEntry), logger *zap.Logger) error {
+	if _, err := j.fd.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("cannot rewind visits journal: %w", err)
+	}
+
+	rd := bufio.NewReader(j.fd)
+	var offset, base int64
+	var journalEpoch uint64
+	for {
+		line, err := rd.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				logger.Warn("dropping torn visit", zap.String("file", j.fd.Name()), zap.Int64("offset", offset))
+			}
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("cannot read visits journal: %w", err)
+		}
+
+		var e visitEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			logger.Warn("dropping corrupted visits tail", zap.String("file", j.fd.Name()), zap.Int64("offset", offset))
+			break
+		}
+		first := offset == 0
+		offset += int64(len(line))
+		if first && e.Epoch > 0 {
+			journalEpoch, base = e.Epoch, offset
+			continue
+		}
+		if journalEpoch == epoch {
+			apply(e)
+		}
+	}
+
+	if journalEpoch != epoch {
+		logger.Info("dropping snapshotted visits", zap.String("file", j.fd.Name()),
+			zap.Uint64("epoch", journalEpoch), zap.Uint64("snapshot_epoch", epoch))
+		return j.reset(epoch)
+	}
+
+	if err := j.fd.Truncate(offset); err != nil {
+		return fmt.Errorf("cannot truncate visits journal at offset %d: %w", offset, err)
+	}
+	if _, err := j.fd.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("cannot seek to visits journal end: %w", err)
+	}
+	j.size, j.base = offset, base
+	return nil
+}
+
+// reset drops all entries and starts journal of given generation, it must be called with j.mu held
+func (j *visitJournal) reset(epoch uint64) error {
+	if err := j.fd.Truncate(0); err != nil {
+		return fmt.Errorf("cannot truncate visits journal: %w", err)
+	}
+	if _, err := j.fd.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("cannot rewind visits journal: %w", err)
+	}
+	j.size, j.base = 0, 0
+	if epoch > 0 {
+		b, err := json.Marshal(struct{ Epoch uint64 }{epoch})
+		if err != nil {
+			return fmt.Errorf("cannot marshal visits epoch: %w", err)
+		}
+		b = append(b, '\n')
+		if _, err := j.fd.Write(b); err != nil {
+			return fmt.Errorf("cannot write visits epoch: %w", err)
+		}
+		j.size, j.base = int64(len(b)), int64(len(b))
+	}
+	if err := j.fd.Sync(); err != nil {
+		return fmt.Errorf("cannot sync visits journal: %w", err)
+	}
+	return nil
+}
+
+// dropHead atomically replaces journal with one of given generation holding entries written after offset,
+// it must be called with j.mu held
+func (j *visitJournal) dropHead(offset int64, epoch uint64) error {
+	if offset == j.size {
+		return j.reset(epoch)
+	}
+
+	b, err := json.Marshal(struct{ Epoch uint64 }{epoch})
+	if err != nil {
+		return fmt.Errorf("cannot marshal visits epoch: %w", err)
+	}
+	b = append(b, '\n')
+	data := make([]byte, len(b)+int(j.size-offset))
+	copy(data, b)
+	if _, err := j.fd.ReadAt(data[len(b):], offset); err != nil {
+		return fmt.Errorf("cannot read visits journal tail: %w", err)
+	}
+
+	fd, err := replaceFile(j.fd.Name(), data)
+	if err != nil {
+		return fmt.Errorf("cannot replace visits journal: %w", err)
+	}
+	_ = j.fd.Close()
+	j.fd, j.size, j.base = fd, int64(len(data)), int64(len(b))
+	return nil
+}
+
+// append writes entries without fsync, losing a few visits on crash is acceptable.
+// apply is called with entries written, so they reach state before journal may be reset.
+// Journal size after write is returned.
+func (j *visitJournal) append(apply func(), entries ...visitEntry) (size int64, err error) {
+	var buf []byte
+	for _, e := range entries {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return 0, fmt.Errorf("cannot marshal visit: %w", err)
+		}
+		buf = append(buf, b...)
+		buf = append(buf, '\n')
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, err := j.fd.Write(buf); err != nil {
+		// drop partially written line so next appends stay readable
+		if terr := j.fd.Truncate(j.size); terr == nil {
+			_, _ = j.fd.Seek(j.size, io.SeekStart)
+		}
+		return 0, fmt.Errorf("cannot write visits: %w", err)
+	}
+	j.size += int64(len(buf))
+	if apply != nil {
+		apply()
+	}
+	return j.size, nil
+}
+
+func (j *visitJournal) close() error {
+	if err := j.fd.Sync(); err != nil {
+		return fmt.Errorf("cannot sync visits journal: %w", err)
+	}
+	return j.fd.Close()
+}
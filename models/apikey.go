@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+)
+
+type APIKeyRequest struct {
+	Name string `json:"name"`
+}
+
+type APIKeyResponse struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Key is a bearer token, it is returned only once on creation
+	Key       string    `json:"key,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,25 @@
+package models
+
+// Problem is a body of failed API request following RFC 7807
+type Problem struct {
+	// Type is always "about:blank", Code tells what went wrong instead
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	// Detail is a message explaining this occurrence of the problem
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	Code      string `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+	// Errors point to parts of request which caused the problem
+	Errors []ProblemDetail `json:"errors,omitempty"`
+}
+
+// ProblemDetail is a problem with single part of request
+type ProblemDetail struct {
+	// Index is a position of item in batch request
+	Index         *int   `json:"index,omitempty"`
+	CorrelationID string `json:"correlation_id,omitempty"`
+	Field         string `json:"field,omitempty"`
+	Message       string `json:"message"`
+}
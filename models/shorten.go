@@ -1,7 +1,15 @@
 package models
 
+import (
+	"time"
+)
+
 type ShortenRequest struct {
-	URL string `json:"url"`
+	URL   string `json:"url"`
+	Alias string `json:"alias,omitempty"`
+	// TTL is a link lifetime in seconds
+	TTL       int64      `json:"ttl,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
 }
 
 type ShortenResponse struct {
@@ -14,11 +22,35 @@ type URLResponse struct {
 }
 
 type BatchShortenRequest struct {
-	CorrelationID string `json:"correlation_id"`
-	OriginalURL   string `json:"original_url"`
+	CorrelationID string     `json:"correlation_id"`
+	OriginalURL   string     `json:"original_url"`
+	Alias         string     `json:"alias,omitempty"`
+	TTL           int64      `json:"ttl,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
 }
 
 type BatchShortenResponse struct {
 	CorrelationID string `json:"correlation_id"`
 	ShortURL      string `json:"short_url"`
 }
+
+// BatchShortenResult is an outcome of single item of batch shortened in partial mode
+type BatchShortenResult struct {
+	CorrelationID string `json:"correlation_id"`
+	// Status is one of "created", "existing", "conflict", "invalid" and "error"
+	Status   string         `json:"status"`
+	ShortURL string         `json:"short_url,omitempty"`
+	Error    *ProblemDetail `json:"error,omitempty"`
+}
+
+type LinkStatsResponse struct {
+	ShortURL string        `json:"short_url"`
+	Total    int64         `json:"total"`
+	Daily    []DailyVisits `json:"daily"`
+}
+
+type DailyVisits struct {
+	// Date is a UTC day formatted as YYYY-MM-DD
+	Date   string `json:"date"`
+	Visits int64  `json:"visits"`
+}
@@ -0,0 +1,36 @@
+#!/bin/sh
+# Runs benchmarks of every package and writes benchstat compatible output.
+#
+#   scripts/bench.sh [output file]
+#
+# Settings are taken from environment:
+#   BENCH_PATTERN  benchmarks to run, all by default
+#   BENCH_COUNT    runs of every benchmark, benchstat needs several to estimate variance
+#   BENCH_CPU      GOMAXPROCS values to run parallel benchmarks with
+#   BENCH_TIME     duration or iterations count of every run
+#   BENCH_BASE     previous output to compare the new one with using benchstat
+set -eu
+
+cd "$(dirname "$0")/.."
+
+out=${1:-bench_output.txt}
+pattern=${BENCH_PATTERN:-.}
+count=${BENCH_COUNT:-6}
+cpu=${BENCH_CPU:-1,4}
+benchtime=${BENCH_TIME:-1s}
+
+{
+	# configuration lines label results in benchstat
+	echo "commit: $(git rev-parse --short HEAD 2>/dev/null || echo unknown)"
+	echo "go: $(go env GOVERSION)"
+	go test -run '^$' -bench "$pattern" -benchmem -count "$count" -cpu "$cpu" -benchtime "$benchtime" ./...
+} | tee "$out"
+
+if [ -n "${BENCH_BASE:-}" ]; then
+	if command -v benchstat >/dev/null 2>&1; then
+		benchstat "$BENCH_BASE" "$out"
+	else
+		echo "benchstat is not installed: go install golang.org/x/perf/cmd/benchstat@latest" >&2
+		exit 1
+	fi
+fi